@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/fmyxyz/goreptile/base"
 	"github.com/fmyxyz/goreptile/middleware"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -38,6 +39,11 @@ func (this *myAnalyzer) Id() uint32 {
 
 var logger = log.New(os.Stdout, "analyzer", log.LstdFlags)
 
+//设置日志的输出位置，默认为标准输出
+func SetLogOutput(w io.Writer) {
+	logger.SetOutput(w)
+}
+
 func (this *myAnalyzer) Analyze(respParsers []ParseResponse, resp base.Response) ([]base.Data, []error) {
 	if respParsers == nil {
 		err := errors.New("The response parser list is invaild!")
@@ -50,6 +56,10 @@ func (this *myAnalyzer) Analyze(respParsers []ParseResponse, resp base.Response)
 	}
 	var reqUrl = httpResp.Request.URL
 	logger.Printf("Parser the response (reqUrl=%s)...\n", reqUrl)
+	//缓冲响应体，使每个解析器都能从头读取
+	if err := resp.Buffer(0); err != nil {
+		return nil, []error{err}
+	}
 	var dataList = make([]base.Data, 0)
 	var errorList = make([]error, 0)
 	var respDepth = resp.Depth()
@@ -59,6 +69,7 @@ func (this *myAnalyzer) Analyze(respParsers []ParseResponse, resp base.Response)
 			errorList = append(errorList, err)
 			continue
 		}
+		httpResp.Body = resp.BodyReader()
 		pDataList, pErrorList := respParser(httpResp, respDepth)
 		if pDataList != nil {
 			for _, pData := range pDataList {
@@ -85,7 +96,7 @@ func appendDataList(dataList []base.Data, data base.Data, respDepth uint32) []ba
 	}
 	newDepth := respDepth + 1
 	if req.Depth() != newDepth {
-		req = base.NewRequest(req.HttpReq(), newDepth)
+		req = req.CopyWithDepth(newDepth)
 	}
 	return append(dataList, req)
 }
@@ -0,0 +1,463 @@
+package analyzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"golang.org/x/net/html/charset"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//订阅源的格式
+const (
+	FORMAT_RSS       = "rss"
+	FORMAT_ATOM      = "atom"
+	FORMAT_JSON_FEED = "jsonfeed"
+)
+
+//订阅源条目的键，另有 ITEM_KEY_FORMAT、ITEM_KEY_URL（订阅源的URL）和 ITEM_KEY_ID
+const (
+	ITEM_KEY_FEED       = "feed"
+	ITEM_KEY_TITLE      = "title"
+	ITEM_KEY_LINK       = "link"
+	ITEM_KEY_SUMMARY    = "summary"
+	ITEM_KEY_CONTENT    = "content"
+	ITEM_KEY_AUTHOR     = "author"
+	ITEM_KEY_CATEGORIES = "categories"
+	ITEM_KEY_PUBLISHED  = "published"
+	ITEM_KEY_UPDATED    = "updated"
+)
+
+//订阅源
+type Feed struct {
+	Format  string
+	Title   string
+	Link    string
+	Entries []FeedEntry
+}
+
+//订阅源中的条目，链接已按订阅源的URL转换为绝对URL
+//Id 为空时依次使用链接、标题和发布时间
+type FeedEntry struct {
+	Id         string
+	Title      string
+	Link       string
+	Summary    string
+	Content    string
+	Author     string
+	Categories []string
+	Published  time.Time //无法解析时为零值
+	Updated    time.Time //无法解析时为零值
+}
+
+//转换为条目，feedUrl 为订阅源的URL
+func (this *FeedEntry) Item(feed *Feed, feedUrl string) base.Item {
+	item := base.Item{
+		ITEM_KEY_FORMAT: feed.Format,
+		ITEM_KEY_URL:    feedUrl,
+		ITEM_KEY_FEED:   feed.Title,
+		ITEM_KEY_ID:     this.Id,
+		ITEM_KEY_TITLE:  this.Title,
+		ITEM_KEY_LINK:   this.Link,
+	}
+	if this.Summary != "" {
+		item[ITEM_KEY_SUMMARY] = this.Summary
+	}
+	if this.Content != "" {
+		item[ITEM_KEY_CONTENT] = this.Content
+	}
+	if this.Author != "" {
+		item[ITEM_KEY_AUTHOR] = this.Author
+	}
+	if len(this.Categories) > 0 {
+		item[ITEM_KEY_CATEGORIES] = this.Categories
+	}
+	if !this.Published.IsZero() {
+		item[ITEM_KEY_PUBLISHED] = this.Published.Format(time.RFC3339)
+	}
+	if !this.Updated.IsZero() {
+		item[ITEM_KEY_UPDATED] = this.Updated.Format(time.RFC3339)
+	}
+	return item
+}
+
+//解析订阅源的响应，每个条目产生一个条目和一个对条目链接的请求
+//条目链接的请求继承订阅源请求的元数据
+func ParseFeed(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
+	if httpResp.Request == nil || httpResp.Request.URL == nil {
+		return nil, []error{errors.New("The request of http response is invalid!")}
+	}
+	body, err := readBody(httpResp)
+	if err != nil {
+		return nil, []error{err}
+	}
+	reqUrl := httpResp.Request.URL
+	feed, err := ParseFeedDocument(body, httpResp.Header.Get("Content-Type"), reqUrl)
+	if err != nil {
+		return nil, []error{err}
+	}
+	meta := base.RequestMeta(httpResp.Request)
+	var dataList []base.Data
+	var errs []error
+	for i := range feed.Entries {
+		entry := &feed.Entries[i]
+		dataList = append(dataList, entry.Item(feed, reqUrl.String()))
+		if entry.Link == "" {
+			continue
+		}
+		httpReq, err := http.NewRequest(http.MethodGet, entry.Link, nil)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		req := base.NewRequest(httpReq, respDepth)
+		if meta != nil {
+			req.SetMetaMap(meta)
+		}
+		dataList = append(dataList, req)
+	}
+	return dataList, errs
+}
+
+//解析 RSS 2.0（以及 RSS 1.0）、Atom 或 JSON Feed 文档
+//contentType 用于判断XML文档的编码，baseUrl 用于把相对链接转换为绝对URL，可以为nil
+func ParseFeedDocument(body []byte, contentType string, baseUrl *url.URL) (*Feed, error) {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(body, []byte{0xef, 0xbb, 0xbf}))
+	var feed *Feed
+	var err error
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		feed, err = parseJsonFeed(trimmed)
+	} else {
+		feed, err = parseXmlFeed(body, contentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	feed.Link = resolveLink(baseUrl, feed.Link)
+	for i := range feed.Entries {
+		entry := &feed.Entries[i]
+		entry.Link = resolveLink(baseUrl, entry.Link)
+		if entry.Id == "" {
+			entry.Id = entry.Link
+		}
+		if entry.Id == "" && entry.Title != "" {
+			entry.Id = entry.Title
+			if !entry.Published.IsZero() {
+				entry.Id += "@" + entry.Published.Format(time.RFC3339)
+			}
+		}
+	}
+	return feed, nil
+}
+
+func resolveLink(baseUrl *url.URL, link string) string {
+	link = strings.TrimSpace(link)
+	if link == "" || baseUrl == nil {
+		return link
+	}
+	resolved, err := baseUrl.Parse(link)
+	if err != nil {
+		return link
+	}
+	return resolved.String()
+}
+
+//订阅源中常见的时间格式
+var feedTimeLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC822Z,
+	time.RFC822,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func parseFeedTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+//XML元素的文本，Atom 中 type 为 xhtml 时取元素内部的XML
+type feedText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+func (this *feedText) value() string {
+	if this.Type == "xhtml" {
+		return strings.TrimSpace(this.Inner)
+	}
+	return strings.TrimSpace(this.Text)
+}
+
+type feedLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Text string `xml:",chardata"`
+}
+
+//选择链接：RSS 取 <link> 的文本，Atom 取 rel 为空或 alternate 的 href
+func pickLink(links []feedLink) string {
+	for _, link := range links {
+		if text := strings.TrimSpace(link.Text); text != "" {
+			return text
+		}
+	}
+	for _, link := range links {
+		if link.Href != "" && (link.Rel == "" || link.Rel == "alternate") {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+type rssItem struct {
+	Title       feedText   `xml:"title"`
+	Links       []feedLink `xml:"link"`
+	Description feedText   `xml:"description"`
+	Content     feedText   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Guid        string     `xml:"guid"`
+	About       string     `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	PubDate     string     `xml:"pubDate"`
+	Date        string     `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Author      string     `xml:"author"`
+	Creator     string     `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Categories  []string   `xml:"category"`
+}
+
+type rssDocument struct {
+	Channel struct {
+		Title feedText   `xml:"title"`
+		Links []feedLink `xml:"link"`
+		Items []rssItem  `xml:"item"`
+	} `xml:"channel"`
+	Items []rssItem `xml:"item"` //RSS 1.0 的条目与 channel 同级
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	Id         string         `xml:"id"`
+	Title      feedText       `xml:"title"`
+	Links      []feedLink     `xml:"link"`
+	Summary    feedText       `xml:"summary"`
+	Content    feedText       `xml:"content"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomPerson   `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomDocument struct {
+	Title   feedText    `xml:"title"`
+	Links   []feedLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+//XML声明中指定了编码时按声明的编码解码，否则按 contentType 中的字符集解码
+func newXmlDecoder(body []byte, contentType string) *xml.Decoder {
+	var input io.Reader = bytes.NewReader(body)
+	if !declaresEncoding(body) {
+		if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+			if reader, err := charset.NewReaderLabel(params["charset"], input); err == nil {
+				input = reader
+			}
+		}
+	}
+	decoder := xml.NewDecoder(input)
+	decoder.Strict = false
+	decoder.CharsetReader = charset.NewReaderLabel
+	return decoder
+}
+
+//判断XML文档是否在XML声明中指定了编码
+func declaresEncoding(body []byte) bool {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(body, []byte{0xef, 0xbb, 0xbf}))
+	if !bytes.HasPrefix(trimmed, []byte("<?xml")) {
+		return false
+	}
+	end := bytes.Index(trimmed, []byte("?>"))
+	return end > 0 && bytes.Contains(trimmed[:end], []byte("encoding"))
+}
+
+func parseXmlFeed(body []byte, contentType string) (*Feed, error) {
+	decoder := newXmlDecoder(body, contentType)
+	var root xml.StartElement
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("The feed document is invalid: %s", err))
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			root = start
+			break
+		}
+	}
+	switch strings.ToLower(root.Name.Local) {
+	case "rss", "rdf":
+		var doc rssDocument
+		if err := decoder.DecodeElement(&doc, &root); err != nil {
+			return nil, errors.New(fmt.Sprintf("The rss document is invalid: %s", err))
+		}
+		feed := &Feed{Format: FORMAT_RSS, Title: doc.Channel.Title.value(), Link: pickLink(doc.Channel.Links)}
+		for _, item := range append(doc.Channel.Items, doc.Items...) {
+			feed.Entries = append(feed.Entries, item.entry())
+		}
+		return feed, nil
+	case "feed":
+		var doc atomDocument
+		if err := decoder.DecodeElement(&doc, &root); err != nil {
+			return nil, errors.New(fmt.Sprintf("The atom document is invalid: %s", err))
+		}
+		feed := &Feed{Format: FORMAT_ATOM, Title: doc.Title.value(), Link: pickLink(doc.Links)}
+		for _, entry := range doc.Entries {
+			feed.Entries = append(feed.Entries, entry.entry())
+		}
+		return feed, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unsupported feed root element <%s>!", root.Name.Local))
+}
+
+func (this *rssItem) entry() FeedEntry {
+	entry := FeedEntry{
+		Id:         strings.TrimSpace(this.Guid),
+		Title:      this.Title.value(),
+		Link:       pickLink(this.Links),
+		Summary:    this.Description.value(),
+		Content:    this.Content.value(),
+		Author:     strings.TrimSpace(this.Author),
+		Categories: this.Categories,
+		Published:  parseFeedTime(this.PubDate),
+	}
+	if entry.Id == "" {
+		entry.Id = strings.TrimSpace(this.About)
+	}
+	if entry.Author == "" {
+		entry.Author = strings.TrimSpace(this.Creator)
+	}
+	if entry.Published.IsZero() {
+		entry.Published = parseFeedTime(this.Date)
+	}
+	return entry
+}
+
+func (this *atomEntry) entry() FeedEntry {
+	entry := FeedEntry{
+		Id:        strings.TrimSpace(this.Id),
+		Title:     this.Title.value(),
+		Link:      pickLink(this.Links),
+		Summary:   this.Summary.value(),
+		Content:   this.Content.value(),
+		Published: parseFeedTime(this.Published),
+		Updated:   parseFeedTime(this.Updated),
+	}
+	var authors []string
+	for _, author := range this.Authors {
+		if name := strings.TrimSpace(author.Name); name != "" {
+			authors = append(authors, name)
+		}
+	}
+	entry.Author = strings.Join(authors, ", ")
+	for _, category := range this.Categories {
+		if category.Term != "" {
+			entry.Categories = append(entry.Categories, category.Term)
+		}
+	}
+	if entry.Published.IsZero() {
+		entry.Published = entry.Updated
+	}
+	return entry
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedDocument struct {
+	Version     string `json:"version"`
+	Title       string `json:"title"`
+	HomePageUrl string `json:"home_page_url"`
+	Items       []struct {
+		Id            interface{}      `json:"id"`
+		Url           string           `json:"url"`
+		ExternalUrl   string           `json:"external_url"`
+		Title         string           `json:"title"`
+		ContentHtml   string           `json:"content_html"`
+		ContentText   string           `json:"content_text"`
+		Summary       string           `json:"summary"`
+		DatePublished string           `json:"date_published"`
+		DateModified  string           `json:"date_modified"`
+		Author        *jsonFeedAuthor  `json:"author"`
+		Authors       []jsonFeedAuthor `json:"authors"`
+		Tags          []string         `json:"tags"`
+	} `json:"items"`
+}
+
+func parseJsonFeed(body []byte) (*Feed, error) {
+	var doc jsonFeedDocument
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, errors.New(fmt.Sprintf("The json feed document is invalid: %s", err))
+	}
+	if !strings.Contains(doc.Version, "jsonfeed.org") {
+		return nil, errors.New(fmt.Sprintf("Unsupported json feed version %q!", doc.Version))
+	}
+	feed := &Feed{Format: FORMAT_JSON_FEED, Title: doc.Title, Link: doc.HomePageUrl}
+	for _, item := range doc.Items {
+		entry := FeedEntry{
+			Title:      item.Title,
+			Link:       item.Url,
+			Summary:    item.Summary,
+			Content:    item.ContentHtml,
+			Categories: item.Tags,
+			Published:  parseFeedTime(item.DatePublished),
+			Updated:    parseFeedTime(item.DateModified),
+		}
+		if item.Id != nil {
+			entry.Id = fmt.Sprint(item.Id)
+		}
+		if entry.Link == "" {
+			entry.Link = item.ExternalUrl
+		}
+		if entry.Content == "" {
+			entry.Content = item.ContentText
+		}
+		authors := item.Authors
+		if item.Author != nil {
+			authors = append(authors, *item.Author)
+		}
+		var names []string
+		for _, author := range authors {
+			if author.Name != "" {
+				names = append(names, author.Name)
+			}
+		}
+		entry.Author = strings.Join(names, ", ")
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed, nil
+}
@@ -0,0 +1,329 @@
+package analyzer
+
+import (
+	"bytes"
+	"github.com/fmyxyz/goreptile/base"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func feedTime(t *testing.T, value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestParseFeedDocument(t *testing.T) {
+	baseUrl, _ := url.Parse("http://example.com/feeds/main.xml")
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		want        Feed
+	}{
+		{
+			name: "rss 2.0",
+			body: `<?xml version="1.0"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel>
+  <title>Example News</title>
+  <link>http://example.com/</link>
+  <item>
+    <title>First</title>
+    <link>/posts/1</link>
+    <guid isPermaLink="false">post-1</guid>
+    <description>Summary &amp; more</description>
+    <content:encoded><![CDATA[<p>Full text</p>]]></content:encoded>
+    <author>alice@example.com</author>
+    <category>go</category>
+    <category>crawler</category>
+    <pubDate>Mon, 02 Jan 2006 15:04:05 GMT</pubDate>
+  </item>
+  <item>
+    <title>Second</title>
+    <link>http://other.example.com/2</link>
+    <dc:creator>Bob</dc:creator>
+    <dc:date>2006-01-03T10:00:00Z</dc:date>
+  </item>
+  <item>
+    <title>No link</title>
+    <pubDate>Tue, 3 Jan 2006 08:00:00 +0000</pubDate>
+  </item>
+</channel>
+</rss>`,
+			want: Feed{
+				Format: FORMAT_RSS,
+				Title:  "Example News",
+				Link:   "http://example.com/",
+				Entries: []FeedEntry{
+					{
+						Id:         "post-1",
+						Title:      "First",
+						Link:       "http://example.com/posts/1",
+						Summary:    "Summary & more",
+						Content:    "<p>Full text</p>",
+						Author:     "alice@example.com",
+						Categories: []string{"go", "crawler"},
+						Published:  feedTime(t, "2006-01-02T15:04:05Z"),
+					},
+					{
+						Id:        "http://other.example.com/2",
+						Title:     "Second",
+						Link:      "http://other.example.com/2",
+						Author:    "Bob",
+						Published: feedTime(t, "2006-01-03T10:00:00Z"),
+					},
+					{
+						Id:        "No link@2006-01-03T08:00:00Z",
+						Title:     "No link",
+						Published: feedTime(t, "2006-01-03T08:00:00Z"),
+					},
+				},
+			},
+		},
+		{
+			name: "rss 1.0",
+			body: `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/">
+  <channel><title>RDF Feed</title><link>http://example.com/</link></channel>
+  <item rdf:about="http://example.com/rdf/1">
+    <title>RDF item</title>
+    <link>http://example.com/rdf/1</link>
+  </item>
+</rdf:RDF>`,
+			want: Feed{
+				Format: FORMAT_RSS,
+				Title:  "RDF Feed",
+				Link:   "http://example.com/",
+				Entries: []FeedEntry{
+					{Id: "http://example.com/rdf/1", Title: "RDF item", Link: "http://example.com/rdf/1"},
+				},
+			},
+		},
+		{
+			name: "atom",
+			body: `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title type="text">Example Atom</title>
+  <link rel="self" href="http://example.com/feeds/atom.xml"/>
+  <link href="http://example.com/"/>
+  <entry>
+    <id>urn:uuid:1225c695</id>
+    <title>Atom entry</title>
+    <link rel="edit" href="/edit/1"/>
+    <link rel="alternate" href="../atom/1"/>
+    <summary>Short</summary>
+    <content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>Rich</p></div></content>
+    <author><name>Alice</name></author>
+    <author><name>Bob</name></author>
+    <category term="news"/>
+    <updated>2006-01-02T15:04:05+08:00</updated>
+  </entry>
+</feed>`,
+			want: Feed{
+				Format: FORMAT_ATOM,
+				Title:  "Example Atom",
+				Link:   "http://example.com/",
+				Entries: []FeedEntry{
+					{
+						Id:         "urn:uuid:1225c695",
+						Title:      "Atom entry",
+						Link:       "http://example.com/atom/1",
+						Summary:    "Short",
+						Content:    `<div xmlns="http://www.w3.org/1999/xhtml"><p>Rich</p></div>`,
+						Author:     "Alice, Bob",
+						Categories: []string{"news"},
+						Published:  feedTime(t, "2006-01-02T15:04:05+08:00"),
+						Updated:    feedTime(t, "2006-01-02T15:04:05+08:00"),
+					},
+				},
+			},
+		},
+		{
+			name: "json feed",
+			body: "\xef\xbb\xbf" + `{
+  "version": "https://jsonfeed.org/version/1.1",
+  "title": "Example JSON",
+  "home_page_url": "http://example.com/",
+  "items": [
+    {"id": 42, "url": "/json/42", "title": "Numeric id", "content_text": "Text", "summary": "Sum",
+     "date_published": "2006-01-02T15:04:05Z", "authors": [{"name": "Alice"}], "tags": ["a", "b"]},
+    {"id": "x", "external_url": "http://elsewhere.example.com/x", "content_html": "<b>Html</b>",
+     "content_text": "ignored", "author": {"name": "Bob"}}
+  ]
+}`,
+			want: Feed{
+				Format: FORMAT_JSON_FEED,
+				Title:  "Example JSON",
+				Link:   "http://example.com/",
+				Entries: []FeedEntry{
+					{
+						Id:         "42",
+						Title:      "Numeric id",
+						Link:       "http://example.com/json/42",
+						Summary:    "Sum",
+						Content:    "Text",
+						Author:     "Alice",
+						Categories: []string{"a", "b"},
+						Published:  feedTime(t, "2006-01-02T15:04:05Z"),
+					},
+					{
+						Id:      "x",
+						Link:    "http://elsewhere.example.com/x",
+						Content: "<b>Html</b>",
+						Author:  "Bob",
+					},
+				},
+			},
+		},
+		{
+			name:        "charset from xml declaration",
+			body:        "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><rss><channel><title>Caf\xe9</title></channel></rss>",
+			contentType: "application/rss+xml; charset=utf-8",
+			want:        Feed{Format: FORMAT_RSS, Title: "Café"},
+		},
+		{
+			name:        "charset from content type",
+			body:        "<rss><channel><title>Caf\xe9</title><item><guid>1</guid><title>\xe9t\xe9</title></item></channel></rss>",
+			contentType: "application/rss+xml; charset=ISO-8859-1",
+			want: Feed{
+				Format:  FORMAT_RSS,
+				Title:   "Café",
+				Entries: []FeedEntry{{Id: "1", Title: "été"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			feed, err := ParseFeedDocument([]byte(test.body), test.contentType, baseUrl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if feed.Format != test.want.Format || feed.Title != test.want.Title || feed.Link != test.want.Link {
+				t.Fatalf("feed is %s %q %q, want %s %q %q", feed.Format, feed.Title, feed.Link,
+					test.want.Format, test.want.Title, test.want.Link)
+			}
+			if len(feed.Entries) != len(test.want.Entries) {
+				t.Fatalf("%d entries, want %d", len(feed.Entries), len(test.want.Entries))
+			}
+			for i, entry := range feed.Entries {
+				want := test.want.Entries[i]
+				if !entry.Published.Equal(want.Published) || !entry.Updated.Equal(want.Updated) {
+					t.Fatalf("entry %d published %s updated %s, want %s and %s", i, entry.Published, entry.Updated, want.Published, want.Updated)
+				}
+				entry.Published, entry.Updated = want.Published, want.Updated
+				if !reflect.DeepEqual(entry, want) {
+					t.Fatalf("entry %d is %+v, want %+v", i, entry, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFeedDocumentInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"not a feed",
+		"<html><body>page</body></html>",
+		`{"version": "1.0", "items": []}`,
+		`{"version": "https://jsonfeed.org/version/1", "items": [}`,
+		"<rss><channel><item><title>broken",
+	}
+	for _, body := range tests {
+		if feed, err := ParseFeedDocument([]byte(body), "", nil); err == nil {
+			t.Errorf("ParseFeedDocument(%q) = %+v, want an error", body, feed)
+		}
+	}
+}
+
+func TestParseFeedTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string //RFC3339，空字符串表示无法解析
+	}{
+		{"2006-01-02T15:04:05Z", "2006-01-02T15:04:05Z"},
+		{"2006-01-02T15:04:05.999+08:00", "2006-01-02T15:04:05.999+08:00"},
+		{"Mon, 02 Jan 2006 15:04:05 -0700", "2006-01-02T15:04:05-07:00"},
+		{"Mon, 2 Jan 2006 15:04:05 -0700", "2006-01-02T15:04:05-07:00"},
+		{"2 Jan 2006 15:04:05 -0700", "2006-01-02T15:04:05-07:00"},
+		{"02 Jan 06 15:04 -0700", "2006-01-02T15:04:00-07:00"},
+		{" 2006-01-02 15:04:05 ", "2006-01-02T15:04:05Z"},
+		{"2006-01-02", "2006-01-02T00:00:00Z"},
+		{"yesterday", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		got := parseFeedTime(test.value)
+		if test.want == "" {
+			if !got.IsZero() {
+				t.Errorf("parseFeedTime(%q) = %s, want zero", test.value, got)
+			}
+			continue
+		}
+		if want := feedTime(t, test.want); !got.Equal(want) {
+			t.Errorf("parseFeedTime(%q) = %s, want %s", test.value, got, want)
+		}
+	}
+}
+
+func TestParseFeed(t *testing.T) {
+	body := `<rss><channel><title>News</title>
+<item><guid>1</guid><title>One</title><link>/1</link></item>
+<item><guid>2</guid><title>Two</title></item>
+</channel></rss>`
+	httpReq, err := http.NewRequest(http.MethodGet, "http://example.com/rss", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := base.NewRequest(httpReq, 1)
+	req.SetMetaMap(map[string]interface{}{"site": "example"})
+	httpResp := &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"application/rss+xml"}},
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte(body))),
+		Request:    req.HttpReq(),
+	}
+	dataList, errs := ParseFeed(httpResp, 2)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	var items []base.Item
+	var reqs []*base.Request
+	for _, data := range dataList {
+		switch d := data.(type) {
+		case base.Item:
+			items = append(items, d)
+		case *base.Request:
+			reqs = append(reqs, d)
+		default:
+			t.Fatalf("unexpected data %T", data)
+		}
+	}
+	if len(items) != 2 || len(reqs) != 1 {
+		t.Fatalf("%d items and %d requests, want 2 and 1", len(items), len(reqs))
+	}
+	wantItem := base.Item{
+		ITEM_KEY_FORMAT: FORMAT_RSS,
+		ITEM_KEY_URL:    "http://example.com/rss",
+		ITEM_KEY_FEED:   "News",
+		ITEM_KEY_ID:     "1",
+		ITEM_KEY_TITLE:  "One",
+		ITEM_KEY_LINK:   "http://example.com/1",
+	}
+	if !reflect.DeepEqual(items[0], wantItem) {
+		t.Fatalf("item is %v, want %v", items[0], wantItem)
+	}
+	//条目链接的请求继承订阅源请求的元数据
+	linkReq := reqs[0]
+	if linkReq.HttpReq().URL.String() != "http://example.com/1" || linkReq.Depth() != 2 {
+		t.Fatalf("request is %s at depth %d", linkReq.HttpReq().URL, linkReq.Depth())
+	}
+	if site, _ := linkReq.Meta("site"); site != "example" {
+		t.Fatalf("request meta is %v", linkReq.MetaMap())
+	}
+}
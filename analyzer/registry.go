@@ -0,0 +1,114 @@
+package analyzer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//读出响应体，以便多个解析函数重复读取
+func readBody(httpResp *http.Response) ([]byte, error) {
+	if httpResp.Body == nil {
+		return nil, nil
+	}
+	defer httpResp.Body.Close()
+	return ioutil.ReadAll(httpResp.Body)
+}
+
+//依次执行解析函数，每个解析函数都会得到一个从头读取的响应体
+func runParsers(parsers []ParseResponse, httpResp *http.Response, respDepth uint32, body []byte) ([]base.Data, []error) {
+	var dataList []base.Data
+	var errorList []error
+	for _, parser := range parsers {
+		httpResp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		pDataList, pErrorList := parser(httpResp, respDepth)
+		dataList = append(dataList, pDataList...)
+		errorList = append(errorList, pErrorList...)
+	}
+	return dataList, errorList
+}
+
+//命名解析函数的注册表
+//请求通过 base.Request.SetCallback 指定回调名称后，其响应只交给以该名称注册的解析函数，
+//没有指定回调名称的响应交给默认的解析函数
+type ParserRegistry struct {
+	parsers  map[string]ParseResponse
+	defaults []ParseResponse
+	rwMutex  sync.RWMutex
+}
+
+//创建解析函数注册表，defaults 为默认的解析函数
+func NewParserRegistry(defaults ...ParseResponse) *ParserRegistry {
+	return &ParserRegistry{
+		parsers:  make(map[string]ParseResponse),
+		defaults: defaults,
+	}
+}
+
+//注册命名解析函数，名称不能重复
+func (this *ParserRegistry) Register(name string, parser ParseResponse) error {
+	if name == "" {
+		return errors.New("The parser name is empty!")
+	}
+	if parser == nil {
+		return errors.New(fmt.Sprintf("The parser %s is invalid!", name))
+	}
+	this.rwMutex.Lock()
+	defer this.rwMutex.Unlock()
+	if _, ok := this.parsers[name]; ok {
+		return errors.New(fmt.Sprintf("The parser %s has been registered!", name))
+	}
+	this.parsers[name] = parser
+	return nil
+}
+
+//获取命名解析函数
+func (this *ParserRegistry) Lookup(name string) (ParseResponse, bool) {
+	this.rwMutex.RLock()
+	defer this.rwMutex.RUnlock()
+	parser, ok := this.parsers[name]
+	return parser, ok
+}
+
+//按回调名称解析响应，可以作为一个解析函数交给分析器
+//回调名称没有注册时返回错误
+func (this *ParserRegistry) ParseResponse(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
+	parsers := this.defaults
+	if callback := base.RequestCallback(httpResp.Request); callback != "" {
+		parser, ok := this.Lookup(callback)
+		if !ok {
+			return nil, []error{errors.New(fmt.Sprintf("The callback parser %s is not registered! (reqUrl=%s)", callback, httpResp.Request.URL))}
+		}
+		parsers = []ParseResponse{parser}
+	}
+	if len(parsers) == 1 {
+		return parsers[0](httpResp, respDepth)
+	}
+	body, err := readBody(httpResp)
+	if err != nil {
+		return nil, []error{err}
+	}
+	return runParsers(parsers, httpResp, respDepth, body)
+}
+
+//已注册的解析函数名称
+func (this *ParserRegistry) Names() []string {
+	this.rwMutex.RLock()
+	defer this.rwMutex.RUnlock()
+	names := make([]string, 0, len(this.parsers))
+	for name := range this.parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (this *ParserRegistry) String() string {
+	return fmt.Sprintf("parsers:[%s],defaults:%d", strings.Join(this.Names(), ","), len(this.defaults))
+}
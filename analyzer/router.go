@@ -0,0 +1,174 @@
+package analyzer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+//判断响应是否满足路由条件
+type MatchResponse func(httpResp *http.Response, respDepth uint32) bool
+
+//路由
+//响应同时满足所有非空的条件时匹配该路由
+type Route struct {
+	Name         string
+	Urls         []string        //URL通配符模式，满足任意一个即可
+	UrlPatterns  []string        //URL正则表达式，与 Urls 满足任意一个即可
+	ContentTypes []string        //媒体类型模式，如 text/html、application/*、*/json，满足任意一个即可
+	Match        MatchResponse   //自定义条件，可以检查请求方法、请求头、深度、元数据（base.RequestMeta）等
+	Parsers      []ParseResponse //匹配时使用的解析函数
+}
+
+type compiledRoute struct {
+	name         string
+	urls         urlMatcher
+	contentTypes []string
+	match        MatchResponse
+	parsers      []ParseResponse
+	matched      uint64
+}
+
+//解析器路由，按路由的顺序把响应交给第一个匹配的路由的解析函数
+//没有匹配的路由时使用后备解析函数，后备解析函数也为空时只记录未匹配的次数
+type Router struct {
+	routes    []*compiledRoute
+	fallback  []ParseResponse
+	fallbacks uint64 //使用后备解析函数的次数
+	unmatched uint64 //没有任何解析函数处理的次数
+}
+
+//创建解析器路由
+func NewRouter(fallback []ParseResponse, routes ...Route) (*Router, error) {
+	router := &Router{}
+	for i, parser := range fallback {
+		if parser == nil {
+			return nil, errors.New(fmt.Sprintf("The fallback parser [%d] is invalid!", i))
+		}
+	}
+	router.fallback = fallback
+	names := make(map[string]bool)
+	for i, route := range routes {
+		name := route.Name
+		if name == "" {
+			name = fmt.Sprintf("route%d", i)
+		}
+		if names[name] {
+			return nil, errors.New(fmt.Sprintf("The route name %q is duplicated!", name))
+		}
+		names[name] = true
+		if len(route.Parsers) == 0 {
+			return nil, errors.New(fmt.Sprintf("The parser list of route %s is empty!", name))
+		}
+		for j, parser := range route.Parsers {
+			if parser == nil {
+				return nil, errors.New(fmt.Sprintf("The parser [%d] of route %s is invalid!", j, name))
+			}
+		}
+		urls, err := compileUrlMatcher(route.Urls, route.UrlPatterns)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("route %s: %s", name, err))
+		}
+		for _, contentType := range route.ContentTypes {
+			if _, err := path.Match(contentType, ""); err != nil {
+				return nil, errors.New(fmt.Sprintf("route %s: The content type pattern %q is invalid!", name, contentType))
+			}
+		}
+		router.routes = append(router.routes, &compiledRoute{
+			name:         name,
+			urls:         urls,
+			contentTypes: route.ContentTypes,
+			match:        route.Match,
+			parsers:      route.Parsers,
+		})
+	}
+	return router, nil
+}
+
+//响应的媒体类型，没有 Content-Type 响应头时根据响应体判断
+func mediaType(httpResp *http.Response, body []byte) string {
+	contentType := httpResp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	return mediaType
+}
+
+func (this *compiledRoute) matches(httpResp *http.Response, respDepth uint32, mediaType string) bool {
+	if !this.urls.match(httpResp.Request.URL.String()) {
+		return false
+	}
+	if len(this.contentTypes) > 0 {
+		matched := false
+		for _, pattern := range this.contentTypes {
+			if ok, _ := path.Match(strings.ToLower(pattern), mediaType); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return this.match == nil || this.match(httpResp, respDepth)
+}
+
+//选择解析函数
+func (this *Router) route(httpResp *http.Response, respDepth uint32, body []byte) []ParseResponse {
+	mediaType := mediaType(httpResp, body)
+	for _, route := range this.routes {
+		if route.matches(httpResp, respDepth, mediaType) {
+			atomic.AddUint64(&route.matched, 1)
+			return route.parsers
+		}
+	}
+	if len(this.fallback) > 0 {
+		atomic.AddUint64(&this.fallbacks, 1)
+		return this.fallback
+	}
+	atomic.AddUint64(&this.unmatched, 1)
+	return nil
+}
+
+//按路由解析响应，可以作为一个解析函数交给分析器
+//选中的每个解析函数都会得到一个从头读取的响应体
+func (this *Router) ParseResponse(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
+	if httpResp.Request == nil || httpResp.Request.URL == nil {
+		return nil, []error{errors.New("The request of http response is invalid!")}
+	}
+	body, err := readBody(httpResp)
+	if err != nil {
+		return nil, []error{err}
+	}
+	parsers := this.route(httpResp, respDepth, body)
+	if parsers == nil {
+		logger.Printf("No parser matched the response (reqUrl=%s)\n", httpResp.Request.URL)
+		return nil, nil
+	}
+	return runParsers(parsers, httpResp, respDepth, body)
+}
+
+//没有任何解析函数处理的响应数
+func (this *Router) Unmatched() uint64 {
+	return atomic.LoadUint64(&this.unmatched)
+}
+
+//摘要信息，包括各个路由的匹配次数、使用后备解析函数的次数和未匹配的次数
+func (this *Router) Summary() string {
+	var buffer bytes.Buffer
+	for _, route := range this.routes {
+		buffer.WriteString(fmt.Sprintf("%s:%d,", route.name, atomic.LoadUint64(&route.matched)))
+	}
+	buffer.WriteString(fmt.Sprintf("fallback:%d,unmatched:%d", atomic.LoadUint64(&this.fallbacks), atomic.LoadUint64(&this.unmatched)))
+	return buffer.String()
+}
@@ -0,0 +1,488 @@
+package analyzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/fmyxyz/goreptile/base"
+	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//规则产生的条目中记录规则名、网页URL和元数据的键
+const (
+	ITEM_KEY_RULE = "_rule"
+	ITEM_KEY_URL  = "_url"
+	ITEM_KEY_META = "_meta" //网页对应请求的元数据，没有元数据时不设置
+)
+
+//规则集，对应一个规则文件
+type RuleSet struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+//抽取规则
+//网页的URL与 urls 中任意一个通配符模式或 urlPatterns 中任意一个正则表达式匹配时使用该规则，
+//两者都为空时匹配所有网页
+type Rule struct {
+	Name        string       `json:"name" yaml:"name"`
+	Urls        []string     `json:"urls" yaml:"urls"`               //URL通配符模式，* 匹配任意字符，? 匹配单个字符
+	UrlPatterns []string     `json:"urlPatterns" yaml:"urlPatterns"` //URL正则表达式
+	Scope       *Selector    `json:"scope" yaml:"scope"`             //条目范围，每个匹配的元素产生一个条目，为空时整个网页产生一个条目
+	Fields      []FieldRule  `json:"fields" yaml:"fields"`           //条目字段
+	Follow      []FollowRule `json:"follow" yaml:"follow"`           //跟随的链接
+}
+
+//选择器
+//css 和 xpath 至多设置一个，选中的元素按 attr 取值：
+//为空时取元素的文本，html 取元素内部的HTML，outerHtml 取包括元素本身的HTML，其他值取同名属性；
+//regex 用于从取到的值中提取内容，有分组时取第一个分组，否则取整个匹配；
+//没有 css 和 xpath 时 regex 直接用于整个网页（或条目范围内的HTML）
+type Selector struct {
+	Css   string `json:"css" yaml:"css"`
+	Xpath string `json:"xpath" yaml:"xpath"`
+	Regex string `json:"regex" yaml:"regex"`
+	Attr  string `json:"attr" yaml:"attr"`
+}
+
+//字段规则
+type FieldRule struct {
+	Name     string `json:"name" yaml:"name"`
+	Selector `yaml:",inline"`
+	Multiple bool `json:"multiple" yaml:"multiple"` //为true时取所有值，字段值为 []string，否则只取第一个值
+	Required bool `json:"required" yaml:"required"` //为true时没有值的条目会被丢弃
+}
+
+//链接跟随规则，attr 默认为 href
+//链接的URL与 urls 或 urlPatterns 匹配时才跟随，两者都为空时跟随所有链接
+type FollowRule struct {
+	Selector    `yaml:",inline"`
+	Urls        []string          `json:"urls" yaml:"urls"`
+	UrlPatterns []string          `json:"urlPatterns" yaml:"urlPatterns"`
+	MaxDepth    uint32            `json:"maxDepth" yaml:"maxDepth"` //链接请求的最大深度，为0时不限制
+	Priority    int               `json:"priority" yaml:"priority"` //链接请求的优先级
+	Callback    string            `json:"callback" yaml:"callback"` //链接请求的回调名称，如另一条规则的名称
+	Meta        map[string]string `json:"meta" yaml:"meta"`         //链接请求的元数据，链接请求会继承当前网页的元数据
+}
+
+//从YAML或JSON文件加载规则集，扩展名为 .json 时按JSON解析，否则按YAML解析
+//规则中不认识的键会被当作错误
+func LoadRuleSet(path string) (*RuleSet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ruleSet := &RuleSet{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(ruleSet)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(ruleSet)
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse the rule file %s: %s", path, err))
+	}
+	return ruleSet, nil
+}
+
+//从YAML或JSON文件加载规则并编译为解析函数
+func LoadRuleParsers(path string) ([]ParseResponse, error) {
+	ruleSet, err := LoadRuleSet(path)
+	if err != nil {
+		return nil, err
+	}
+	return ruleSet.Compile()
+}
+
+//把规则集编译为解析函数，每条规则对应一个解析函数
+func (this *RuleSet) Compile() ([]ParseResponse, error) {
+	if len(this.Rules) == 0 {
+		return nil, errors.New("The rule set is empty!")
+	}
+	parsers := make([]ParseResponse, 0, len(this.Rules))
+	for i := range this.Rules {
+		rule, err := compileRule(&this.Rules[i])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("rules[%d](%s): %s", i, this.Rules[i].Name, err))
+		}
+		parsers = append(parsers, rule.parse)
+	}
+	return parsers, nil
+}
+
+//把每条规则以规则名注册为命名解析函数，以便链接跟随规则通过 callback 指定
+func (this *RuleSet) Register(registry *ParserRegistry) error {
+	parsers, err := this.Compile()
+	if err != nil {
+		return err
+	}
+	for i, parser := range parsers {
+		if err := registry.Register(this.Rules[i].Name, parser); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//URL匹配器
+type urlMatcher []*regexp.Regexp
+
+//编译URL通配符模式和正则表达式
+func compileUrlMatcher(globs []string, patterns []string) (urlMatcher, error) {
+	var matcher urlMatcher
+	for _, glob := range globs {
+		re, err := globToRegexp(glob)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("The url glob %q is invalid: %s", glob, err))
+		}
+		matcher = append(matcher, re)
+	}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("The url pattern %q is invalid: %s", pattern, err))
+		}
+		matcher = append(matcher, re)
+	}
+	return matcher, nil
+}
+
+//把通配符模式转换为正则表达式，* 匹配任意字符（包括 /），? 匹配单个字符
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			buffer.WriteString(".*")
+		case '?':
+			buffer.WriteString(".")
+		default:
+			buffer.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buffer.WriteString("$")
+	return regexp.Compile(buffer.String())
+}
+
+//匹配器为空时匹配所有URL
+func (this urlMatcher) match(rawurl string) bool {
+	if len(this) == 0 {
+		return true
+	}
+	for _, re := range this {
+		if re.MatchString(rawurl) {
+			return true
+		}
+	}
+	return false
+}
+
+//编译后的选择器
+type compiledSelector struct {
+	css   cascadia.Selector
+	xpath *xpath.Expr
+	regex *regexp.Regexp
+	attr  string
+}
+
+func compileSelector(selector *Selector, defaultAttr string, allowRegexOnly bool) (*compiledSelector, error) {
+	compiled := &compiledSelector{attr: selector.Attr}
+	if selector.Css != "" && selector.Xpath != "" {
+		return nil, errors.New("The css and xpath can not be both set!")
+	}
+	if selector.Css != "" {
+		css, err := cascadia.Compile(selector.Css)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("The css selector %q is invalid: %s", selector.Css, err))
+		}
+		compiled.css = css
+	}
+	if selector.Xpath != "" {
+		expr, err := xpath.Compile(selector.Xpath)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("The xpath %q is invalid: %s", selector.Xpath, err))
+		}
+		compiled.xpath = expr
+	}
+	if selector.Regex != "" {
+		re, err := regexp.Compile(selector.Regex)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("The regex %q is invalid: %s", selector.Regex, err))
+		}
+		compiled.regex = re
+	}
+	if compiled.css == nil && compiled.xpath == nil {
+		if compiled.regex == nil || !allowRegexOnly {
+			return nil, errors.New("The css or xpath is required!")
+		}
+		if selector.Attr != "" {
+			return nil, errors.New("The attr can not be used without css or xpath!")
+		}
+	}
+	if compiled.attr == "" {
+		compiled.attr = defaultAttr
+	}
+	return compiled, nil
+}
+
+//选择节点，没有 css 和 xpath 时返回nil
+func (this *compiledSelector) nodes(top *html.Node) []*html.Node {
+	if this.css != nil {
+		return this.css.MatchAll(top)
+	}
+	if this.xpath != nil {
+		return htmlquery.QuerySelectorAll(top, this.xpath)
+	}
+	return nil
+}
+
+//节点的值
+func (this *compiledSelector) nodeValue(node *html.Node) string {
+	switch strings.ToLower(this.attr) {
+	case "", "text":
+		return strings.TrimSpace(htmlquery.InnerText(node))
+	case "html":
+		return htmlquery.OutputHTML(node, false)
+	case "outerhtml":
+		return htmlquery.OutputHTML(node, true)
+	default:
+		return strings.TrimSpace(htmlquery.SelectAttr(node, this.attr))
+	}
+}
+
+//用正则表达式提取内容，有分组时取第一个分组
+func regexValues(re *regexp.Regexp, text string, all bool) []string {
+	n := 1
+	if all {
+		n = -1
+	}
+	var values []string
+	for _, match := range re.FindAllStringSubmatch(text, n) {
+		if len(match) > 1 {
+			values = append(values, match[1])
+		} else {
+			values = append(values, match[0])
+		}
+	}
+	return values
+}
+
+//取值，all 为false时最多取一个值
+func (this *compiledSelector) values(top *html.Node, all bool) []string {
+	var values []string
+	if this.css == nil && this.xpath == nil {
+		return regexValues(this.regex, htmlquery.OutputHTML(top, true), all)
+	}
+	for _, node := range this.nodes(top) {
+		value := this.nodeValue(node)
+		if this.regex != nil {
+			values = append(values, regexValues(this.regex, value, all)...)
+		} else if value != "" {
+			values = append(values, value)
+		}
+		if !all && len(values) > 0 {
+			return values[:1]
+		}
+	}
+	return values
+}
+
+type compiledField struct {
+	name     string
+	selector *compiledSelector
+	multiple bool
+	required bool
+}
+
+type compiledFollow struct {
+	selector *compiledSelector
+	urls     urlMatcher
+	maxDepth uint32
+	priority int
+	callback string
+	meta     map[string]string
+}
+
+//编译后的规则
+type compiledRule struct {
+	name   string
+	urls   urlMatcher
+	scope  *compiledSelector
+	fields []compiledField
+	follow []compiledFollow
+}
+
+func compileRule(rule *Rule) (*compiledRule, error) {
+	if rule.Name == "" {
+		return nil, errors.New("The rule name is required!")
+	}
+	if len(rule.Fields) == 0 && len(rule.Follow) == 0 {
+		return nil, errors.New("The rule has neither fields nor follow rules!")
+	}
+	urls, err := compileUrlMatcher(rule.Urls, rule.UrlPatterns)
+	if err != nil {
+		return nil, err
+	}
+	compiled := &compiledRule{name: rule.Name, urls: urls}
+	if rule.Scope != nil {
+		if rule.Scope.Regex != "" || rule.Scope.Attr != "" {
+			return nil, errors.New("scope: The regex and attr can not be used in scope!")
+		}
+		scope, err := compileSelector(rule.Scope, "", false)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("scope: %s", err))
+		}
+		compiled.scope = scope
+	}
+	names := make(map[string]bool)
+	for i, field := range rule.Fields {
+		if field.Name == "" || strings.HasPrefix(field.Name, "_") {
+			return nil, errors.New(fmt.Sprintf("fields[%d]: The field name %q is invalid!", i, field.Name))
+		}
+		if names[field.Name] {
+			return nil, errors.New(fmt.Sprintf("fields[%d]: The field name %q is duplicated!", i, field.Name))
+		}
+		names[field.Name] = true
+		selector, err := compileSelector(&field.Selector, "", true)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("fields[%d](%s): %s", i, field.Name, err))
+		}
+		compiled.fields = append(compiled.fields, compiledField{
+			name:     field.Name,
+			selector: selector,
+			multiple: field.Multiple,
+			required: field.Required,
+		})
+	}
+	for i, follow := range rule.Follow {
+		selector, err := compileSelector(&follow.Selector, "href", true)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("follow[%d]: %s", i, err))
+		}
+		urls, err := compileUrlMatcher(follow.Urls, follow.UrlPatterns)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("follow[%d]: %s", i, err))
+		}
+		compiled.follow = append(compiled.follow, compiledFollow{
+			selector: selector,
+			urls:     urls,
+			maxDepth: follow.MaxDepth,
+			priority: follow.Priority,
+			callback: follow.Callback,
+			meta:     follow.Meta,
+		})
+	}
+	return compiled, nil
+}
+
+//按规则解析响应，URL不匹配时不产生任何数据
+func (this *compiledRule) parse(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
+	if httpResp.Request == nil || httpResp.Request.URL == nil {
+		return nil, []error{errors.New("The request of http response is invalid!")}
+	}
+	reqUrl := httpResp.Request.URL
+	if !this.urls.match(reqUrl.String()) {
+		return nil, nil
+	}
+	defer httpResp.Body.Close()
+	doc, err := html.Parse(httpResp.Body)
+	if err != nil {
+		return nil, []error{err}
+	}
+	var dataList []base.Data
+	var errs []error
+	if len(this.fields) > 0 {
+		scopes := []*html.Node{doc}
+		if this.scope != nil {
+			scopes = this.scope.nodes(doc)
+		}
+		for _, scope := range scopes {
+			if item := this.item(scope, httpResp.Request); item != nil {
+				dataList = append(dataList, item)
+			}
+		}
+	}
+	for _, follow := range this.follow {
+		if follow.maxDepth > 0 && respDepth+1 > follow.maxDepth {
+			continue
+		}
+		for _, link := range follow.selector.values(doc, true) {
+			linkUrl, err := reqUrl.Parse(link)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if linkUrl.Scheme != "http" && linkUrl.Scheme != "https" {
+				continue
+			}
+			linkUrl.Fragment = ""
+			if !follow.urls.match(linkUrl.String()) {
+				continue
+			}
+			httpReq, err := http.NewRequest(http.MethodGet, linkUrl.String(), nil)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			req := base.NewRequestWithPriority(httpReq, respDepth, follow.priority)
+			if meta := base.RequestMeta(httpResp.Request); len(meta) > 0 || len(follow.meta) > 0 {
+				if meta == nil {
+					meta = make(map[string]interface{}, len(follow.meta))
+				}
+				for key, value := range follow.meta {
+					meta[key] = value
+				}
+				req.SetMetaMap(meta)
+			}
+			if follow.callback != "" {
+				req.SetCallback(follow.callback)
+			}
+			dataList = append(dataList, req)
+		}
+	}
+	return dataList, errs
+}
+
+//在范围内抽取一个条目，没有任何字段有值或必需的字段没有值时返回nil
+func (this *compiledRule) item(scope *html.Node, httpReq *http.Request) base.Item {
+	reqUrl := httpReq.URL
+	item := base.Item{}
+	found := false
+	for _, field := range this.fields {
+		values := field.selector.values(scope, field.multiple)
+		if len(values) == 0 {
+			if field.required {
+				return nil
+			}
+			continue
+		}
+		found = true
+		if field.multiple {
+			item[field.name] = values
+		} else {
+			item[field.name] = values[0]
+		}
+	}
+	if !found {
+		return nil
+	}
+	item[ITEM_KEY_RULE] = this.name
+	item[ITEM_KEY_URL] = reqUrl.String()
+	if meta := base.RequestMeta(httpReq); meta != nil {
+		item[ITEM_KEY_META] = meta
+	}
+	return item
+}
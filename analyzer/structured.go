@@ -0,0 +1,422 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"net/http"
+	"strings"
+)
+
+//结构化数据的格式
+const (
+	FORMAT_JSON_LD   = "json-ld"
+	FORMAT_MICRODATA = "microdata"
+	FORMAT_RDFA      = "rdfa"
+	FORMAT_OPENGRAPH = "opengraph"
+)
+
+//结构化数据条目的键
+//每个条目的结构相同：格式、网页URL、类型、ID和属性，
+//属性的值出现一次时为单个值，出现多次时为 []interface{}，嵌套的条目为同样结构的 map
+const (
+	ITEM_KEY_FORMAT     = "_format"
+	ITEM_KEY_TYPE       = "type"
+	ITEM_KEY_ID         = "id"
+	ITEM_KEY_PROPERTIES = "properties"
+)
+
+//从网页中抽取结构化数据的函数
+type extractStructured func(doc *html.Node, reqUrl string) ([]base.Item, []error)
+
+//用给定的抽取函数解析响应，网页只解析一次
+func parseStructured(httpResp *http.Response, extractors ...extractStructured) ([]base.Data, []error) {
+	if httpResp.Request == nil || httpResp.Request.URL == nil {
+		return nil, []error{errors.New("The request of http response is invalid!")}
+	}
+	defer httpResp.Body.Close()
+	doc, err := html.Parse(httpResp.Body)
+	if err != nil {
+		return nil, []error{err}
+	}
+	reqUrl := httpResp.Request.URL.String()
+	var dataList []base.Data
+	var errs []error
+	for _, extract := range extractors {
+		items, pErrs := extract(doc, reqUrl)
+		for _, item := range items {
+			dataList = append(dataList, item)
+		}
+		errs = append(errs, pErrs...)
+	}
+	return dataList, errs
+}
+
+//抽取 schema.org JSON-LD 数据
+func ParseJsonLd(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
+	return parseStructured(httpResp, extractJsonLd)
+}
+
+//抽取 Microdata 数据
+func ParseMicrodata(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
+	return parseStructured(httpResp, extractMicrodata)
+}
+
+//抽取 RDFa Lite 数据
+func ParseRdfa(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
+	return parseStructured(httpResp, extractRdfa)
+}
+
+//抽取 OpenGraph 和 Twitter Card 元数据
+func ParseOpenGraph(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
+	return parseStructured(httpResp, extractOpenGraph)
+}
+
+//抽取以上所有结构化数据
+func ParseStructuredData(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
+	return parseStructured(httpResp, extractJsonLd, extractMicrodata, extractRdfa, extractOpenGraph)
+}
+
+//去掉 schema.org 词汇表前缀，其他词汇表的类型保持完整的IRI
+func normalizeType(t string) string {
+	t = strings.TrimSpace(t)
+	for _, prefix := range []string{"http://schema.org/", "https://schema.org/", "schema:"} {
+		if strings.HasPrefix(t, prefix) {
+			return t[len(prefix):]
+		}
+	}
+	return t
+}
+
+//规范化类型，多个类型时为 []interface{}
+func normalizeTypes(types []string) interface{} {
+	var normalized []interface{}
+	for _, t := range types {
+		if t = normalizeType(t); t != "" {
+			normalized = append(normalized, t)
+		}
+	}
+	switch len(normalized) {
+	case 0:
+		return nil
+	case 1:
+		return normalized[0]
+	default:
+		return normalized
+	}
+}
+
+//添加属性值，同一属性出现多次时转换为列表
+func addProperty(properties map[string]interface{}, name string, value interface{}) {
+	old, ok := properties[name]
+	if !ok {
+		properties[name] = value
+		return
+	}
+	if list, ok := old.([]interface{}); ok {
+		properties[name] = append(list, value)
+		return
+	}
+	properties[name] = []interface{}{old, value}
+}
+
+//生成规范化的结构化数据条目
+func structuredItem(format string, reqUrl string, types interface{}, id string, properties map[string]interface{}) base.Item {
+	item := base.Item{
+		ITEM_KEY_FORMAT:     format,
+		ITEM_KEY_URL:        reqUrl,
+		ITEM_KEY_PROPERTIES: properties,
+	}
+	if types != nil {
+		item[ITEM_KEY_TYPE] = types
+	}
+	if id != "" {
+		item[ITEM_KEY_ID] = id
+	}
+	return item
+}
+
+func attr(node *html.Node, name string) (string, bool) {
+	for _, a := range node.Attr {
+		if a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func textContent(node *html.Node) string {
+	var builder strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			builder.WriteString(n.Data)
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+	return strings.Join(strings.Fields(builder.String()), " ")
+}
+
+//深度优先遍历元素，visit 返回false时不再遍历该元素的子元素
+func walkElements(node *html.Node, visit func(n *html.Node) bool) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		if visit(child) {
+			walkElements(child, visit)
+		}
+	}
+}
+
+func extractJsonLd(doc *html.Node, reqUrl string) ([]base.Item, []error) {
+	var items []base.Item
+	var errs []error
+	walkElements(doc, func(n *html.Node) bool {
+		if n.DataAtom != atom.Script {
+			return true
+		}
+		if t, _ := attr(n, "type"); strings.ToLower(strings.TrimSpace(t)) != "application/ld+json" {
+			return false
+		}
+		var data interface{}
+		if err := json.Unmarshal([]byte(textContent(n)), &data); err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("Invalid JSON-LD block: %s (reqUrl=%s)", err, reqUrl)))
+			return false
+		}
+		for _, object := range jsonLdObjects(data) {
+			items = append(items, jsonLdItem(object, reqUrl))
+		}
+		return false
+	})
+	return items, errs
+}
+
+//展开数组和 @graph 中的对象
+func jsonLdObjects(data interface{}) []map[string]interface{} {
+	var objects []map[string]interface{}
+	switch value := data.(type) {
+	case []interface{}:
+		for _, element := range value {
+			objects = append(objects, jsonLdObjects(element)...)
+		}
+	case map[string]interface{}:
+		if graph, ok := value["@graph"]; ok {
+			objects = append(objects, jsonLdObjects(graph)...)
+		} else {
+			objects = append(objects, value)
+		}
+	}
+	return objects
+}
+
+func jsonLdItem(object map[string]interface{}, reqUrl string) base.Item {
+	var types []string
+	switch t := object["@type"].(type) {
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, element := range t {
+			if s, ok := element.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+	id, _ := object["@id"].(string)
+	properties := make(map[string]interface{})
+	for key, value := range object {
+		if strings.HasPrefix(key, "@") {
+			continue
+		}
+		properties[key] = value
+	}
+	return structuredItem(FORMAT_JSON_LD, reqUrl, normalizeTypes(types), id, properties)
+}
+
+//Microdata 和 RDFa 中元素的属性值
+func elementValue(n *html.Node, contentAttr bool) string {
+	if contentAttr {
+		if content, ok := attr(n, "content"); ok {
+			return content
+		}
+	}
+	var names []string
+	switch n.DataAtom {
+	case atom.Meta:
+		names = []string{"content"}
+	case atom.Audio, atom.Embed, atom.Iframe, atom.Img, atom.Source, atom.Track, atom.Video:
+		names = []string{"src"}
+	case atom.A, atom.Area, atom.Link:
+		names = []string{"href"}
+	case atom.Object:
+		names = []string{"data"}
+	case atom.Data, atom.Meter:
+		names = []string{"value"}
+	case atom.Time:
+		names = []string{"datetime"}
+	}
+	for _, name := range names {
+		if value, ok := attr(n, name); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return textContent(n)
+}
+
+func extractMicrodata(doc *html.Node, reqUrl string) ([]base.Item, []error) {
+	var items []base.Item
+	walkElements(doc, func(n *html.Node) bool {
+		if _, ok := attr(n, "itemscope"); !ok {
+			return true
+		}
+		if _, ok := attr(n, "itemprop"); ok && hasAncestorAttr(n, "itemscope") {
+			//属于外层条目的嵌套条目
+			return true
+		}
+		items = append(items, microdataItem(n, reqUrl))
+		return true
+	})
+	return items, nil
+}
+
+func microdataItem(scope *html.Node, reqUrl string) base.Item {
+	itemtype, _ := attr(scope, "itemtype")
+	itemid, _ := attr(scope, "itemid")
+	properties := make(map[string]interface{})
+	walkElements(scope, func(n *html.Node) bool {
+		_, isScope := attr(n, "itemscope")
+		if names, ok := attr(n, "itemprop"); ok {
+			var value interface{}
+			if isScope {
+				value = microdataItem(n, reqUrl)
+			} else {
+				value = elementValue(n, false)
+			}
+			for _, name := range strings.Fields(names) {
+				addProperty(properties, normalizeType(name), value)
+			}
+		}
+		return !isScope
+	})
+	return structuredItem(FORMAT_MICRODATA, reqUrl, normalizeTypes(strings.Fields(itemtype)), itemid, properties)
+}
+
+//RDFa Lite 的词汇表，由最近的带 vocab 属性的祖先元素决定
+func rdfaVocab(n *html.Node) string {
+	for node := n; node != nil; node = node.Parent {
+		if node.Type != html.ElementNode {
+			continue
+		}
+		if vocab, ok := attr(node, "vocab"); ok {
+			return vocab
+		}
+	}
+	return ""
+}
+
+//判断元素是否有带某个属性的祖先元素
+func hasAncestorAttr(n *html.Node, name string) bool {
+	for node := n.Parent; node != nil; node = node.Parent {
+		if node.Type != html.ElementNode {
+			continue
+		}
+		if _, ok := attr(node, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func extractRdfa(doc *html.Node, reqUrl string) ([]base.Item, []error) {
+	var items []base.Item
+	walkElements(doc, func(n *html.Node) bool {
+		if _, ok := attr(n, "typeof"); !ok {
+			return true
+		}
+		if _, ok := attr(n, "property"); ok && hasAncestorAttr(n, "typeof") {
+			return true
+		}
+		items = append(items, rdfaItem(n, reqUrl))
+		return true
+	})
+	return items, nil
+}
+
+func rdfaItem(scope *html.Node, reqUrl string) base.Item {
+	vocab := rdfaVocab(scope)
+	typeof, _ := attr(scope, "typeof")
+	resource, _ := attr(scope, "resource")
+	var types []string
+	for _, t := range strings.Fields(typeof) {
+		if vocab != "" && !strings.Contains(t, ":") {
+			t = vocab + t
+		}
+		types = append(types, t)
+	}
+	properties := make(map[string]interface{})
+	walkElements(scope, func(n *html.Node) bool {
+		_, isScope := attr(n, "typeof")
+		if names, ok := attr(n, "property"); ok {
+			var value interface{}
+			if isScope {
+				value = rdfaItem(n, reqUrl)
+			} else if res, ok := attr(n, "resource"); ok {
+				value = res
+			} else {
+				value = elementValue(n, true)
+			}
+			for _, name := range strings.Fields(names) {
+				addProperty(properties, normalizeType(name), value)
+			}
+		}
+		return !isScope
+	})
+	return structuredItem(FORMAT_RDFA, reqUrl, normalizeTypes(types), resource, properties)
+}
+
+//OpenGraph、Twitter Card 以及 article:、product: 等相关前缀的元数据
+var openGraphPrefixes = []string{"og:", "twitter:", "article:", "product:", "book:", "profile:", "music:", "video:", "fb:"}
+
+func extractOpenGraph(doc *html.Node, reqUrl string) ([]base.Item, []error) {
+	properties := make(map[string]interface{})
+	walkElements(doc, func(n *html.Node) bool {
+		if n.DataAtom != atom.Meta {
+			return true
+		}
+		name, ok := attr(n, "property")
+		if !ok {
+			name, _ = attr(n, "name")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		content, ok := attr(n, "content")
+		if !ok {
+			return false
+		}
+		for _, prefix := range openGraphPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				addProperty(properties, name, strings.TrimSpace(content))
+				break
+			}
+		}
+		return false
+	})
+	if len(properties) == 0 {
+		return nil, nil
+	}
+	var types interface{}
+	if t, ok := properties["og:type"].(string); ok {
+		types = t
+	}
+	var id string
+	if u, ok := properties["og:url"].(string); ok {
+		id = u
+	}
+	return []base.Item{structuredItem(FORMAT_OPENGRAPH, reqUrl, types, id, properties)}, nil
+}
@@ -3,6 +3,8 @@ package base
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
 type Args interface {
@@ -87,3 +89,272 @@ func (this *PoolBaseArgs) PageDownloaderPoolSize() uint32 {
 func (this *PoolBaseArgs) AnalyzerPoolSize() uint32 {
 	return this.analyzerPoolSize
 }
+
+//单个域名的礼貌策略
+type HostPoliteness struct {
+	Concurrency uint32        //最大并发数，0表示不限制
+	Delay       time.Duration //两次请求之间的最小间隔
+}
+
+//礼貌爬取参数
+type PolitenessArgs struct {
+	hostConcurrency uint32                    //每个主机的最大并发数，0表示不限制
+	ipConcurrency   uint32                    //每个IP的最大并发数，0表示不限制
+	hostDelay       time.Duration             //同一主机两次请求之间的最小间隔
+	domainOverrides map[string]HostPoliteness //按域名覆盖的礼貌策略，对其子域名同样有效
+	description     string
+}
+
+func NewPolitenessArgs(hostConcurrency, ipConcurrency uint32, hostDelay time.Duration) PolitenessArgs {
+	return PolitenessArgs{
+		hostConcurrency: hostConcurrency,
+		ipConcurrency:   ipConcurrency,
+		hostDelay:       hostDelay,
+		domainOverrides: make(map[string]HostPoliteness),
+	}
+}
+
+//设置某个域名（及其子域名）的礼貌策略
+func (this *PolitenessArgs) SetDomainPoliteness(domain string, concurrency uint32, delay time.Duration) {
+	if this.domainOverrides == nil {
+		this.domainOverrides = make(map[string]HostPoliteness)
+	}
+	this.domainOverrides[strings.ToLower(domain)] = HostPoliteness{Concurrency: concurrency, Delay: delay}
+}
+
+func (this *PolitenessArgs) Check() error {
+	if this.hostDelay < 0 {
+		return errors.New("PolitenessArgs Check error!")
+	}
+	for domain, hp := range this.domainOverrides {
+		if domain == "" || hp.Delay < 0 {
+			return errors.New("PolitenessArgs Check error!")
+		}
+	}
+	return nil
+}
+
+func (this *PolitenessArgs) String() string {
+	return fmt.Sprintf(`hostConcurrency:   %d,
+		ipConcurrency:   %d,
+		hostDelay:   %s,
+		domainOverrides:   %d
+`, this.hostConcurrency, this.ipConcurrency, this.hostDelay, len(this.domainOverrides))
+}
+
+func (this PolitenessArgs) IpConcurrency() uint32 {
+	return this.ipConcurrency
+}
+
+//获取某个主机的礼貌策略
+//按域名覆盖的策略中，匹配最长的域名优先
+func (this PolitenessArgs) HostPoliteness(host string) HostPoliteness {
+	host = strings.ToLower(host)
+	hp := HostPoliteness{Concurrency: this.hostConcurrency, Delay: this.hostDelay}
+	var matched string
+	for domain, override := range this.domainOverrides {
+		if (host == domain || strings.HasSuffix(host, "."+domain)) && len(domain) > len(matched) {
+			matched = domain
+			hp = override
+		}
+	}
+	return hp
+}
+
+//robots.txt 参数
+type RobotsArgs struct {
+	obey        bool            //是否遵守 robots.txt
+	userAgent   string          //匹配 robots.txt 规则时使用的 User-agent
+	ignoreHosts map[string]bool //忽略 robots.txt 的主机，如自己的站点
+	description string
+}
+
+//创建遵守 robots.txt 的参数
+func NewRobotsArgs(userAgent string, ignoreHosts ...string) RobotsArgs {
+	hosts := make(map[string]bool)
+	for _, host := range ignoreHosts {
+		hosts[strings.ToLower(host)] = true
+	}
+	return RobotsArgs{
+		obey:        true,
+		userAgent:   userAgent,
+		ignoreHosts: hosts,
+	}
+}
+
+func (this *RobotsArgs) Check() error {
+	if this.obey && strings.TrimSpace(this.userAgent) == "" {
+		return errors.New("RobotsArgs Check error!")
+	}
+	return nil
+}
+
+func (this *RobotsArgs) String() string {
+	return fmt.Sprintf(`obey:   %v,
+		userAgent:   %s,
+		ignoreHosts:   %d
+`, this.obey, this.userAgent, len(this.ignoreHosts))
+}
+
+func (this RobotsArgs) Obey() bool {
+	return this.obey
+}
+
+func (this RobotsArgs) UserAgent() string {
+	return this.userAgent
+}
+
+//判断是否需要对该主机遵守 robots.txt
+func (this RobotsArgs) ObeyHost(host string) bool {
+	return this.obey && !this.ignoreHosts[strings.ToLower(host)]
+}
+
+//默认重试的响应状态码
+var DefaultRetryStatusCodes = []int{429, 500, 502, 503, 504}
+
+//下载重试参数
+type RetryArgs struct {
+	maxAttempts uint32        //最大尝试次数（包括第一次），不大于1表示不重试
+	baseDelay   time.Duration //第一次重试前的等待时间，之后按指数增长
+	maxDelay    time.Duration //最长等待时间
+	statusCodes map[int]bool  //需要重试的响应状态码
+	description string
+}
+
+func NewRetryArgs(maxAttempts uint32, baseDelay, maxDelay time.Duration) RetryArgs {
+	args := RetryArgs{
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		maxDelay:    maxDelay,
+	}
+	args.SetRetryStatusCodes(DefaultRetryStatusCodes...)
+	return args
+}
+
+//设置需要重试的响应状态码
+func (this *RetryArgs) SetRetryStatusCodes(codes ...int) {
+	this.statusCodes = make(map[int]bool)
+	for _, code := range codes {
+		this.statusCodes[code] = true
+	}
+}
+
+func (this *RetryArgs) Check() error {
+	if this.maxAttempts > 1 && (this.baseDelay <= 0 || this.maxDelay < this.baseDelay) {
+		return errors.New("RetryArgs Check error!")
+	}
+	return nil
+}
+
+func (this *RetryArgs) String() string {
+	return fmt.Sprintf(`maxAttempts:   %d,
+		baseDelay:   %s,
+		maxDelay:   %s,
+		statusCodes:   %d
+`, this.maxAttempts, this.baseDelay, this.maxDelay, len(this.statusCodes))
+}
+
+func (this RetryArgs) MaxAttempts() uint32 {
+	return this.maxAttempts
+}
+
+func (this RetryArgs) BaseDelay() time.Duration {
+	return this.baseDelay
+}
+
+func (this RetryArgs) MaxDelay() time.Duration {
+	return this.maxDelay
+}
+
+//判断该响应状态码是否需要重试
+func (this RetryArgs) RetryStatus(statusCode int) bool {
+	return this.statusCodes[statusCode]
+}
+
+//站点地图索引默认的最大嵌套层数
+const DefaultSitemapMaxDepth uint32 = 3
+
+//站点地图参数
+type SitemapArgs struct {
+	enabled     bool      //是否使用站点地图
+	discover    bool      //是否从首个请求所在站点的 robots.txt 和 /sitemap.xml 发现站点地图
+	sitemapUrls []string  //指定的站点地图
+	since       time.Time //只放入该时间之后修改过的URL，为零值时不限制
+	maxUrls     uint64    //最多使用站点地图中的URL数，为0时不限制
+	maxDepth    uint32    //站点地图索引的最大嵌套层数
+	description string
+}
+
+//创建站点地图参数，discover 为false时只使用指定的站点地图
+func NewSitemapArgs(discover bool, sitemapUrls ...string) SitemapArgs {
+	return SitemapArgs{
+		enabled:     true,
+		discover:    discover,
+		sitemapUrls: sitemapUrls,
+		maxDepth:    DefaultSitemapMaxDepth,
+	}
+}
+
+//只放入该时间之后修改过的URL，没有 lastmod 的URL总会放入
+func (this *SitemapArgs) SetSince(since time.Time) {
+	this.since = since
+}
+
+//设置最多使用站点地图中的URL数，为0时不限制
+func (this *SitemapArgs) SetMaxUrls(maxUrls uint64) {
+	this.maxUrls = maxUrls
+}
+
+//设置站点地图索引的最大嵌套层数
+func (this *SitemapArgs) SetMaxDepth(maxDepth uint32) {
+	this.maxDepth = maxDepth
+}
+
+func (this *SitemapArgs) Check() error {
+	if !this.enabled {
+		return nil
+	}
+	if !this.discover && len(this.sitemapUrls) == 0 {
+		return errors.New("SitemapArgs Check error! The sitemap url list is empty.")
+	}
+	for _, sitemapUrl := range this.sitemapUrls {
+		if !strings.HasPrefix(sitemapUrl, "http://") && !strings.HasPrefix(sitemapUrl, "https://") {
+			return errors.New(fmt.Sprintf("SitemapArgs Check error! The sitemap url %q is invalid.", sitemapUrl))
+		}
+	}
+	return nil
+}
+
+func (this *SitemapArgs) String() string {
+	return fmt.Sprintf(`enabled:   %v,
+		discover:   %v,
+		sitemapUrls:   %d,
+		since:   %s,
+		maxUrls:   %d,
+		maxDepth:   %d
+`, this.enabled, this.discover, len(this.sitemapUrls), this.since.Format(time.RFC3339), this.maxUrls, this.maxDepth)
+}
+
+func (this SitemapArgs) Enabled() bool {
+	return this.enabled
+}
+
+func (this SitemapArgs) Discover() bool {
+	return this.discover
+}
+
+func (this SitemapArgs) SitemapUrls() []string {
+	return this.sitemapUrls
+}
+
+func (this SitemapArgs) Since() time.Time {
+	return this.since
+}
+
+func (this SitemapArgs) MaxUrls() uint64 {
+	return this.maxUrls
+}
+
+func (this SitemapArgs) MaxDepth() uint32 {
+	return this.maxDepth
+}
@@ -1,15 +1,27 @@
 package base
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 //请求
 type Request struct {
-	httpReq *http.Request //http 请求
-	depth   uint32        //请求深度
+	httpReq  *http.Request //http 请求
+	depth    uint32        //请求深度
+	priority int           //优先级，值越大越先被下载
+	attempt  uint32        //已尝试下载的次数
 }
 
 //创建新请求
@@ -17,24 +29,219 @@ func NewRequest(httpReq *http.Request, depth uint32) *Request {
 	return &Request{httpReq: httpReq, depth: depth}
 }
 
+//创建带优先级的新请求
+func NewRequestWithPriority(httpReq *http.Request, depth uint32, priority int) *Request {
+	return &Request{httpReq: httpReq, depth: depth, priority: priority}
+}
+
 //获取http请求
 func (this *Request) HttpReq() *http.Request {
 	return this.httpReq
 }
 
+//替换http请求，如下载器中间件改写请求
+func (this *Request) SetHttpReq(httpReq *http.Request) {
+	this.httpReq = httpReq
+}
+
+//请求的元数据和回调，保存在 http 请求的上下文中，
+//因此会随 http 请求经过下载器中间件、重定向，直到解析函数得到的 httpResp.Request
+type requestInfo struct {
+	meta     map[string]interface{}
+	callback string
+	seq      uint64 //请求在持久化的请求缓存中的序号
+}
+
+type requestInfoKey struct{}
+
+func httpReqInfo(httpReq *http.Request) *requestInfo {
+	if httpReq == nil {
+		return nil
+	}
+	info, _ := httpReq.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+//获取 http 请求携带的元数据，返回的是副本
+//解析函数可以通过 httpResp.Request 获取对应请求的元数据
+func RequestMeta(httpReq *http.Request) map[string]interface{} {
+	info := httpReqInfo(httpReq)
+	if info == nil || len(info.meta) == 0 {
+		return nil
+	}
+	meta := make(map[string]interface{}, len(info.meta))
+	for key, value := range info.meta {
+		meta[key] = value
+	}
+	return meta
+}
+
+//获取 http 请求携带的回调名称
+func RequestCallback(httpReq *http.Request) string {
+	info := httpReqInfo(httpReq)
+	if info == nil {
+		return ""
+	}
+	return info.callback
+}
+
+//替换请求信息，每次都生成新的 http 请求，不影响共用原 http 请求的其他请求
+func (this *Request) setInfo(info *requestInfo) {
+	if this.httpReq == nil {
+		return
+	}
+	ctx := context.WithValue(this.httpReq.Context(), requestInfoKey{}, info)
+	this.httpReq = this.httpReq.WithContext(ctx)
+}
+
+func (this *Request) info() requestInfo {
+	if info := httpReqInfo(this.httpReq); info != nil {
+		return *info
+	}
+	return requestInfo{}
+}
+
+//获取元数据
+func (this *Request) Meta(key string) (interface{}, bool) {
+	value, ok := this.info().meta[key]
+	return value, ok
+}
+
+//获取所有元数据的副本
+func (this *Request) MetaMap() map[string]interface{} {
+	return RequestMeta(this.httpReq)
+}
+
+//设置元数据
+//元数据会被传递给该请求的响应；持久化的请求按JSON保存元数据，恢复后数字会变为 float64
+func (this *Request) SetMeta(key string, value interface{}) {
+	info := this.info()
+	meta := make(map[string]interface{}, len(info.meta)+1)
+	for k, v := range info.meta {
+		meta[k] = v
+	}
+	meta[key] = value
+	info.meta = meta
+	this.setInfo(&info)
+}
+
+//替换所有元数据
+func (this *Request) SetMetaMap(meta map[string]interface{}) {
+	info := this.info()
+	info.meta = make(map[string]interface{}, len(meta))
+	for k, v := range meta {
+		info.meta[k] = v
+	}
+	this.setInfo(&info)
+}
+
+//获取回调名称，为空时使用默认的解析函数
+func (this *Request) Callback() string {
+	return this.info().callback
+}
+
+//设置回调名称，该请求的响应只交给以此名称注册的解析函数
+func (this *Request) SetCallback(name string) {
+	info := this.info()
+	info.callback = name
+	this.setInfo(&info)
+}
+
+//获取请求在持久化的请求缓存中的序号，未持久化时为0
+func (this *Request) Seq() uint64 {
+	return this.info().seq
+}
+
+//设置请求在持久化的请求缓存中的序号，序号随 http 请求传递给对应的响应
+func (this *Request) SetSeq(seq uint64) {
+	info := this.info()
+	info.seq = seq
+	this.setInfo(&info)
+}
+
 //获取深度
 func (this *Request) Depth() uint32 {
 	return this.depth
 }
 
+//获取优先级
+func (this *Request) Priority() int {
+	return this.priority
+}
+
+//设置优先级
+func (this *Request) SetPriority(priority int) {
+	this.priority = priority
+}
+
+//获取已尝试下载的次数
+func (this *Request) Attempt() uint32 {
+	return this.attempt
+}
+
+//设置已尝试下载的次数
+func (this *Request) SetAttempt(attempt uint32) {
+	this.attempt = attempt
+}
+
+//复制请求用于重试，已尝试次数加一，其他属性保持不变
+func (this *Request) NextAttempt() *Request {
+	req := *this
+	req.attempt++
+	return &req
+}
+
+//复制请求并设置新的深度，其他属性保持不变
+func (this *Request) CopyWithDepth(depth uint32) *Request {
+	req := *this
+	req.depth = depth
+	return &req
+}
+
 func (this *Request) Valid() bool {
 	return this.httpReq != nil && this.httpReq.URL != nil
 }
 
+//读取请求体
+//若请求体不可重复读取，会用已读出的内容替换原请求体
+func (this *Request) BodyBytes() ([]byte, error) {
+	httpReq := this.httpReq
+	if httpReq == nil {
+		return nil, errors.New("The http request is invalid!")
+	}
+	var body io.ReadCloser
+	if httpReq.GetBody != nil {
+		b, err := httpReq.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	} else if httpReq.Body != nil && httpReq.Body != http.NoBody {
+		body = httpReq.Body
+	}
+	if body == nil {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if httpReq.GetBody == nil {
+		httpReq.Body = ioutil.NopCloser(bytes.NewReader(data))
+		httpReq.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		}
+	}
+	return data, nil
+}
+
 //响应
 type Response struct {
 	httpResp *http.Response
 	depth    uint32
+	body     []byte //缓冲的响应体
+	buffered bool   //响应体是否已缓冲
 }
 
 //创建新响应
@@ -42,11 +249,158 @@ func NewResponse(httpResp *http.Response, depth uint32) *Response {
 	return &Response{httpResp: httpResp, depth: depth}
 }
 
+//读取并缓冲响应体，之后响应体可以被重复读取
+//经过 gzip 或 deflate 压缩的响应体会被解压，并去掉 Content-Encoding 响应头
+//maxSize 为解压后响应体的最大长度，为0时不限制，超过时返回错误
+func (this *Response) Buffer(maxSize int64) error {
+	if this.buffered {
+		if maxSize > 0 && int64(len(this.body)) > maxSize {
+			return bodyTooLargeError(this.httpResp, maxSize)
+		}
+		return nil
+	}
+	httpResp := this.httpResp
+	if httpResp == nil {
+		return errors.New("The http response is invalid!")
+	}
+	if httpResp.Body == nil || httpResp.Body == http.NoBody {
+		this.SetBody(nil)
+		return nil
+	}
+	defer httpResp.Body.Close()
+	if maxSize > 0 && httpResp.ContentLength > maxSize && httpResp.Header.Get("Content-Encoding") == "" {
+		return bodyTooLargeError(httpResp, maxSize)
+	}
+	var reader io.Reader = httpResp.Body
+	encoding := strings.ToLower(strings.TrimSpace(httpResp.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "gzip", "x-gzip":
+		gzipReader, err := gzip.NewReader(httpResp.Body)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case "deflate":
+		//deflate 应为 zlib 格式，但有些服务器会发送不带 zlib 头的原始 deflate 数据
+		bufReader := bufio.NewReader(httpResp.Body)
+		if header, err := bufReader.Peek(2); err == nil && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 && header[0]&0x0f == 8 {
+			zlibReader, err := zlib.NewReader(bufReader)
+			if err != nil {
+				return err
+			}
+			defer zlibReader.Close()
+			reader = zlibReader
+		} else {
+			flateReader := flate.NewReader(bufReader)
+			defer flateReader.Close()
+			reader = flateReader
+		}
+	default:
+		encoding = ""
+	}
+	if maxSize > 0 {
+		reader = io.LimitReader(reader, maxSize+1)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	if maxSize > 0 && int64(len(body)) > maxSize {
+		return bodyTooLargeError(httpResp, maxSize)
+	}
+	if encoding != "" {
+		httpResp.Header.Del("Content-Encoding")
+		httpResp.Uncompressed = true
+	}
+	this.SetBody(body)
+	return nil
+}
+
+func bodyTooLargeError(httpResp *http.Response, maxSize int64) error {
+	var reqUrl string
+	if httpResp.Request != nil && httpResp.Request.URL != nil {
+		reqUrl = httpResp.Request.URL.String()
+	}
+	return errors.New(fmt.Sprintf("The response body is larger than the max size %d bytes! (requestUrl=%s)", maxSize, reqUrl))
+}
+
+//获取响应体，未缓冲时先缓冲且不限制长度
+func (this *Response) Body() ([]byte, error) {
+	if err := this.Buffer(0); err != nil {
+		return nil, err
+	}
+	return this.body, nil
+}
+
+//替换响应体，同时更新 http 响应的响应体和长度
+func (this *Response) SetBody(body []byte) {
+	this.body = body
+	this.buffered = true
+	if this.httpResp == nil {
+		return
+	}
+	this.httpResp.ContentLength = int64(len(body))
+	if this.httpResp.Header != nil && this.httpResp.Header.Get("Content-Length") != "" {
+		this.httpResp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	this.httpResp.Body = this.BodyReader()
+}
+
+//获取一个从头读取缓冲的响应体的新读取器，响应体未缓冲时返回nil
+func (this *Response) BodyReader() io.ReadCloser {
+	if !this.buffered {
+		return nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(this.body))
+}
+
 //获取http响应
 func (this *Response) HttpReq() *http.Response {
 	return this.httpResp
 }
 
+//获取对应请求的元数据
+func (this *Response) Meta(key string) (interface{}, bool) {
+	if this.httpResp == nil {
+		return nil, false
+	}
+	info := httpReqInfo(this.httpResp.Request)
+	if info == nil {
+		return nil, false
+	}
+	value, ok := info.meta[key]
+	return value, ok
+}
+
+//获取对应请求的所有元数据的副本
+func (this *Response) MetaMap() map[string]interface{} {
+	if this.httpResp == nil {
+		return nil
+	}
+	return RequestMeta(this.httpResp.Request)
+}
+
+//获取对应请求的回调名称
+func (this *Response) Callback() string {
+	if this.httpResp == nil {
+		return ""
+	}
+	return RequestCallback(this.httpResp.Request)
+}
+
+//获取对应请求在持久化的请求缓存中的序号，见 Request.Seq
+func (this *Response) Seq() uint64 {
+	if this.httpResp == nil {
+		return 0
+	}
+	info := httpReqInfo(this.httpResp.Request)
+	if info == nil {
+		return 0
+	}
+	return info.seq
+}
+
 //获取深度
 func (this *Response) Depth() uint32 {
 	return this.depth
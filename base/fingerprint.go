@@ -0,0 +1,266 @@
+package base
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+//默认移除的跟踪参数，以 * 结尾的表示前缀
+var DefaultTrackingParams = []string{
+	"utm_*", "gclid", "fbclid", "msclkid", "yclid", "spm", "_hsenc", "_hsmi", "mc_cid", "mc_eid",
+}
+
+//URL规范化器
+//依次进行协议和主机小写、默认端口移除、路径百分号编码规范化和点段消除、
+//结尾斜杠移除、跟踪参数移除、查询参数排序、片段移除
+type Canonicalizer struct {
+	trackingParams     map[string]bool
+	trackingPrefixes   []string
+	stripTrailingSlash bool
+	stripFragment      bool
+	sortQuery          bool
+}
+
+//创建使用默认设置的URL规范化器
+func NewCanonicalizer() *Canonicalizer {
+	c := &Canonicalizer{
+		stripTrailingSlash: true,
+		stripFragment:      true,
+		sortQuery:          true,
+	}
+	c.SetTrackingParams(DefaultTrackingParams...)
+	return c
+}
+
+//设置需要移除的跟踪参数，以 * 结尾的表示前缀，如 utm_*
+func (this *Canonicalizer) SetTrackingParams(params ...string) {
+	this.trackingParams = make(map[string]bool)
+	this.trackingPrefixes = nil
+	for _, param := range params {
+		param = strings.ToLower(strings.TrimSpace(param))
+		if param == "" {
+			continue
+		}
+		if strings.HasSuffix(param, "*") {
+			this.trackingPrefixes = append(this.trackingPrefixes, strings.TrimSuffix(param, "*"))
+		} else {
+			this.trackingParams[param] = true
+		}
+	}
+}
+
+//设置是否移除路径结尾的斜杠（根路径除外）
+func (this *Canonicalizer) SetStripTrailingSlash(strip bool) {
+	this.stripTrailingSlash = strip
+}
+
+//设置是否移除片段
+func (this *Canonicalizer) SetStripFragment(strip bool) {
+	this.stripFragment = strip
+}
+
+//设置是否对查询参数排序
+func (this *Canonicalizer) SetSortQuery(sortQuery bool) {
+	this.sortQuery = sortQuery
+}
+
+func (this *Canonicalizer) isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	if this.trackingParams[name] {
+		return true
+	}
+	for _, prefix := range this.trackingPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//规范化URL，返回新的URL，不修改原URL
+func (this *Canonicalizer) Canonicalize(u *url.URL) *url.URL {
+	c := *u
+	c.User = nil
+	c.Scheme = strings.ToLower(c.Scheme)
+	host := strings.TrimSuffix(strings.ToLower(c.Hostname()), ".")
+	port := c.Port()
+	if (c.Scheme == "http" && port == "80") || (c.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	c.Host = host
+
+	p := normalizeEscapes(c.EscapedPath())
+	if p == "" {
+		p = "/"
+	} else {
+		trailing := strings.HasSuffix(p, "/")
+		p = path.Clean(p)
+		if trailing && p != "/" && !this.stripTrailingSlash {
+			p += "/"
+		}
+	}
+	c.RawPath = p
+	if unescaped, err := url.PathUnescape(p); err == nil {
+		c.Path = unescaped
+	} else {
+		c.Path = p
+	}
+
+	c.RawQuery = this.canonicalQuery(c.RawQuery)
+	c.ForceQuery = false
+	if this.stripFragment {
+		c.Fragment = ""
+		c.RawFragment = ""
+	}
+	return &c
+}
+
+//规范化URL并返回字符串形式
+func (this *Canonicalizer) CanonicalString(u *url.URL) string {
+	return this.Canonicalize(u).String()
+}
+
+//规范化查询参数，保留参数原有的编码
+func (this *Canonicalizer) canonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	pairs := make([]string, 0)
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		pair = normalizeEscapes(strings.Replace(pair, "+", "%20", -1))
+		name := pair
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			name = pair[:i]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if this.isTrackingParam(name) {
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
+	if this.sortQuery {
+		sort.Strings(pairs)
+	}
+	return strings.Join(pairs, "&")
+}
+
+//规范化百分号编码：解码非保留字符，其余编码使用大写十六进制
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var buffer bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if isUnreserved(b) {
+				buffer.WriteByte(b)
+			} else {
+				buffer.WriteByte('%')
+				buffer.WriteString(strings.ToUpper(s[i+1 : i+3]))
+			}
+			i += 2
+			continue
+		}
+		buffer.WriteByte(s[i])
+	}
+	return buffer.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func isUnreserved(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+//请求指纹生成器
+//指纹由请求方法、规范化后的URL、请求体以及选定的请求头计算得到
+type Fingerprinter struct {
+	canonicalizer *Canonicalizer
+	headers       []string
+}
+
+//创建请求指纹生成器
+//headers 为参与计算指纹的请求头
+func NewFingerprinter(canonicalizer *Canonicalizer, headers ...string) *Fingerprinter {
+	if canonicalizer == nil {
+		canonicalizer = NewCanonicalizer()
+	}
+	names := make([]string, 0, len(headers))
+	for _, header := range headers {
+		names = append(names, http.CanonicalHeaderKey(header))
+	}
+	sort.Strings(names)
+	return &Fingerprinter{canonicalizer: canonicalizer, headers: names}
+}
+
+//获取URL规范化器
+func (this *Fingerprinter) Canonicalizer() *Canonicalizer {
+	return this.canonicalizer
+}
+
+//计算请求的指纹，返回十六进制的 SHA-1 值
+func (this *Fingerprinter) Fingerprint(req *Request) (string, error) {
+	if req == nil || !req.Valid() {
+		return "", errors.New("The request is invalid!")
+	}
+	httpReq := req.HttpReq()
+	hash := sha1.New()
+	method := strings.ToUpper(httpReq.Method)
+	if method == "" {
+		method = "GET"
+	}
+	io.WriteString(hash, method)
+	hash.Write([]byte{0})
+	io.WriteString(hash, this.canonicalizer.CanonicalString(httpReq.URL))
+	hash.Write([]byte{0})
+	body, err := req.BodyBytes()
+	if err != nil {
+		return "", err
+	}
+	hash.Write(body)
+	for _, name := range this.headers {
+		values := httpReq.Header[name]
+		if len(values) == 0 {
+			continue
+		}
+		hash.Write([]byte{0})
+		io.WriteString(hash, name)
+		hash.Write([]byte{':'})
+		io.WriteString(hash, strings.Join(values, ","))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
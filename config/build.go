@@ -0,0 +1,478 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fmyxyz/goreptile/analyzer"
+	"github.com/fmyxyz/goreptile/base"
+	"github.com/fmyxyz/goreptile/downloader"
+	"github.com/fmyxyz/goreptile/middleware"
+	"github.com/fmyxyz/goreptile/scheduler"
+	"github.com/fmyxyz/goreptile/scope"
+	"github.com/fmyxyz/goreptile/seed"
+	"github.com/fmyxyz/goreptile/tool"
+	"github.com/fmyxyz/goreptile/warc"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+//配置错误，每个问题都以出错的键开头
+type Error struct {
+	Problems []string
+}
+
+func (this *Error) Error() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("The config is invalid! (%d problems)", len(this.Problems)))
+	for _, problem := range this.Problems {
+		buffer.WriteString("\n  - ")
+		buffer.WriteString(problem)
+	}
+	return buffer.String()
+}
+
+//监控参数，见 tool.Monitoring
+type MonitoringArgs struct {
+	Interval      time.Duration
+	MaxIdleCount  uint
+	AutoStop      bool
+	DetailSummary bool
+}
+
+//由配置生成的爬取设置
+type Crawl struct {
+	Scheduler  scheduler.Config
+	Monitoring MonitoringArgs
+	closers    []io.Closer //需要在爬取结束后关闭的资源，如 WARC 文件
+}
+
+//监控调度器，见 tool.Monitoring
+func (this *Crawl) Monitor(sched scheduler.Scheduler, record tool.Record) <-chan uint64 {
+	args := this.Monitoring
+	return tool.Monitoring(sched, args.Interval, args.MaxIdleCount, args.AutoStop, args.DetailSummary, record)
+}
+
+//关闭爬取过程中使用的资源
+func (this *Crawl) Close() error {
+	var firstErr error
+	for _, closer := range this.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	this.closers = nil
+	return firstErr
+}
+
+//配置问题的收集器
+type problems struct {
+	list []string
+}
+
+func (this *problems) add(key string, format string, args ...interface{}) {
+	this.list = append(this.list, key+": "+fmt.Sprintf(format, args...))
+}
+
+func (this *problems) check(key string, err error) {
+	if err != nil {
+		this.add(key, "%s", err)
+	}
+}
+
+//解析时间间隔，allowEmpty 为true时空字符串解析为0
+func (this *problems) duration(key string, value string, allowEmpty bool) time.Duration {
+	if value == "" && allowEmpty {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		this.add(key, "The duration %q is invalid!", value)
+		return 0
+	}
+	return d
+}
+
+//按名称查找枚举值，names 为各个枚举值的名称
+func (this *problems) enum(key string, value string, names []string) int {
+	for i, name := range names {
+		if name == value {
+			return i
+		}
+	}
+	this.add(key, "The value %q is invalid! It must be one of %v.", value, names)
+	return -1
+}
+
+func (this *problems) absoluteUrls(key string, urls []string) {
+	for i, rawUrl := range urls {
+		u, err := url.Parse(rawUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			this.add(fmt.Sprintf("%s[%d]", key, i), "The url %q is invalid!", rawUrl)
+		}
+	}
+}
+
+//检查配置并生成爬取设置，所有问题会一并返回
+//生成的 Scheduler 不包含条目处理器；除 analyzer 中配置的解析函数外，调用方还可以追加自己的解析函数
+func (this *File) Build() (*Crawl, error) {
+	ps := &problems{}
+	crawl := &Crawl{}
+	config := &crawl.Scheduler
+
+	sched := this.Scheduler
+	config.DataDir = sched.DataDir
+	config.CrawlDepth = sched.CrawlDepth
+	config.ChannelArgs = base.NewChannelArgs(sched.Channels.Request, sched.Channels.Response, sched.Channels.Item, sched.Channels.Error)
+	channels := []struct {
+		key    string
+		length uint
+	}{
+		{"scheduler.channels.request", sched.Channels.Request},
+		{"scheduler.channels.response", sched.Channels.Response},
+		{"scheduler.channels.item", sched.Channels.Item},
+		{"scheduler.channels.error", sched.Channels.Error},
+	}
+	for _, channel := range channels {
+		if channel.length == 0 {
+			ps.add(channel.key, "The channel length must be positive!")
+		}
+	}
+	config.PoolBaseArgs = base.NewPoolBaseArgs(sched.Pools.Downloader, sched.Pools.Analyzer)
+	if sched.Pools.Downloader == 0 {
+		ps.add("scheduler.pools.downloader", "The pool size must be positive!")
+	}
+	if sched.Pools.Analyzer == 0 {
+		ps.add("scheduler.pools.analyzer", "The pool size must be positive!")
+	}
+	frontiers := []scheduler.FrontierStrategy{scheduler.FRONTIER_BFS, scheduler.FRONTIER_DFS, scheduler.FRONTIER_PRIORITY}
+	frontierNames := make([]string, len(frontiers))
+	for i, frontier := range frontiers {
+		frontierNames[i] = frontier.String()
+	}
+	if i := ps.enum("scheduler.frontier", sched.Frontier, frontierNames); i >= 0 {
+		config.FrontierStrategy = frontiers[i]
+	}
+	switch ps.enum("scheduler.dedup", sched.Dedup, []string{"exact", "bloom"}) {
+	case 0:
+		config.DedupStore = middleware.NewExactDedupStore
+	case 1:
+		capacity, errorRate := sched.BloomCapacity, sched.BloomErrorRate
+		if _, err := middleware.NewBloomDedupStore(capacity, errorRate); err != nil {
+			ps.add("scheduler.bloomCapacity", "%s", err)
+		} else {
+			config.DedupStore = func() middleware.DedupStore {
+				store, _ := middleware.NewBloomDedupStore(capacity, errorRate)
+				return store
+			}
+		}
+	}
+
+	this.buildSeeds(ps, config)
+	this.buildScope(ps, config)
+
+	politeness := this.Politeness
+	config.Politeness = base.NewPolitenessArgs(politeness.HostConcurrency, politeness.IpConcurrency,
+		ps.duration("politeness.hostDelay", politeness.HostDelay, true))
+	for domain, override := range politeness.Domains {
+		key := "politeness.domains." + domain
+		if domain == "" {
+			ps.add(key, "The domain is empty!")
+		}
+		config.Politeness.SetDomainPoliteness(domain, override.Concurrency, ps.duration(key+".delay", override.Delay, true))
+	}
+
+	if this.Robots.Obey {
+		if this.Robots.UserAgent == "" {
+			ps.add("robots.userAgent", "The user agent is required when robots.obey is true!")
+		}
+		config.Robots = base.NewRobotsArgs(this.Robots.UserAgent, this.Robots.IgnoreHosts...)
+	}
+
+	retry := this.Retry
+	config.Retry = base.NewRetryArgs(retry.MaxAttempts,
+		ps.duration("retry.baseDelay", retry.BaseDelay, false), ps.duration("retry.maxDelay", retry.MaxDelay, false))
+	config.Retry.SetRetryStatusCodes(retry.StatusCodes...)
+	if retry.MaxAttempts > 1 && config.Retry.MaxDelay() < config.Retry.BaseDelay() {
+		ps.add("retry.maxDelay", "The max delay must not be less than retry.baseDelay!")
+	}
+
+	this.buildDownloader(ps, config)
+	this.buildWarc(ps, crawl)
+	config.RespParsers = this.buildAnalyzer(ps)
+
+	monitoring := this.Monitoring
+	crawl.Monitoring = MonitoringArgs{
+		Interval:      ps.duration("monitoring.interval", monitoring.Interval, false),
+		MaxIdleCount:  monitoring.MaxIdleCount,
+		AutoStop:      monitoring.AutoStop,
+		DetailSummary: monitoring.DetailSummary,
+	}
+
+	if len(ps.list) > 0 {
+		crawl.Close()
+		return nil, &Error{Problems: ps.list}
+	}
+	return crawl, nil
+}
+
+func (this *File) buildSeeds(ps *problems, config *scheduler.Config) {
+	seeds := this.Seeds
+	ps.absoluteUrls("seeds.urls", seeds.Urls)
+	if len(seeds.Urls) > 0 {
+		if httpReq, err := http.NewRequest(http.MethodGet, seeds.Urls[0], nil); err == nil {
+			config.FirstHttpReq = httpReq
+		}
+		if len(seeds.Urls) > 1 {
+			config.Seeds = append(config.Seeds, seed.NewUrlSource(seeds.Urls[1:]...))
+		}
+	}
+	for _, path := range seeds.Files {
+		config.Seeds = append(config.Seeds, seed.NewFileSource(path))
+	}
+
+	sitemaps := seeds.Sitemaps
+	ps.absoluteUrls("seeds.sitemaps.urls", sitemaps.Urls)
+	if sitemaps.Discover || len(sitemaps.Urls) > 0 {
+		config.Sitemaps = base.NewSitemapArgs(sitemaps.Discover, sitemaps.Urls...)
+		config.Sitemaps.SetMaxUrls(sitemaps.MaxUrls)
+		config.Sitemaps.SetMaxDepth(sitemaps.MaxDepth)
+		if sitemaps.Since != "" {
+			since, err := time.Parse("2006-01-02", sitemaps.Since)
+			if err != nil {
+				since, err = time.Parse(time.RFC3339, sitemaps.Since)
+			}
+			if err != nil {
+				ps.add("seeds.sitemaps.since", "The date %q is invalid!", sitemaps.Since)
+			}
+			config.Sitemaps.SetSince(since)
+		}
+		if sitemaps.Discover && len(seeds.Urls) == 0 {
+			ps.add("seeds.sitemaps.discover", "Sitemap discovery requires seeds.urls!")
+		}
+	}
+
+	feeds := seeds.Feeds
+	ps.absoluteUrls("seeds.feeds.urls", feeds.Urls)
+	if len(seeds.Urls) == 0 && len(seeds.Files) == 0 && len(sitemaps.Urls) == 0 && len(feeds.Urls) == 0 {
+		ps.add("seeds.urls", "There is no seed! Set seeds.urls, seeds.files, seeds.sitemaps.urls or seeds.feeds.urls.")
+	}
+	if len(feeds.Urls) > 0 {
+		config.FeedUrls = feeds.Urls
+		config.FeedInterval = ps.duration("seeds.feeds.interval", feeds.Interval, false)
+		if config.FeedInterval == 0 {
+			ps.add("seeds.feeds.interval", "The interval must be positive!")
+		}
+	}
+}
+
+func (this *File) buildScope(ps *problems, config *scheduler.Config) {
+	section := this.Scope
+	if len(section.Domains) == 0 && len(section.DenyDomains) == 0 && len(section.Include) == 0 &&
+		len(section.Exclude) == 0 && len(section.PathPrefixes) == 0 && isDefaultSchemes(section.Schemes) {
+		if len(this.Seeds.Urls) == 0 {
+			ps.add("scope.domains", "The allowed domains are required when seeds.urls is empty!")
+		}
+		return
+	}
+	policy := scope.NewPolicy()
+	if len(section.Schemes) == 0 {
+		ps.add("scope.schemes", "The scheme list is empty!")
+	}
+	policy.AllowSchemes(section.Schemes...)
+	for i, domain := range section.Domains {
+		ps.check(fmt.Sprintf("scope.domains[%d]", i), policy.AllowDomains(domain))
+	}
+	if len(section.Domains) == 0 && len(this.Seeds.Urls) > 0 {
+		//与调度器的默认范围一致，只允许与首个请求同一主域名的URL
+		if u, err := url.Parse(this.Seeds.Urls[0]); err == nil {
+			domain, err := scope.RegistrableDomain(u.Hostname())
+			if err == nil {
+				err = policy.AllowDomains(domain)
+			}
+			ps.check("seeds.urls[0]", err)
+		}
+	}
+	policy.DenyDomains(section.DenyDomains...)
+	for i, pattern := range section.Include {
+		ps.check(fmt.Sprintf("scope.include[%d]", i), policy.Include(pattern))
+	}
+	for i, pattern := range section.Exclude {
+		ps.check(fmt.Sprintf("scope.exclude[%d]", i), policy.Exclude(pattern))
+	}
+	policy.AllowPathPrefixes(section.PathPrefixes...)
+	config.Scope = policy
+}
+
+func isDefaultSchemes(schemes []string) bool {
+	return len(schemes) == 2 && schemes[0] == "http" && schemes[1] == "https"
+}
+
+//生成下载器设置，不包括 WARC 归档，见 buildWarc
+func (this *File) buildDownloader(ps *problems, config *scheduler.Config) {
+	section := this.Downloader
+	timeout := ps.duration("downloader.timeout", section.Timeout, true)
+	config.HttpClientGenerator = func() *http.Client {
+		return &http.Client{Timeout: timeout}
+	}
+	if section.MaxBodySize < 0 {
+		ps.add("downloader.maxBodySize", "The max body size must not be negative!")
+	}
+	config.MaxBodySize = section.MaxBodySize
+	header := http.Header{}
+	for name, value := range section.Headers {
+		header.Set(name, value)
+	}
+	if section.UserAgent != "" {
+		header.Set("User-Agent", section.UserAgent)
+	}
+	if len(header) > 0 {
+		config.DownloaderMiddlewares = append(config.DownloaderMiddlewares, downloader.NewHeaderMiddleware(header))
+	}
+
+	if section.Cache.Dir != "" {
+		policies := []downloader.CachePolicy{downloader.CACHE_POLICY_RFC, downloader.CACHE_POLICY_DEV}
+		names := make([]string, len(policies))
+		for i, policy := range policies {
+			names[i] = policy.String()
+		}
+		if i := ps.enum("downloader.cache.policy", section.Cache.Policy, names); i >= 0 {
+			cache, err := downloader.NewHttpCache(section.Cache.Dir, policies[i], nil)
+			if err != nil {
+				ps.add("downloader.cache.dir", "%s", err)
+			} else {
+				config.DownloaderMiddlewares = append(config.DownloaderMiddlewares, cache)
+			}
+		}
+	}
+	if section.Charset {
+		config.DownloaderMiddlewares = append(config.DownloaderMiddlewares, downloader.NewCharsetMiddleware())
+	}
+
+	proxies := section.Proxies
+	var proxyUrls []*url.URL
+	for i, rawUrl := range proxies.Urls {
+		proxyUrl, err := downloader.ParseProxy(rawUrl)
+		if err != nil {
+			ps.add(fmt.Sprintf("downloader.proxies.urls[%d]", i), "%s", err)
+			continue
+		}
+		proxyUrls = append(proxyUrls, proxyUrl)
+	}
+	if proxies.File != "" {
+		loaded, err := downloader.LoadProxies(proxies.File)
+		if err != nil {
+			ps.add("downloader.proxies.file", "%s", err)
+		}
+		proxyUrls = append(proxyUrls, loaded...)
+	}
+	if len(proxies.Urls) > 0 || proxies.File != "" {
+		rotations := []downloader.ProxyRotation{downloader.PROXY_ROUND_ROBIN, downloader.PROXY_STICKY}
+		names := make([]string, len(rotations))
+		for i, rotation := range rotations {
+			names[i] = rotation.String()
+		}
+		i := ps.enum("downloader.proxies.rotation", proxies.Rotation, names)
+		coolDown := ps.duration("downloader.proxies.coolDown", proxies.CoolDown, false)
+		if i >= 0 && len(proxyUrls) > 0 {
+			pool, err := downloader.NewProxyPool(proxyUrls, rotations[i], proxies.MaxFailures, coolDown)
+			if err != nil {
+				ps.add("downloader.proxies", "%s", err)
+			} else {
+				config.ProxyPool = pool
+			}
+		}
+	}
+}
+
+//生成 WARC 归档中间件，打开的 WARC 文件在爬取结束后关闭
+func (this *File) buildWarc(ps *problems, crawl *Crawl) {
+	section := this.Downloader.Warc
+	if section.Dir == "" {
+		return
+	}
+	if section.MaxSize <= 0 {
+		ps.add("downloader.warc.maxSize", "The max size must be positive!")
+		return
+	}
+	writer, err := warc.NewWriter(section.Dir, section.Prefix, section.MaxSize, section.Compress)
+	if err != nil {
+		ps.add("downloader.warc.dir", "%s", err)
+		return
+	}
+	crawl.closers = append(crawl.closers, writer)
+	recorder, err := downloader.NewWarcRecorder(writer)
+	if err != nil {
+		ps.add("downloader.warc.dir", "%s", err)
+		return
+	}
+	crawl.Scheduler.DownloaderMiddlewares = append(crawl.Scheduler.DownloaderMiddlewares, recorder)
+}
+
+func (this *File) buildAnalyzer(ps *problems) []analyzer.ParseResponse {
+	section := this.Analyzer
+	var parsers []analyzer.ParseResponse
+	if section.Rules != "" {
+		ruleParsers, err := analyzer.LoadRuleParsers(section.Rules)
+		if err != nil {
+			ps.add("analyzer.rules", "%s", err)
+		}
+		parsers = append(parsers, ruleParsers...)
+	}
+	if section.StructuredData {
+		parsers = append(parsers, analyzer.ParseStructuredData)
+	}
+	if section.Feeds {
+		parsers = append(parsers, analyzer.ParseFeed)
+	}
+	return parsers
+}
+
+//只检查 analyzer 部分并生成其中配置的解析函数，用于在调度器之外解析响应
+func (this *File) BuildParsers() ([]analyzer.ParseResponse, error) {
+	ps := &problems{}
+	parsers := this.buildAnalyzer(ps)
+	if len(ps.list) > 0 {
+		return nil, &Error{Problems: ps.list}
+	}
+	return parsers, nil
+}
+
+//只检查 downloader 部分并创建网页下载器，用于在调度器之外下载单个请求
+//下载的响应不写入 WARC 归档
+func (this *File) BuildDownloader() (downloader.PageDownloader, error) {
+	ps := &problems{}
+	crawl := &Crawl{}
+	this.buildDownloader(ps, &crawl.Scheduler)
+	if len(ps.list) > 0 {
+		return nil, &Error{Problems: ps.list}
+	}
+	return crawl.NewPageDownloader()
+}
+
+//按配置创建网页下载器，用于在调度器之外下载单个请求
+//与调度器一样，设置了代理池时代理池作为最后一个中间件
+func (this *Crawl) NewPageDownloader() (downloader.PageDownloader, error) {
+	config := this.Scheduler
+	client := config.HttpClientGenerator()
+	middlewares := config.DownloaderMiddlewares
+	if config.ProxyPool != nil {
+		wrapped, err := config.ProxyPool.Client(client)
+		if err != nil {
+			return nil, err
+		}
+		client = wrapped
+		middlewares = append(append([]downloader.DownloaderMiddleware(nil), middlewares...), config.ProxyPool)
+	}
+	return downloader.NewPageDownloaderWithMaxBodySize(client, config.MaxBodySize, middlewares...), nil
+}
+
+//读取配置文件、应用环境变量覆盖并生成爬取设置
+func LoadCrawl(path string) (*Crawl, error) {
+	file, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return file.Build()
+}
@@ -0,0 +1,279 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/fmyxyz/goreptile/base"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//配置文件
+//时间间隔使用 time.ParseDuration 的格式，如 500ms、10s、1h；日期使用 2006-01-02 或 RFC 3339 格式
+//每个键都可以用环境变量覆盖，见 ApplyEnv
+type File struct {
+	Seeds      SeedsSection      `yaml:"seeds" toml:"seeds"`
+	Scheduler  SchedulerSection  `yaml:"scheduler" toml:"scheduler"`
+	Scope      ScopeSection      `yaml:"scope" toml:"scope"`
+	Politeness PolitenessSection `yaml:"politeness" toml:"politeness"`
+	Robots     RobotsSection     `yaml:"robots" toml:"robots"`
+	Retry      RetrySection      `yaml:"retry" toml:"retry"`
+	Downloader DownloaderSection `yaml:"downloader" toml:"downloader"`
+	Analyzer   AnalyzerSection   `yaml:"analyzer" toml:"analyzer"`
+	Monitoring MonitoringSection `yaml:"monitoring" toml:"monitoring"`
+}
+
+type SeedsSection struct {
+	Urls     []string        `yaml:"urls" toml:"urls" desc:"种子URL，第一个URL作为首个请求并决定默认的爬取范围"`
+	Files    []string        `yaml:"files" toml:"files" desc:"种子文件，每行一个URL或一个JSON种子，以 .gz 结尾的文件会先解压"`
+	Sitemaps SitemapsSection `yaml:"sitemaps" toml:"sitemaps"`
+	Feeds    FeedsSection    `yaml:"feeds" toml:"feeds"`
+}
+
+type SitemapsSection struct {
+	Discover bool     `yaml:"discover" toml:"discover" desc:"是否从首个请求所在站点的 robots.txt 和 /sitemap.xml 发现站点地图"`
+	Urls     []string `yaml:"urls" toml:"urls" desc:"指定的站点地图"`
+	Since    string   `yaml:"since" toml:"since" desc:"只使用该日期之后修改过的URL，为空时不限制"`
+	MaxUrls  uint64   `yaml:"maxUrls" toml:"maxUrls" desc:"最多使用站点地图中的URL数，为0时不限制"`
+	MaxDepth uint32   `yaml:"maxDepth" toml:"maxDepth" desc:"站点地图索引的最大嵌套层数"`
+}
+
+type FeedsSection struct {
+	Urls     []string `yaml:"urls" toml:"urls" desc:"轮询的订阅源（RSS、Atom 或 JSON Feed）"`
+	Interval string   `yaml:"interval" toml:"interval" desc:"订阅源的轮询间隔"`
+}
+
+type SchedulerSection struct {
+	CrawlDepth     uint32          `yaml:"crawlDepth" toml:"crawlDepth" desc:"爬取深度，深度大于该值的请求会被丢弃，种子的深度为0"`
+	DataDir        string          `yaml:"dataDir" toml:"dataDir" desc:"持久化目录，为空时不持久化"`
+	Frontier       string          `yaml:"frontier" toml:"frontier" desc:"请求缓存的调度策略：bfs、dfs 或 priority"`
+	Dedup          string          `yaml:"dedup" toml:"dedup" desc:"去重存储：exact 或 bloom"`
+	BloomCapacity  uint64          `yaml:"bloomCapacity" toml:"bloomCapacity" desc:"布隆过滤器的初始容量"`
+	BloomErrorRate float64         `yaml:"bloomErrorRate" toml:"bloomErrorRate" desc:"布隆过滤器的总误判率"`
+	Channels       ChannelsSection `yaml:"channels" toml:"channels"`
+	Pools          PoolsSection    `yaml:"pools" toml:"pools"`
+}
+
+type ChannelsSection struct {
+	Request  uint `yaml:"request" toml:"request" desc:"请求通道的长度"`
+	Response uint `yaml:"response" toml:"response" desc:"响应通道的长度"`
+	Item     uint `yaml:"item" toml:"item" desc:"条目通道的长度"`
+	Error    uint `yaml:"error" toml:"error" desc:"错误通道的长度"`
+}
+
+type PoolsSection struct {
+	Downloader uint32 `yaml:"downloader" toml:"downloader" desc:"网页下载器池的大小"`
+	Analyzer   uint32 `yaml:"analyzer" toml:"analyzer" desc:"分析器池的大小"`
+}
+
+type ScopeSection struct {
+	Domains      []string `yaml:"domains" toml:"domains" desc:"允许的主机，只允许这些主机及其子域名；为空时只允许与首个请求同一可注册域名的URL"`
+	DenyDomains  []string `yaml:"denyDomains" toml:"denyDomains" desc:"禁止的域名（含子域名）"`
+	Include      []string `yaml:"include" toml:"include" desc:"包含规则（正则表达式），设置后URL至少需要匹配一条"`
+	Exclude      []string `yaml:"exclude" toml:"exclude" desc:"排除规则（正则表达式）"`
+	PathPrefixes []string `yaml:"pathPrefixes" toml:"pathPrefixes" desc:"允许的路径前缀"`
+	Schemes      []string `yaml:"schemes" toml:"schemes" desc:"允许的协议"`
+}
+
+type PolitenessSection struct {
+	HostConcurrency uint32                      `yaml:"hostConcurrency" toml:"hostConcurrency" desc:"每个主机的最大并发数，为0时不限制"`
+	IpConcurrency   uint32                      `yaml:"ipConcurrency" toml:"ipConcurrency" desc:"每个IP的最大并发数，为0时不限制"`
+	HostDelay       string                      `yaml:"hostDelay" toml:"hostDelay" desc:"同一主机两次请求之间的最小间隔"`
+	Domains         map[string]DomainPoliteness `yaml:"domains" toml:"domains" desc:"按域名覆盖的礼貌策略，值包括 concurrency 和 delay"`
+}
+
+type DomainPoliteness struct {
+	Concurrency uint32 `yaml:"concurrency" toml:"concurrency"`
+	Delay       string `yaml:"delay" toml:"delay"`
+}
+
+type RobotsSection struct {
+	Obey        bool     `yaml:"obey" toml:"obey" desc:"是否遵守 robots.txt"`
+	UserAgent   string   `yaml:"userAgent" toml:"userAgent" desc:"匹配 robots.txt 规则时使用的 User-agent"`
+	IgnoreHosts []string `yaml:"ignoreHosts" toml:"ignoreHosts" desc:"忽略 robots.txt 的主机"`
+}
+
+type RetrySection struct {
+	MaxAttempts uint32 `yaml:"maxAttempts" toml:"maxAttempts" desc:"最大尝试次数（包括第一次），不大于1时不重试"`
+	BaseDelay   string `yaml:"baseDelay" toml:"baseDelay" desc:"第一次重试前的等待时间"`
+	MaxDelay    string `yaml:"maxDelay" toml:"maxDelay" desc:"最长等待时间"`
+	StatusCodes []int  `yaml:"statusCodes" toml:"statusCodes" desc:"需要重试的响应状态码"`
+}
+
+type DownloaderSection struct {
+	UserAgent   string            `yaml:"userAgent" toml:"userAgent" desc:"请求的 User-Agent，为空时不设置"`
+	Headers     map[string]string `yaml:"headers" toml:"headers" desc:"请求头"`
+	Timeout     string            `yaml:"timeout" toml:"timeout" desc:"HTTP请求的超时时间，为空时不限制"`
+	MaxBodySize int64             `yaml:"maxBodySize" toml:"maxBodySize" desc:"响应体的最大长度（字节），为0时不限制"`
+	Charset     bool              `yaml:"charset" toml:"charset" desc:"是否把非 UTF-8 编码的网页转码为 UTF-8"`
+	Cache       CacheSection      `yaml:"cache" toml:"cache"`
+	Warc        WarcSection       `yaml:"warc" toml:"warc"`
+	Proxies     ProxiesSection    `yaml:"proxies" toml:"proxies"`
+}
+
+type CacheSection struct {
+	Dir    string `yaml:"dir" toml:"dir" desc:"HTTP缓存目录，为空时不缓存"`
+	Policy string `yaml:"policy" toml:"policy" desc:"缓存策略：rfc 或 dev"`
+}
+
+type WarcSection struct {
+	Dir      string `yaml:"dir" toml:"dir" desc:"WARC 归档目录，为空时不归档"`
+	Prefix   string `yaml:"prefix" toml:"prefix" desc:"WARC 文件名前缀"`
+	MaxSize  int64  `yaml:"maxSize" toml:"maxSize" desc:"单个 WARC 文件的最大长度（字节）"`
+	Compress bool   `yaml:"compress" toml:"compress" desc:"是否使用 gzip 压缩 WARC 记录"`
+}
+
+type ProxiesSection struct {
+	Urls        []string `yaml:"urls" toml:"urls" desc:"代理（http、https 或 socks5）"`
+	File        string   `yaml:"file" toml:"file" desc:"代理文件，每行一个代理"`
+	Rotation    string   `yaml:"rotation" toml:"rotation" desc:"代理的轮换方式：roundRobin 或 sticky"`
+	MaxFailures uint32   `yaml:"maxFailures" toml:"maxFailures" desc:"代理连续失败该次数后暂停使用"`
+	CoolDown    string   `yaml:"coolDown" toml:"coolDown" desc:"代理暂停使用的时间"`
+}
+
+type AnalyzerSection struct {
+	Rules          string `yaml:"rules" toml:"rules" desc:"抽取规则文件（YAML 或 JSON）"`
+	StructuredData bool   `yaml:"structuredData" toml:"structuredData" desc:"是否抽取 JSON-LD、Microdata、RDFa 和 OpenGraph 数据"`
+	Feeds          bool   `yaml:"feeds" toml:"feeds" desc:"是否把响应按订阅源解析"`
+}
+
+type MonitoringSection struct {
+	Interval      string `yaml:"interval" toml:"interval" desc:"检查调度器状态的间隔"`
+	MaxIdleCount  uint   `yaml:"maxIdleCount" toml:"maxIdleCount" desc:"连续空闲该次数后视为爬取完成"`
+	AutoStop      bool   `yaml:"autoStop" toml:"autoStop" desc:"爬取完成后是否自动停止调度器"`
+	DetailSummary bool   `yaml:"detailSummary" toml:"detailSummary" desc:"是否记录详细的摘要信息"`
+}
+
+//默认配置
+func Default() *File {
+	return &File{
+		Seeds: SeedsSection{
+			Sitemaps: SitemapsSection{MaxDepth: base.DefaultSitemapMaxDepth},
+			Feeds:    FeedsSection{Interval: "10m"},
+		},
+		Scheduler: SchedulerSection{
+			CrawlDepth:     10,
+			Frontier:       "bfs",
+			Dedup:          "exact",
+			BloomCapacity:  1000000,
+			BloomErrorRate: 0.001,
+			Channels:       ChannelsSection{Request: 10, Response: 10, Item: 10, Error: 10},
+			Pools:          PoolsSection{Downloader: 3, Analyzer: 3},
+		},
+		Scope: ScopeSection{
+			Schemes: []string{"http", "https"},
+		},
+		Robots: RobotsSection{
+			UserAgent: "goreptile",
+		},
+		Retry: RetrySection{
+			MaxAttempts: 1,
+			BaseDelay:   "1s",
+			MaxDelay:    "30s",
+			StatusCodes: append([]int(nil), base.DefaultRetryStatusCodes...),
+		},
+		Downloader: DownloaderSection{
+			Timeout:     "30s",
+			MaxBodySize: 10 << 20,
+			Charset:     true,
+			Cache:       CacheSection{Policy: "rfc"},
+			Warc:        WarcSection{Prefix: "goreptile", MaxSize: 1 << 30, Compress: true},
+			Proxies:     ProxiesSection{Rotation: "roundRobin", MaxFailures: 3, CoolDown: "1m"},
+		},
+		Monitoring: MonitoringSection{
+			Interval:      "10ms",
+			MaxIdleCount:  10000,
+			AutoStop:      true,
+			DetailSummary: true,
+		},
+	}
+}
+
+//读取配置文件并应用环境变量覆盖
+//path 为空时只使用默认配置和环境变量；按扩展名判断格式，.toml 为 TOML，其他为 YAML（也可以是 JSON）
+func Load(path string) (*File, error) {
+	file, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+//读取配置文件，不应用环境变量，path 为空时返回默认配置
+func LoadFile(path string) (*File, error) {
+	file := Default()
+	if path == "" {
+		return file, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format := FORMAT_YAML
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		format = FORMAT_TOML
+	}
+	if err := file.Decode(bytes.NewReader(data), format); err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", path, err))
+	}
+	return file, nil
+}
+
+//配置文件的格式
+const (
+	FORMAT_YAML = "yaml"
+	FORMAT_TOML = "toml"
+)
+
+//解码配置，文件中没有的键保持原值，未知的键会导致错误
+func (this *File) Decode(reader io.Reader, format string) error {
+	switch format {
+	case FORMAT_YAML:
+		decoder := yaml.NewDecoder(reader)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(this); err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	case FORMAT_TOML:
+		meta, err := toml.NewDecoder(reader).Decode(this)
+		if err != nil {
+			return err
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			sort.Strings(keys)
+			return errors.New(fmt.Sprintf("Unknown keys: %s", strings.Join(keys, ", ")))
+		}
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Unsupported config format %q!", format))
+}
+
+//按给定格式输出配置，输出的配置可以用 Decode 或 Load 读回
+func (this *File) Encode(writer io.Writer, format string) error {
+	switch format {
+	case FORMAT_YAML:
+		encoder := yaml.NewEncoder(writer)
+		if err := encoder.Encode(this); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case FORMAT_TOML:
+		return toml.NewEncoder(writer).Encode(this)
+	}
+	return errors.New(fmt.Sprintf("Unsupported config format %q!", format))
+}
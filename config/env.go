@@ -0,0 +1,195 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//环境变量的前缀
+const ENV_PREFIX = "GOREPTILE"
+
+//配置中的一个键
+type Key struct {
+	Path        string //键的路径，如 scheduler.channels.request
+	Env         string //覆盖该键的环境变量，不能用环境变量覆盖时为空
+	Type        string
+	Default     string
+	Description string
+}
+
+//配置的模式，按文件中的顺序列出所有的键及其默认值
+func Schema() []Key {
+	var keys []Key
+	walkFields(reflect.ValueOf(Default()).Elem(), "", func(path string, field reflect.StructField, value reflect.Value) {
+		key := Key{
+			Path:        path,
+			Type:        typeName(field.Type),
+			Default:     formatValue(value),
+			Description: field.Tag.Get("desc"),
+		}
+		if field.Type.Kind() != reflect.Map {
+			key.Env = envName(path)
+		}
+		keys = append(keys, key)
+	})
+	return keys
+}
+
+//输出配置的模式
+func WriteSchema(writer io.Writer) error {
+	for _, key := range Schema() {
+		env := key.Env
+		if env == "" {
+			env = "-"
+		}
+		if _, err := fmt.Fprintf(writer, "%s (%s, default: %s, env: %s)\n    %s\n", key.Path, key.Type, key.Default, env, key.Description); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//用环境变量覆盖配置
+//环境变量名为 GOREPTILE_ 加上键的路径，路径中的 . 和驼峰分隔均替换为 _ 并转为大写，
+//如 scheduler.channels.request 对应 GOREPTILE_SCHEDULER_CHANNELS_REQUEST，
+//downloader.maxBodySize 对应 GOREPTILE_DOWNLOADER_MAX_BODY_SIZE
+//列表的值以逗号分隔，映射类型的键不能用环境变量覆盖
+//所有无法解析的环境变量会一并返回
+func (this *File) ApplyEnv(lookupEnv func(name string) (string, bool)) error {
+	var problems []string
+	walkFields(reflect.ValueOf(this).Elem(), "", func(path string, field reflect.StructField, value reflect.Value) {
+		if field.Type.Kind() == reflect.Map {
+			return
+		}
+		name := envName(path)
+		raw, ok := lookupEnv(name)
+		if !ok {
+			return
+		}
+		if err := setValue(value, raw); err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s): %s", name, path, err))
+		}
+	})
+	if len(problems) > 0 {
+		return &Error{Problems: problems}
+	}
+	return nil
+}
+
+//遍历结构体中的叶子字段，path 为以 yaml 标签组成的路径
+func walkFields(value reflect.Value, prefix string, visit func(path string, field reflect.StructField, value reflect.Value)) {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		if field.Type.Kind() == reflect.Struct {
+			walkFields(value.Field(i), path, visit)
+			continue
+		}
+		visit(path, field, value.Field(i))
+	}
+}
+
+//键的路径对应的环境变量名
+func envName(path string) string {
+	var builder strings.Builder
+	builder.WriteString(ENV_PREFIX)
+	for _, part := range strings.Split(path, ".") {
+		builder.WriteByte('_')
+		for i, r := range part {
+			if i > 0 && unicode.IsUpper(r) {
+				builder.WriteByte('_')
+			}
+			builder.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return builder.String()
+}
+
+func typeName(fieldType reflect.Type) string {
+	switch fieldType.Kind() {
+	case reflect.Slice:
+		return "list of " + typeName(fieldType.Elem())
+	case reflect.Map:
+		return "map of " + typeName(fieldType.Elem())
+	case reflect.Struct:
+		return "table"
+	}
+	return fieldType.Kind().String()
+}
+
+func formatValue(value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.String:
+		return strconv.Quote(value.String())
+	case reflect.Slice:
+		items := make([]string, value.Len())
+		for i := range items {
+			items[i] = formatValue(value.Index(i))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Map:
+		return "{}"
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+//解析环境变量的值并设置到字段
+func setValue(value reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return errors.New(fmt.Sprintf("The value %q is not a bool!", raw))
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+		if err != nil {
+			return errors.New(fmt.Sprintf("The value %q is not an integer of %d bits!", raw, value.Type().Bits()))
+		}
+		value.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, value.Type().Bits())
+		if err != nil {
+			return errors.New(fmt.Sprintf("The value %q is not an unsigned integer of %d bits!", raw, value.Type().Bits()))
+		}
+		value.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, value.Type().Bits())
+		if err != nil {
+			return errors.New(fmt.Sprintf("The value %q is not a number!", raw))
+		}
+		value.SetFloat(f)
+	case reflect.Slice:
+		var parts []string
+		if raw != "" {
+			parts = strings.Split(raw, ",")
+		}
+		slice := reflect.MakeSlice(value.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setValue(slice.Index(i), part); err != nil {
+				return errors.New(fmt.Sprintf("item %d: %s", i, err))
+			}
+		}
+		value.Set(slice)
+	default:
+		return errors.New(fmt.Sprintf("Unsupported type %s!", value.Type()))
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func lookupIn(env map[string]string) func(name string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"seeds.urls", "GOREPTILE_SEEDS_URLS"},
+		{"scheduler.channels.request", "GOREPTILE_SCHEDULER_CHANNELS_REQUEST"},
+		{"downloader.maxBodySize", "GOREPTILE_DOWNLOADER_MAX_BODY_SIZE"},
+		{"seeds.sitemaps.maxUrls", "GOREPTILE_SEEDS_SITEMAPS_MAX_URLS"},
+	}
+	for _, test := range tests {
+		if got := envName(test.path); got != test.want {
+			t.Errorf("envName(%s) = %s, want %s", test.path, got, test.want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(file *File) bool
+	}{
+		{
+			name:  "nothing set keeps defaults",
+			env:   map[string]string{"GOREPTILE_UNKNOWN": "1"},
+			check: func(file *File) bool { return reflect.DeepEqual(file, Default()) },
+		},
+		{
+			name:  "string",
+			env:   map[string]string{"GOREPTILE_SCHEDULER_FRONTIER": " priority "},
+			check: func(file *File) bool { return file.Scheduler.Frontier == "priority" },
+		},
+		{
+			name:  "bool",
+			env:   map[string]string{"GOREPTILE_ROBOTS_OBEY": "true", "GOREPTILE_DOWNLOADER_CHARSET": "0"},
+			check: func(file *File) bool { return file.Robots.Obey && !file.Downloader.Charset },
+		},
+		{
+			name: "numbers",
+			env: map[string]string{
+				"GOREPTILE_SCHEDULER_CRAWL_DEPTH":      "3",
+				"GOREPTILE_DOWNLOADER_MAX_BODY_SIZE":   "-1",
+				"GOREPTILE_SCHEDULER_BLOOM_ERROR_RATE": "0.05",
+				"GOREPTILE_SCHEDULER_CHANNELS_REQUEST": "100",
+			},
+			check: func(file *File) bool {
+				return file.Scheduler.CrawlDepth == 3 && file.Downloader.MaxBodySize == -1 &&
+					file.Scheduler.BloomErrorRate == 0.05 && file.Scheduler.Channels.Request == 100
+			},
+		},
+		{
+			name: "lists",
+			env: map[string]string{
+				"GOREPTILE_SEEDS_URLS":         "http://a/, http://b/",
+				"GOREPTILE_RETRY_STATUS_CODES": "500,503",
+				"GOREPTILE_SCOPE_SCHEMES":      "",
+			},
+			check: func(file *File) bool {
+				return reflect.DeepEqual(file.Seeds.Urls, []string{"http://a/", "http://b/"}) &&
+					reflect.DeepEqual(file.Retry.StatusCodes, []int{500, 503}) &&
+					len(file.Scope.Schemes) == 0
+			},
+		},
+		{
+			name:  "maps are not overridden",
+			env:   map[string]string{"GOREPTILE_DOWNLOADER_HEADERS": "Accept: */*"},
+			check: func(file *File) bool { return file.Downloader.Headers == nil },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := Default()
+			if err := file.ApplyEnv(lookupIn(test.env)); err != nil {
+				t.Fatal(err)
+			}
+			if !test.check(file) {
+				t.Fatalf("unexpected config after applying %v: %+v", test.env, file)
+			}
+		})
+	}
+}
+
+func TestApplyEnvErrors(t *testing.T) {
+	env := map[string]string{
+		"GOREPTILE_ROBOTS_OBEY":              "maybe",
+		"GOREPTILE_SCHEDULER_CRAWL_DEPTH":    "-1",
+		"GOREPTILE_DOWNLOADER_MAX_BODY_SIZE": "10MB",
+		"GOREPTILE_RETRY_STATUS_CODES":       "500,x",
+		"GOREPTILE_SCHEDULER_FRONTIER":       "dfs",
+	}
+	file := Default()
+	err := file.ApplyEnv(lookupIn(env))
+	configErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("ApplyEnv returned %v, want *Error", err)
+	}
+	//所有无法解析的环境变量一并返回
+	if len(configErr.Problems) != 4 {
+		t.Fatalf("%d problems, want 4: %s", len(configErr.Problems), err)
+	}
+	for _, name := range []string{"GOREPTILE_ROBOTS_OBEY", "GOREPTILE_SCHEDULER_CRAWL_DEPTH", "GOREPTILE_DOWNLOADER_MAX_BODY_SIZE", "GOREPTILE_RETRY_STATUS_CODES"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("the error does not mention %s: %s", name, err)
+		}
+	}
+	if file.Scheduler.Frontier != "dfs" {
+		t.Errorf("the valid variable is not applied, frontier is %s", file.Scheduler.Frontier)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		data    string
+		wantErr string //错误中应包含的内容，为空时不应出错
+	}{
+		{
+			name:   "yaml",
+			format: FORMAT_YAML,
+			data:   "seeds:\n  urls: [\"http://a/\"]\nscheduler:\n  crawlDepth: 2\n",
+		},
+		{
+			name:   "empty yaml",
+			format: FORMAT_YAML,
+			data:   "",
+		},
+		{
+			name:   "toml",
+			format: FORMAT_TOML,
+			data:   "[seeds]\nurls = [\"http://a/\"]\n[scheduler]\ncrawlDepth = 2\n",
+		},
+		{
+			name:    "unknown yaml key",
+			format:  FORMAT_YAML,
+			data:    "scheduler:\n  crawlDepht: 2\n",
+			wantErr: "crawlDepht",
+		},
+		{
+			name:    "unknown yaml section",
+			format:  FORMAT_YAML,
+			data:    "schedular:\n  crawlDepth: 2\n",
+			wantErr: "schedular",
+		},
+		{
+			name:    "unknown toml keys",
+			format:  FORMAT_TOML,
+			data:    "[scheduler]\ncrawlDepht = 2\n[scheduler.channels]\nrequests = 1\n",
+			wantErr: "Unknown keys: scheduler.channels.requests, scheduler.crawlDepht",
+		},
+		{
+			name:    "wrong type",
+			format:  FORMAT_YAML,
+			data:    "scheduler:\n  crawlDepth: deep\n",
+			wantErr: "deep",
+		},
+		{
+			name:    "unsupported format",
+			format:  "ini",
+			data:    "",
+			wantErr: "Unsupported config format",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := Default()
+			err := file.Decode(strings.NewReader(test.data), test.format)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Decode returned %v, want an error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Default()
+			if test.data != "" {
+				want.Seeds.Urls = []string{"http://a/"}
+				want.Scheduler.CrawlDepth = 2
+			}
+			//文件中没有的键保持默认值
+			if !reflect.DeepEqual(file, want) {
+				t.Fatalf("decoded %+v, want %+v", file, want)
+			}
+		})
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	for _, format := range []string{FORMAT_YAML, FORMAT_TOML} {
+		t.Run(format, func(t *testing.T) {
+			file := Default()
+			file.Seeds.Urls = []string{"http://a/"}
+			file.Politeness.Domains = map[string]DomainPoliteness{"example.com": {Concurrency: 2, Delay: "1s"}}
+			file.Downloader.Headers = map[string]string{"Accept": "*/*"}
+			var buffer bytes.Buffer
+			if err := file.Encode(&buffer, format); err != nil {
+				t.Fatal(err)
+			}
+			encoded := buffer.String()
+			decoded := &File{}
+			if err := decoded.Decode(&buffer, format); err != nil {
+				t.Fatal(err)
+			}
+			//空列表读回后不再是nil，比较再次输出的配置
+			var again bytes.Buffer
+			if err := decoded.Encode(&again, format); err != nil {
+				t.Fatal(err)
+			}
+			if again.String() != encoded {
+				t.Fatalf("encoded again:\n%s\nwant:\n%s", again.String(), encoded)
+			}
+			if !reflect.DeepEqual(decoded.Politeness.Domains, file.Politeness.Domains) || !reflect.DeepEqual(decoded.Seeds.Urls, file.Seeds.Urls) {
+				t.Fatalf("decoded %+v, want %+v", decoded, file)
+			}
+		})
+	}
+}
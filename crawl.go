@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"github.com/fmyxyz/goreptile/config"
+	"github.com/fmyxyz/goreptile/itempipeline"
+	sched "github.com/fmyxyz/goreptile/scheduler"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+//持久化目录中保存爬取配置的文件，resume 从该文件恢复配置
+const jobFileName = "crawl.yaml"
+
+func runCrawl(flags *flag.FlagSet, args []string) error {
+	configPath := flags.String("config", "", "config `file` (YAML or TOML)")
+	var seeds, seedFiles, domains stringList
+	flags.Var(&seeds, "seed", "seed `url`, may be repeated; replaces seeds.urls")
+	flags.Var(&seedFiles, "seeds", "seed `file` with one url or JSON seed per line, may be repeated; replaces seeds.files")
+	depth := flags.Uint("depth", 0, "crawl depth; overrides scheduler.crawlDepth")
+	flags.Var(&domains, "domain", "allowed `domain`, may be repeated; replaces scope.domains")
+	output := flags.String("output", "items.jsonl", "append the items as JSON lines to this `file`, - for standard output")
+	dataDir := flags.String("data", "", "persist the crawl to this `dir` so that it can be resumed; overrides scheduler.dataDir")
+	flags.Parse(args)
+
+	//命令行参数覆盖配置文件和环境变量中的设置
+	override := func(file *config.File) {
+		if urls := append(seeds, flags.Args()...); len(urls) > 0 {
+			file.Seeds.Urls = urls
+		}
+		if len(seedFiles) > 0 {
+			file.Seeds.Files = seedFiles
+		}
+		if flagGiven(flags, "depth") {
+			file.Scheduler.CrawlDepth = uint32(*depth)
+		}
+		if len(domains) > 0 {
+			file.Scope.Domains = domains
+		}
+		if flagGiven(flags, "data") {
+			file.Scheduler.DataDir = *dataDir
+		}
+	}
+	file, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	override(file)
+	crawl, err := file.Build()
+	if err != nil {
+		return err
+	}
+	if dir := file.Scheduler.DataDir; dir != "" {
+		//保存的配置不包括环境变量的值（可能是代理的密码等），resume 时重新应用环境变量
+		job, err := config.LoadFile(*configPath)
+		if err == nil {
+			override(job)
+			err = saveJob(job, dir)
+		}
+		if err != nil {
+			crawl.Close()
+			return err
+		}
+	}
+	return runScheduler(crawl, *output)
+}
+
+func runResume(flags *flag.FlagSet, args []string) error {
+	output := flags.String("output", "items.jsonl", "append the items as JSON lines to this `file`, - for standard output")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("The data dir is required!")
+	}
+	dir := flags.Arg(0)
+	path := filepath.Join(dir, jobFileName)
+	if _, err := os.Stat(path); err != nil {
+		return errors.New(fmt.Sprintf("There is no crawl to resume in %q! Start one with crawl -data. (%s)", dir, err))
+	}
+	file, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	file.Scheduler.DataDir = dir
+	crawl, err := file.Build()
+	if err != nil {
+		return err
+	}
+	return runScheduler(crawl, *output)
+}
+
+//把配置保存到持久化目录，相对路径按启动爬取时的工作目录转换为绝对路径，因此可以在其他目录中 resume
+//配置中可能有请求头、代理的密码等，文件只允许当前用户读写
+func saveJob(file *config.File, dir string) error {
+	if err := absPaths(file); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	job, err := os.OpenFile(filepath.Join(dir, jobFileName), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := file.Encode(job, config.FORMAT_YAML); err != nil {
+		job.Close()
+		return err
+	}
+	return job.Close()
+}
+
+//把配置中的文件和目录转换为绝对路径
+func absPaths(file *config.File) error {
+	paths := []*string{
+		&file.Analyzer.Rules,
+		&file.Downloader.Cache.Dir,
+		&file.Downloader.Warc.Dir,
+		&file.Downloader.Proxies.File,
+	}
+	files := make([]string, len(file.Seeds.Files))
+	copy(files, file.Seeds.Files)
+	file.Seeds.Files = files
+	for i := range files {
+		paths = append(paths, &files[i])
+	}
+	for _, path := range paths {
+		if *path == "" || filepath.IsAbs(*path) {
+			continue
+		}
+		absPath, err := filepath.Abs(*path)
+		if err != nil {
+			return err
+		}
+		*path = absPath
+	}
+	return nil
+}
+
+//启动调度器并等待爬取结束，收到中断信号时停止调度器，持久化的爬取可以用 resume 继续
+func runScheduler(crawl *config.Crawl, output string) error {
+	defer crawl.Close()
+	items, err := newItemWriter(output)
+	if err != nil {
+		return err
+	}
+	defer items.close()
+	crawl.Scheduler.RespParsers = respParsers(crawl.Scheduler.RespParsers)
+	crawl.Scheduler.ItemProcessors = []itempipeline.ProcessItem{items.write}
+
+	scheduler, err := sched.StartScheduler(crawl.Scheduler)
+	if err != nil {
+		return err
+	}
+	checkChan := crawl.Monitor(scheduler, record)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	select {
+	case <-checkChan:
+	case sig := <-signals:
+		record(1, fmt.Sprintf("Received %s, stopping the scheduler...", sig))
+		scheduler.Stop()
+	}
+	record(0, fmt.Sprintf("%d items written to %s", items.count(), output))
+	return nil
+}
+
+//把条目以 JSON 行的形式写入输出的条目处理器
+type itemWriter struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer //输出为标准输出时为nil
+	written uint64
+	closed  bool
+}
+
+func newItemWriter(output string) (*itemWriter, error) {
+	if output == "-" {
+		return &itemWriter{encoder: json.NewEncoder(os.Stdout)}, nil
+	}
+	file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &itemWriter{encoder: json.NewEncoder(file), closer: file}, nil
+}
+
+func (this *itemWriter) write(item base.Item) (base.Item, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.closed {
+		return nil, errors.New("The item output is closed!")
+	}
+	if err := this.encoder.Encode(item); err != nil {
+		return nil, err
+	}
+	this.written++
+	return item, nil
+}
+
+func (this *itemWriter) count() uint64 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.written
+}
+
+func (this *itemWriter) close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	if this.closer == nil {
+		return nil
+	}
+	return this.closer.Close()
+}
@@ -0,0 +1,112 @@
+package downloader
+
+import (
+	"bytes"
+	"github.com/fmyxyz/goreptile/base"
+	"golang.org/x/net/html/charset"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+//记录响应原始字符集的响应头
+const CharsetHeader = "X-Reptile-Charset"
+
+var utf8Bom = []byte{0xef, 0xbb, 0xbf}
+
+//判断内容类型是否为文本，只有文本内容才需要转码
+func textContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		strings.HasSuffix(mediaType, "+json") {
+		return true
+	}
+	switch mediaType {
+	case "application/xml", "application/json", "application/javascript", "application/x-javascript":
+		return true
+	}
+	return false
+}
+
+//判断内容类型是否为 JSON，JSON 只能使用 UTF-8 编码（RFC 8259）
+func jsonContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+//把文本内容转换为 UTF-8 编码
+//依次根据 BOM、contentType 中的 charset 参数和 HTML 中的 <meta charset> 判断编码，
+//都没有时按 HTML5 规范猜测编码，猜测的编码不确定而内容是有效的 UTF-8 时不转码；
+//JSON 内容总是按 UTF-8 处理；返回转换后的内容和原始字符集名称
+func ToUTF8(content []byte, contentType string) ([]byte, string, error) {
+	if bytes.HasPrefix(content, utf8Bom) {
+		return content[len(utf8Bom):], "utf-8", nil
+	}
+	if jsonContentType(contentType) {
+		return content, "utf-8", nil
+	}
+	encoding, name, certain := charset.DetermineEncoding(content, contentType)
+	if name == "utf-8" || (!certain && utf8.Valid(content)) {
+		return content, "utf-8", nil
+	}
+	decoded, err := encoding.NewDecoder().Bytes(content)
+	if err != nil {
+		return nil, name, err
+	}
+	return decoded, name, nil
+}
+
+//字符集转码中间件，把文本响应的响应体转换为 UTF-8 编码
+//转码后 Content-Type 的 charset 参数改为 utf-8，原始字符集记录在 CharsetHeader 响应头中
+//响应体使用无法解压的压缩编码（如 br）时不做处理
+type charsetMiddleware struct{}
+
+//创建字符集转码中间件
+func NewCharsetMiddleware() DownloaderMiddleware {
+	return &charsetMiddleware{}
+}
+
+func (this *charsetMiddleware) ProcessRequest(req *base.Request) (*base.Response, error) {
+	return nil, nil
+}
+
+func (this *charsetMiddleware) ProcessResponse(req *base.Request, resp *base.Response, err error) (*base.Response, error) {
+	if err != nil || resp == nil || resp.HttpReq() == nil || resp.HttpReq().Body == nil {
+		return resp, err
+	}
+	httpResp := resp.HttpReq()
+	body, readErr := resp.Body()
+	if readErr != nil {
+		return nil, readErr
+	}
+	if encoding := httpResp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return resp, nil
+	}
+	contentType := httpResp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	if !textContentType(contentType) {
+		return resp, nil
+	}
+	decoded, name, decodeErr := ToUTF8(body, contentType)
+	if decodeErr != nil {
+		logger.Printf("Failed to decode the response body from %s (requestUrl='%s'): %s\n", name, req.HttpReq().URL, decodeErr)
+		return resp, nil
+	}
+	httpResp.Header.Set(CharsetHeader, name)
+	if mediaType, params, err := mime.ParseMediaType(contentType); err == nil {
+		params["charset"] = "utf-8"
+		httpResp.Header.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+	}
+	resp.SetBody(decoded)
+	return resp, nil
+}
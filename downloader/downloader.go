@@ -5,10 +5,20 @@ import (
 	"fmt"
 	"github.com/fmyxyz/goreptile/base"
 	"github.com/fmyxyz/goreptile/middleware"
+	"io"
+	"log"
 	"net/http"
+	"os"
 	"reflect"
 )
 
+var logger = log.New(os.Stdout, "downloader:", log.LstdFlags)
+
+//设置日志的输出位置，默认为标准输出
+func SetLogOutput(w io.Writer) {
+	logger.SetOutput(w)
+}
+
 //网页下载器
 type PageDownloader interface {
 	Id() uint32
@@ -23,9 +33,14 @@ type PageDownloaderPool interface {
 	Used() uint32
 }
 
+//默认的响应体最大长度
+const DefaultMaxBodySize int64 = 10 << 20
+
 type myPageDownloader struct {
-	httpClient http.Client
-	id         uint32
+	httpClient  http.Client
+	id          uint32
+	middlewares middlewareChain //下载器中间件
+	maxBodySize int64           //响应体最大长度，为0时不限制
 }
 
 func (this *myPageDownloader) Id() uint32 {
@@ -33,11 +48,20 @@ func (this *myPageDownloader) Id() uint32 {
 }
 
 func (this *myPageDownloader) Download(req base.Request) (*base.Response, error) {
+	return this.middlewares.download(&req, this.do)
+}
+
+//发出http请求，并缓冲（必要时解压）响应体
+func (this *myPageDownloader) do(req *base.Request) (*base.Response, error) {
 	httpResp, err := this.httpClient.Do(req.HttpReq())
 	if err != nil {
 		return nil, err
 	}
-	return base.NewResponse(httpResp, req.Depth()), nil
+	resp := base.NewResponse(httpResp, req.Depth())
+	if err := resp.Buffer(this.maxBodySize); err != nil {
+		return nil, err
+	}
+	return resp, nil
 }
 
 var downloaderIdGenertor middleware.IdGenertor = middleware.NewCyclicIdGenertor()
@@ -47,13 +71,30 @@ func genDownloaderId() uint32 {
 }
 
 func NewPageDownloader(client *http.Client) PageDownloader {
+	return NewPageDownloaderWithMiddlewares(client)
+}
+
+//创建带中间件的网页下载器，中间件按给定的顺序处理请求
+//响应体的最大长度为 DefaultMaxBodySize
+func NewPageDownloaderWithMiddlewares(client *http.Client, middlewares ...DownloaderMiddleware) PageDownloader {
+	return NewPageDownloaderWithMaxBodySize(client, DefaultMaxBodySize, middlewares...)
+}
+
+//创建限制响应体长度的网页下载器，maxBodySize 为0时不限制
+//响应体超过最大长度时下载返回错误
+func NewPageDownloaderWithMaxBodySize(client *http.Client, maxBodySize int64, middlewares ...DownloaderMiddleware) PageDownloader {
 	var id = genDownloaderId()
 	if client == nil {
 		client = &http.Client{}
 	}
+	if maxBodySize < 0 {
+		maxBodySize = 0
+	}
 	return &myPageDownloader{
-		id:         id,
-		httpClient: *client,
+		id:          id,
+		httpClient:  *client,
+		middlewares: middlewareChain(middlewares),
+		maxBodySize: maxBodySize,
 	}
 }
 
@@ -0,0 +1,81 @@
+package downloader
+
+import (
+	"errors"
+	"github.com/fmyxyz/goreptile/base"
+	"net/http"
+)
+
+//丢弃请求，中间件返回该错误时请求不会被下载，也不会被当作下载错误
+var ErrDropRequest = errors.New("The request is dropped by downloader middleware!")
+
+//下载器中间件
+//同一个中间件会被下载器池中的所有下载器共用，需要保证并发安全
+type DownloaderMiddleware interface {
+	//处理发出的请求，按中间件的顺序依次调用，可以直接修改请求
+	//返回非nil的响应时不再发出请求，以该响应作为下载结果
+	//返回错误时不再发出请求，返回 ErrDropRequest 表示丢弃该请求
+	ProcessRequest(req *base.Request) (*base.Response, error)
+	//处理下载结果，按中间件的相反顺序依次调用
+	//resp 和 err 为下载（或后一个中间件）的结果，返回值作为新的结果，
+	//可以检查或替换响应，也可以把错误转换为响应
+	ProcessResponse(req *base.Request, resp *base.Response, err error) (*base.Response, error)
+}
+
+//下载器中间件链
+type middlewareChain []DownloaderMiddleware
+
+//依次执行中间件并下载
+//只有 ProcessRequest 被调用过的中间件才会处理结果
+func (this middlewareChain) download(req *base.Request, do func(req *base.Request) (*base.Response, error)) (*base.Response, error) {
+	var resp *base.Response
+	var err error
+	called := 0
+	for _, middleware := range this {
+		called++
+		resp, err = middleware.ProcessRequest(req)
+		if resp != nil || err != nil {
+			break
+		}
+	}
+	if resp == nil && err == nil {
+		resp, err = do(req)
+	}
+	if err == ErrDropRequest {
+		return nil, err
+	}
+	for i := called - 1; i >= 0; i-- {
+		resp, err = this[i].ProcessResponse(req, resp, err)
+		if err == ErrDropRequest {
+			return nil, err
+		}
+	}
+	return resp, err
+}
+
+//设置默认请求头的中间件，请求中已有的请求头不会被覆盖
+type headerMiddleware struct {
+	header http.Header
+}
+
+//创建设置默认请求头的中间件，如 User-Agent
+func NewHeaderMiddleware(header http.Header) DownloaderMiddleware {
+	return &headerMiddleware{header: header}
+}
+
+func (this *headerMiddleware) ProcessRequest(req *base.Request) (*base.Response, error) {
+	httpReq := req.HttpReq()
+	if httpReq.Header == nil {
+		httpReq.Header = make(http.Header)
+	}
+	for name, values := range this.header {
+		if _, ok := httpReq.Header[name]; !ok {
+			httpReq.Header[name] = append([]string(nil), values...)
+		}
+	}
+	return nil, nil
+}
+
+func (this *headerMiddleware) ProcessResponse(req *base.Request, resp *base.Response, err error) (*base.Response, error) {
+	return resp, err
+}
@@ -0,0 +1,423 @@
+package downloader
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//HTTP缓存策略
+type CachePolicy uint8
+
+const (
+	CACHE_POLICY_RFC CachePolicy = iota //按 RFC 7234 判断新鲜度，过期时使用 ETag/Last-Modified 条件请求重新验证
+	CACHE_POLICY_DEV                    //开发模式，缓存中存在时总是直接使用缓存，不论新鲜度
+)
+
+var cachePolicyNameMap = map[CachePolicy]string{
+	CACHE_POLICY_RFC: "rfc",
+	CACHE_POLICY_DEV: "dev",
+}
+
+func (this CachePolicy) String() string {
+	if name, ok := cachePolicyNameMap[this]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", this)
+}
+
+//标记响应来源的响应头，值为 hit 或 revalidated
+const CacheStatusHeader = "X-Reptile-Cache"
+
+//可以缓存的响应状态码
+var cacheableStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true, 404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+//缓存在磁盘上的响应
+type cacheEntry struct {
+	Url          string      `json:"url"`
+	StatusCode   int         `json:"statusCode"`
+	Status       string      `json:"status"`
+	Header       http.Header `json:"header"`
+	Vary         http.Header `json:"vary,omitempty"` //Vary 中列出的请求头在请求时的值
+	RequestTime  time.Time   `json:"requestTime"`
+	ResponseTime time.Time   `json:"responseTime"`
+	BodyDigest   string      `json:"bodyDigest"` //响应体的SHA-1摘要，用于确认元数据与响应体属于同一次写入
+}
+
+//磁盘HTTP缓存，以下载器中间件的形式工作
+//缓存以请求指纹为键，每个响应保存为一个元数据文件和一个响应体文件
+type HttpCache struct {
+	dir           string
+	policy        CachePolicy
+	fingerprinter *base.Fingerprinter
+	hits          uint64
+	misses        uint64
+	revalidated   uint64
+	stored        uint64
+}
+
+//创建磁盘HTTP缓存
+//fingerprinter 为nil时使用默认的请求指纹生成器
+func NewHttpCache(dir string, policy CachePolicy, fingerprinter *base.Fingerprinter) (*HttpCache, error) {
+	if _, ok := cachePolicyNameMap[policy]; !ok {
+		return nil, errors.New(fmt.Sprintf("The cache policy %d is invalid!", policy))
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if fingerprinter == nil {
+		fingerprinter = base.NewFingerprinter(nil)
+	}
+	return &HttpCache{dir: dir, policy: policy, fingerprinter: fingerprinter}, nil
+}
+
+func (this *HttpCache) paths(fingerprint string) (string, string) {
+	prefix := filepath.Join(this.dir, fingerprint[:2], fingerprint)
+	return prefix + ".meta", prefix + ".body"
+}
+
+func (this *HttpCache) load(fingerprint string) (*cacheEntry, []byte, error) {
+	metaPath, bodyPath := this.paths(fingerprint)
+	meta, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(meta, &entry); err != nil {
+		return nil, nil, err
+	}
+	body, err := ioutil.ReadFile(bodyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	//并发写入同一指纹时，元数据和响应体可能来自不同的写入
+	if entry.BodyDigest != bodyDigest(body) {
+		return nil, nil, errors.New(fmt.Sprintf("The body of the cached response is inconsistent with its meta! (url=%s)", entry.Url))
+	}
+	return &entry, body, nil
+}
+
+func bodyDigest(body []byte) string {
+	digest := sha1.Sum(body)
+	return hex.EncodeToString(digest[:])
+}
+
+//写入同一目录下唯一的临时文件后重命名，避免读到不完整的缓存，并发的写入互不干扰
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (this *HttpCache) store(fingerprint string, entry *cacheEntry, body []byte) error {
+	metaPath, bodyPath := this.paths(fingerprint)
+	if err := os.MkdirAll(filepath.Dir(metaPath), 0755); err != nil {
+		return err
+	}
+	entry.BodyDigest = bodyDigest(body)
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	//先写响应体后写元数据，元数据中的摘要与响应体一致时缓存才有效
+	if err := writeFileAtomic(bodyPath, body); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(metaPath, meta); err != nil {
+		return err
+	}
+	atomic.AddUint64(&this.stored, 1)
+	return nil
+}
+
+//判断请求是否可以使用缓存
+func (this *HttpCache) cacheable(req *base.Request) bool {
+	if this.policy == CACHE_POLICY_DEV {
+		return true
+	}
+	httpReq := req.HttpReq()
+	if httpReq.Method != "" && httpReq.Method != "GET" && httpReq.Method != "HEAD" {
+		return false
+	}
+	return !parseCacheControl(httpReq.Header)["no-store"]
+}
+
+func (this *HttpCache) ProcessRequest(req *base.Request) (*base.Response, error) {
+	if !this.cacheable(req) {
+		return nil, nil
+	}
+	fingerprint, err := this.fingerprinter.Fingerprint(req)
+	if err != nil {
+		return nil, nil
+	}
+	entry, body, err := this.load(fingerprint)
+	if err != nil || !entry.matchVary(req.HttpReq()) {
+		atomic.AddUint64(&this.misses, 1)
+		return nil, nil
+	}
+	if this.policy == CACHE_POLICY_DEV || entry.fresh(req.HttpReq(), time.Now()) {
+		atomic.AddUint64(&this.hits, 1)
+		return entry.response(req, body, "hit"), nil
+	}
+	//过期的缓存，发出条件请求重新验证
+	//条件请求头加在复制的请求上，不修改调度器保存的请求（重试、持久化时仍是原请求）
+	httpReq := req.HttpReq().Clone(req.HttpReq().Context())
+	req.SetHttpReq(httpReq)
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		httpReq.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+		httpReq.Header.Set("If-Modified-Since", lastModified)
+	}
+	atomic.AddUint64(&this.misses, 1)
+	return nil, nil
+}
+
+func (this *HttpCache) ProcessResponse(req *base.Request, resp *base.Response, err error) (*base.Response, error) {
+	if err != nil || resp == nil || !this.cacheable(req) {
+		return resp, err
+	}
+	httpResp := resp.HttpReq()
+	if httpResp == nil || httpResp.Header.Get(CacheStatusHeader) != "" {
+		return resp, err
+	}
+	fingerprint, fpErr := this.fingerprinter.Fingerprint(req)
+	if fpErr != nil {
+		return resp, err
+	}
+	now := time.Now()
+	if httpResp.StatusCode == http.StatusNotModified {
+		entry, body, loadErr := this.load(fingerprint)
+		if loadErr != nil {
+			return resp, err
+		}
+		httpResp.Body.Close()
+		//使用 304 响应中的响应头更新缓存
+		for name, values := range httpResp.Header {
+			entry.Header[name] = values
+		}
+		entry.RequestTime = now
+		entry.ResponseTime = now
+		this.store(fingerprint, entry, body)
+		atomic.AddUint64(&this.revalidated, 1)
+		return entry.response(req, body, "revalidated"), nil
+	}
+	if !this.storable(httpResp) {
+		return resp, err
+	}
+	body, readErr := resp.Body()
+	if readErr != nil {
+		return nil, readErr
+	}
+	entry := &cacheEntry{
+		Url:          req.HttpReq().URL.String(),
+		StatusCode:   httpResp.StatusCode,
+		Status:       httpResp.Status,
+		Header:       httpResp.Header,
+		Vary:         varyHeader(httpResp.Header, req.HttpReq()),
+		RequestTime:  now,
+		ResponseTime: now,
+	}
+	if storeErr := this.store(fingerprint, entry, body); storeErr != nil {
+		logger.Printf("Failed to store the response in http cache : %s\n", storeErr)
+	}
+	return resp, err
+}
+
+//判断响应是否可以存入缓存
+func (this *HttpCache) storable(httpResp *http.Response) bool {
+	if this.policy == CACHE_POLICY_DEV {
+		return true
+	}
+	if !cacheableStatus[httpResp.StatusCode] {
+		return false
+	}
+	cc := parseCacheControl(httpResp.Header)
+	if cc["no-store"] {
+		return false
+	}
+	return strings.TrimSpace(httpResp.Header.Get("Vary")) != "*"
+}
+
+func (this *HttpCache) Summary() string {
+	return fmt.Sprintf("policy:%s,dir:%s,hits:%d,misses:%d,revalidated:%d,stored:%d", this.policy, this.dir,
+		atomic.LoadUint64(&this.hits), atomic.LoadUint64(&this.misses), atomic.LoadUint64(&this.revalidated), atomic.LoadUint64(&this.stored))
+}
+
+//由缓存生成响应
+func (this *cacheEntry) response(req *base.Request, body []byte, cacheStatus string) *base.Response {
+	header := make(http.Header, len(this.Header)+1)
+	for name, values := range this.Header {
+		header[name] = values
+	}
+	header.Set(CacheStatusHeader, cacheStatus)
+	httpResp := &http.Response{
+		Status:     this.Status,
+		StatusCode: this.StatusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Request:    req.HttpReq(),
+	}
+	resp := base.NewResponse(httpResp, req.Depth())
+	resp.SetBody(body)
+	return resp
+}
+
+//请求中 Vary 列出的请求头是否与缓存时相同
+func (this *cacheEntry) matchVary(httpReq *http.Request) bool {
+	for name, values := range this.Vary {
+		if strings.Join(httpReq.Header[name], ",") != strings.Join(values, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+//判断缓存是否新鲜（RFC 7234 4.2）
+func (this *cacheEntry) fresh(httpReq *http.Request, now time.Time) bool {
+	respCC := parseCacheControlValues(this.Header)
+	reqCC := parseCacheControlValues(httpReq.Header)
+	if _, ok := respCC["no-cache"]; ok {
+		return false
+	}
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+	if strings.Contains(strings.ToLower(httpReq.Header.Get("Pragma")), "no-cache") {
+		return false
+	}
+	lifetime, ok := this.freshnessLifetime(respCC)
+	if !ok {
+		return false
+	}
+	if maxAge, ok := reqCC["max-age"]; ok {
+		if seconds, err := strconv.Atoi(maxAge); err == nil && time.Duration(seconds)*time.Second < lifetime {
+			lifetime = time.Duration(seconds) * time.Second
+		}
+	}
+	return lifetime > this.currentAge(now)
+}
+
+//新鲜期：max-age，其次 Expires 减 Date，其次按 Last-Modified 的启发式计算
+func (this *cacheEntry) freshnessLifetime(cc map[string]string) (time.Duration, bool) {
+	if maxAge, ok := cc["max-age"]; ok {
+		if seconds, err := strconv.Atoi(maxAge); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		return 0, false
+	}
+	date := this.date()
+	if expires := this.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0, false
+		}
+		return t.Sub(date), true
+	}
+	if lastModified, err := http.ParseTime(this.Header.Get("Last-Modified")); err == nil && date.After(lastModified) {
+		return date.Sub(lastModified) / 10, true
+	}
+	return 0, false
+}
+
+//响应的 Date，缺失时使用收到响应的时间
+func (this *cacheEntry) date() time.Time {
+	if date, err := http.ParseTime(this.Header.Get("Date")); err == nil {
+		return date
+	}
+	return this.ResponseTime
+}
+
+//当前年龄（RFC 7234 4.2.3）
+func (this *cacheEntry) currentAge(now time.Time) time.Duration {
+	apparentAge := this.ResponseTime.Sub(this.date())
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	var ageValue time.Duration
+	if seconds, err := strconv.Atoi(this.Header.Get("Age")); err == nil && seconds > 0 {
+		ageValue = time.Duration(seconds) * time.Second
+	}
+	responseDelay := this.ResponseTime.Sub(this.RequestTime)
+	correctedAge := ageValue + responseDelay
+	if apparentAge > correctedAge {
+		correctedAge = apparentAge
+	}
+	return correctedAge + now.Sub(this.ResponseTime)
+}
+
+//记录 Vary 中列出的请求头在请求时的值
+func varyHeader(header http.Header, httpReq *http.Request) http.Header {
+	vary := header.Get("Vary")
+	if vary == "" {
+		return nil
+	}
+	values := make(http.Header)
+	for _, name := range strings.Split(vary, ",") {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name != "" {
+			values[name] = httpReq.Header[name]
+		}
+	}
+	return values
+}
+
+//解析 Cache-Control，返回指令及其值
+func parseCacheControlValues(header http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, value := range header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			kv := strings.SplitN(directive, "=", 2)
+			name := strings.ToLower(strings.TrimSpace(kv[0]))
+			if len(kv) == 2 {
+				cc[name] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			} else {
+				cc[name] = ""
+			}
+		}
+	}
+	return cc
+}
+
+//解析 Cache-Control，返回出现的指令
+func parseCacheControl(header http.Header) map[string]bool {
+	directives := make(map[string]bool)
+	for name := range parseCacheControlValues(header) {
+		directives[name] = true
+	}
+	return directives
+}
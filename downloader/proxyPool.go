@@ -0,0 +1,330 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//代理轮换方式
+type ProxyRotation uint8
+
+const (
+	PROXY_ROUND_ROBIN ProxyRotation = iota //依次使用各个代理
+	PROXY_STICKY                           //同一主机总是使用同一个代理，该代理不可用时才更换
+)
+
+var proxyRotationNameMap = map[ProxyRotation]string{
+	PROXY_ROUND_ROBIN: "roundRobin",
+	PROXY_STICKY:      "sticky",
+}
+
+func (this ProxyRotation) String() string {
+	if name, ok := proxyRotationNameMap[this]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", this)
+}
+
+//支持的代理协议
+var proxySchemes = map[string]bool{"http": true, "https": true, "socks5": true}
+
+//解析代理地址，没有协议时默认为 http
+func ParseProxy(rawurl string) (*url.URL, error) {
+	rawurl = strings.TrimSpace(rawurl)
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "http://" + rawurl
+	}
+	proxyUrl, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if !proxySchemes[proxyUrl.Scheme] || proxyUrl.Host == "" {
+		return nil, errors.New(fmt.Sprintf("The proxy is invalid! (proxy=%s)", rawurl))
+	}
+	return proxyUrl, nil
+}
+
+//从文件中读取代理地址，每行一个，忽略空行和以 # 开始的行
+func LoadProxies(path string) ([]*url.URL, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var proxies []*url.URL
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		proxyUrl, err := ParseProxy(line)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s:%d: %s", path, lineNo, err))
+		}
+		proxies = append(proxies, proxyUrl)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return proxies, nil
+}
+
+//代理及其统计信息
+type proxyEntry struct {
+	url            *url.URL
+	requests       uint64
+	successes      uint64
+	failures       uint64
+	consecutive    uint32        //连续失败次数
+	latency        time.Duration //成功请求的总耗时
+	unhealthyUntil time.Time     //冷却结束的时间
+}
+
+func (this *proxyEntry) healthy(now time.Time) bool {
+	return !now.Before(this.unhealthyUntil)
+}
+
+//一次请求使用的代理
+type proxyUse struct {
+	entry *proxyEntry
+	start time.Time
+}
+
+type proxyContextKey struct{}
+
+//粘性轮换时最多记住的主机数，超过时淘汰最久未使用的主机
+const maxStickyHosts = 10000
+
+//粘性轮换时主机绑定的代理
+type stickyHost struct {
+	host  string
+	entry *proxyEntry
+}
+
+//代理池，以下载器中间件的形式为每个请求选择代理
+//下载器的HTTP客户端需要通过 Client 方法包装，使请求经由选定的代理发出
+//代理连续失败达到一定次数后在冷却时间内不再使用，冷却结束后重新启用
+//所有代理都在冷却中时，使用最早结束冷却的代理
+type ProxyPool struct {
+	proxies       []*proxyEntry
+	rotation      ProxyRotation
+	maxFailures   uint32
+	coolDown      time.Duration
+	failureStatus map[int]bool
+	next          int
+	sticky        map[string]*list.Element //主机 -> stickyList 中的 *stickyHost
+	stickyList    *list.List               //按最近使用排序的主机，最近使用的在前
+	mutex         sync.Mutex
+}
+
+//创建代理池
+//maxFailures 为标记代理不可用前允许的连续失败次数，coolDown 为不可用代理的冷却时间
+func NewProxyPool(proxies []*url.URL, rotation ProxyRotation, maxFailures uint32, coolDown time.Duration) (*ProxyPool, error) {
+	if len(proxies) == 0 {
+		return nil, errors.New("The proxy list is empty!")
+	}
+	if _, ok := proxyRotationNameMap[rotation]; !ok {
+		return nil, errors.New(fmt.Sprintf("The proxy rotation %d is invalid!", rotation))
+	}
+	if maxFailures == 0 {
+		return nil, errors.New("The max failures of proxy must be positive!")
+	}
+	if coolDown < 0 {
+		return nil, errors.New(fmt.Sprintf("The cool-down of proxy is invalid! (coolDown=%s)", coolDown))
+	}
+	pool := &ProxyPool{
+		rotation:      rotation,
+		maxFailures:   maxFailures,
+		coolDown:      coolDown,
+		failureStatus: map[int]bool{http.StatusProxyAuthRequired: true},
+		sticky:        make(map[string]*list.Element),
+		stickyList:    list.New(),
+	}
+	for i, proxyUrl := range proxies {
+		if proxyUrl == nil || !proxySchemes[proxyUrl.Scheme] || proxyUrl.Host == "" {
+			return nil, errors.New(fmt.Sprintf("The %dth proxy is invalid!", i))
+		}
+		pool.proxies = append(pool.proxies, &proxyEntry{url: proxyUrl})
+	}
+	return pool, nil
+}
+
+//设置视为代理失败的响应状态码，默认为 407
+func (this *ProxyPool) SetFailureStatusCodes(codes ...int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.failureStatus = make(map[int]bool)
+	for _, code := range codes {
+		this.failureStatus[code] = true
+	}
+}
+
+//包装HTTP客户端，使请求经由代理池选定的代理发出
+//客户端的 Transport 必须为nil或 *http.Transport
+func (this *ProxyPool) Client(client *http.Client) (*http.Client, error) {
+	if client == nil {
+		client = &http.Client{}
+	}
+	var transport *http.Transport
+	switch t := client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return nil, errors.New(fmt.Sprintf("The transport of http client is not *http.Transport! (type=%T)", t))
+	}
+	transport.Proxy = this.proxyFor
+	wrapped := *client
+	wrapped.Transport = transport
+	return &wrapped, nil
+}
+
+//Transport 的代理选择函数
+//请求未经过代理池中间件时（如重定向后的请求），按目标主机选择代理
+func (this *ProxyPool) proxyFor(httpReq *http.Request) (*url.URL, error) {
+	if use, ok := httpReq.Context().Value(proxyContextKey{}).(*proxyUse); ok {
+		return use.entry.url, nil
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.pick(httpReq.URL.Host, time.Now()).url, nil
+}
+
+//选择代理，调用方需持有锁
+func (this *ProxyPool) pick(host string, now time.Time) *proxyEntry {
+	if this.rotation == PROXY_STICKY {
+		if elem, ok := this.sticky[host]; ok && elem.Value.(*stickyHost).entry.healthy(now) {
+			this.stickyList.MoveToFront(elem)
+			return elem.Value.(*stickyHost).entry
+		}
+	}
+	var chosen *proxyEntry
+	for i := 0; i < len(this.proxies); i++ {
+		entry := this.proxies[(this.next+i)%len(this.proxies)]
+		if entry.healthy(now) {
+			chosen = entry
+			this.next = (this.next + i + 1) % len(this.proxies)
+			break
+		}
+	}
+	if chosen == nil {
+		for _, entry := range this.proxies {
+			if chosen == nil || entry.unhealthyUntil.Before(chosen.unhealthyUntil) {
+				chosen = entry
+			}
+		}
+	}
+	if this.rotation == PROXY_STICKY {
+		this.stick(host, chosen)
+	}
+	return chosen
+}
+
+//将主机绑定到代理，调用方需持有锁
+func (this *ProxyPool) stick(host string, entry *proxyEntry) {
+	if elem, ok := this.sticky[host]; ok {
+		elem.Value.(*stickyHost).entry = entry
+		this.stickyList.MoveToFront(elem)
+		return
+	}
+	this.sticky[host] = this.stickyList.PushFront(&stickyHost{host: host, entry: entry})
+	for this.stickyList.Len() > maxStickyHosts {
+		oldest := this.stickyList.Back()
+		this.stickyList.Remove(oldest)
+		delete(this.sticky, oldest.Value.(*stickyHost).host)
+	}
+}
+
+//解除所有主机与代理的绑定，这些主机下次请求时重新选择代理，调用方需持有锁
+func (this *ProxyPool) unstick(entry *proxyEntry) {
+	for elem := this.stickyList.Front(); elem != nil; {
+		next := elem.Next()
+		if sticky := elem.Value.(*stickyHost); sticky.entry == entry {
+			this.stickyList.Remove(elem)
+			delete(this.sticky, sticky.host)
+		}
+		elem = next
+	}
+}
+
+func (this *ProxyPool) ProcessRequest(req *base.Request) (*base.Response, error) {
+	httpReq := req.HttpReq()
+	now := time.Now()
+	this.mutex.Lock()
+	entry := this.pick(httpReq.URL.Host, now)
+	entry.requests++
+	this.mutex.Unlock()
+	ctx := context.WithValue(httpReq.Context(), proxyContextKey{}, &proxyUse{entry: entry, start: now})
+	req.SetHttpReq(httpReq.WithContext(ctx))
+	return nil, nil
+}
+
+func (this *ProxyPool) ProcessResponse(req *base.Request, resp *base.Response, err error) (*base.Response, error) {
+	use, ok := req.HttpReq().Context().Value(proxyContextKey{}).(*proxyUse)
+	if !ok {
+		return resp, err
+	}
+	now := time.Now()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	entry := use.entry
+	failed := err != nil
+	if !failed && resp != nil && resp.HttpReq() != nil {
+		failed = this.failureStatus[resp.HttpReq().StatusCode]
+	}
+	if !failed {
+		entry.successes++
+		entry.consecutive = 0
+		entry.latency += now.Sub(use.start)
+		return resp, err
+	}
+	entry.failures++
+	entry.consecutive++
+	if entry.consecutive >= this.maxFailures {
+		entry.consecutive = 0
+		entry.unhealthyUntil = now.Add(this.coolDown)
+		this.unstick(entry)
+		logger.Printf("The proxy %s is unhealthy, cool down for %s.\n", entry.url.Redacted(), this.coolDown)
+	}
+	return resp, err
+}
+
+//获取摘要信息，包括各个代理的请求数、成功数、失败数、平均耗时和是否可用
+func (this *ProxyPool) Summary() string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	now := time.Now()
+	var buffer bytes.Buffer
+	healthy := 0
+	for _, entry := range this.proxies {
+		if entry.healthy(now) {
+			healthy++
+		}
+	}
+	buffer.WriteString(fmt.Sprintf("rotation:%s,proxies:%d,healthy:%d", this.rotation, len(this.proxies), healthy))
+	for _, entry := range this.proxies {
+		var avgLatency time.Duration
+		if entry.successes > 0 {
+			avgLatency = entry.latency / time.Duration(entry.successes)
+		}
+		buffer.WriteString(fmt.Sprintf("; %s(requests:%d,successes:%d,failures:%d,avgLatency:%s,healthy:%v)",
+			entry.url.Redacted(), entry.requests, entry.successes, entry.failures,
+			avgLatency.Round(time.Millisecond), entry.healthy(now)))
+	}
+	return buffer.String()
+}
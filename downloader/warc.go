@@ -0,0 +1,353 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"github.com/fmyxyz/goreptile/warc"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+//请求不在WARC归档中
+var ErrNotArchived = errors.New("The request is not found in warc archives!")
+
+//WARC记录中间件，把经过下载器的请求和响应写入WARC文件
+type WarcRecorder struct {
+	writer   *warc.Writer
+	recorded uint64
+	failed   uint64
+}
+
+//创建WARC记录中间件
+//写入失败只记录日志，不影响下载结果
+func NewWarcRecorder(writer *warc.Writer) (*WarcRecorder, error) {
+	if writer == nil {
+		return nil, errors.New("The warc writer is invalid!")
+	}
+	return &WarcRecorder{writer: writer}, nil
+}
+
+func (this *WarcRecorder) ProcessRequest(req *base.Request) (*base.Response, error) {
+	return nil, nil
+}
+
+func (this *WarcRecorder) ProcessResponse(req *base.Request, resp *base.Response, err error) (*base.Response, error) {
+	if err != nil || resp == nil || resp.HttpReq() == nil {
+		return resp, err
+	}
+	body, readErr := resp.Body()
+	if readErr != nil {
+		return nil, readErr
+	}
+	if writeErr := this.record(req, resp.HttpReq(), body); writeErr != nil {
+		atomic.AddUint64(&this.failed, 1)
+		logger.Printf("Failed to write warc records (requestUrl='%s'): %s\n", req.HttpReq().URL, writeErr)
+	} else {
+		atomic.AddUint64(&this.recorded, 1)
+	}
+	return resp, nil
+}
+
+func (this *WarcRecorder) record(req *base.Request, httpResp *http.Response, body []byte) error {
+	httpReq := req.HttpReq()
+	reqBody, err := req.BodyBytes()
+	if err != nil {
+		return err
+	}
+	target := httpReq.URL.String()
+	response := warc.NewRecord(warc.TYPE_RESPONSE, dumpResponse(httpResp, body))
+	response.Header.Set("WARC-Target-URI", target)
+	response.Header.Set("Content-Type", "application/http;msgtype=response")
+	request := warc.NewRecord(warc.TYPE_REQUEST, dumpRequest(httpReq, reqBody))
+	request.Header.Set("WARC-Target-URI", target)
+	request.Header.Set("WARC-Concurrent-To", response.Id())
+	request.Header.Set("Content-Type", "application/http;msgtype=request")
+	return this.writer.WriteRecords(response, request)
+}
+
+func (this *WarcRecorder) Summary() string {
+	return fmt.Sprintf("recorded:%d,failed:%d", atomic.LoadUint64(&this.recorded), atomic.LoadUint64(&this.failed))
+}
+
+//按HTTP报文格式输出请求
+func dumpRequest(httpReq *http.Request, body []byte) []byte {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "%s %s HTTP/1.1\r\n", httpReq.Method, httpReq.URL.RequestURI())
+	host := httpReq.Host
+	if host == "" {
+		host = httpReq.URL.Host
+	}
+	fmt.Fprintf(&buffer, "Host: %s\r\n", host)
+	header := httpReq.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Del("Host")
+	header.Del("Transfer-Encoding")
+	if len(body) > 0 {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	header.Write(&buffer)
+	buffer.WriteString("\r\n")
+	buffer.Write(body)
+	return buffer.Bytes()
+}
+
+//按HTTP报文格式输出响应
+//响应体已被完整读出，因此去掉分块传输并设置实际的长度
+func dumpResponse(httpResp *http.Response, body []byte) []byte {
+	var buffer bytes.Buffer
+	major, minor := httpResp.ProtoMajor, httpResp.ProtoMinor
+	if major == 0 {
+		major, minor = 1, 1
+	}
+	status := httpResp.Status
+	if status == "" {
+		status = fmt.Sprintf("%d %s", httpResp.StatusCode, http.StatusText(httpResp.StatusCode))
+	}
+	fmt.Fprintf(&buffer, "HTTP/%d.%d %s\r\n", major, minor, status)
+	header := httpResp.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Write(&buffer)
+	buffer.WriteString("\r\n")
+	buffer.Write(body)
+	return buffer.Bytes()
+}
+
+//响应记录在WARC文件中的位置
+type warcLocation struct {
+	path   string
+	offset int64
+}
+
+//WARC归档，按请求指纹索引其中的响应记录
+//同一请求有多条响应记录时使用最后一条
+//也可作为下载器中间件使用，此时所有请求都从归档中获取，不会访问网络
+type WarcArchive struct {
+	fingerprinter *base.Fingerprinter
+	index         map[string]warcLocation
+	files         int
+	hits          uint64
+	misses        uint64
+}
+
+//打开WARC归档
+//paths 可以是WARC文件或目录，目录中的 .warc 和 .warc.gz 文件按文件名顺序读取
+//fingerprinter 为nil时使用默认的请求指纹生成器
+func OpenWarcArchive(fingerprinter *base.Fingerprinter, paths ...string) (*WarcArchive, error) {
+	if fingerprinter == nil {
+		fingerprinter = base.NewFingerprinter(nil)
+	}
+	files, err := warcFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New(fmt.Sprintf("No warc file is found! (paths=%v)", paths))
+	}
+	archive := &WarcArchive{
+		fingerprinter: fingerprinter,
+		index:         make(map[string]warcLocation),
+		files:         len(files),
+	}
+	for _, file := range files {
+		if err := archive.load(file); err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to read warc file %s: %s", file, err))
+		}
+	}
+	return archive, nil
+}
+
+func warcFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.IsDir() && (strings.HasSuffix(name, ".warc") || strings.HasSuffix(name, ".warc.gz")) {
+				names = append(names, filepath.Join(path, name))
+			}
+		}
+		sort.Strings(names)
+		files = append(files, names...)
+	}
+	return files, nil
+}
+
+//读取一个WARC文件并建立索引
+//请求记录通过 WARC-Concurrent-To 关联响应记录，没有请求记录的响应按 GET 请求索引
+func (this *WarcArchive) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := warc.NewReader(file)
+	if err != nil {
+		return err
+	}
+	type response struct {
+		id       string
+		target   string
+		location warcLocation
+	}
+	var responses []response
+	requests := make(map[string]string) //响应记录ID -> 请求指纹
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch record.Type() {
+		case warc.TYPE_RESPONSE:
+			responses = append(responses, response{
+				id:       record.Id(),
+				target:   record.Header.Get("WARC-Target-URI"),
+				location: warcLocation{path: path, offset: reader.Offset()},
+			})
+		case warc.TYPE_REQUEST:
+			concurrentTo := record.Header.Get("WARC-Concurrent-To")
+			if concurrentTo == "" {
+				continue
+			}
+			req, err := parseRequestRecord(record)
+			if err != nil {
+				logger.Printf("Ignore the invalid warc request record %s: %s\n", record.Id(), err)
+				continue
+			}
+			fingerprint, err := this.fingerprinter.Fingerprint(req)
+			if err != nil {
+				continue
+			}
+			requests[concurrentTo] = fingerprint
+		}
+	}
+	for _, resp := range responses {
+		fingerprint, ok := requests[resp.id]
+		if !ok {
+			httpReq, err := http.NewRequest(http.MethodGet, resp.target, nil)
+			if err != nil {
+				continue
+			}
+			fingerprint, err = this.fingerprinter.Fingerprint(base.NewRequest(httpReq, 0))
+			if err != nil {
+				continue
+			}
+		}
+		this.index[fingerprint] = resp.location
+	}
+	return nil
+}
+
+//从请求记录还原请求
+func parseRequestRecord(record *warc.Record) (*base.Request, error) {
+	httpReq, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(record.Content)))
+	if err != nil {
+		return nil, err
+	}
+	target, err := url.Parse(record.Header.Get("WARC-Target-URI"))
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(httpReq.Body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.URL = target
+	httpReq.RequestURI = ""
+	httpReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	httpReq.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return base.NewRequest(httpReq, 0), nil
+}
+
+//从归档中获取请求对应的响应，不存在时返回 ErrNotArchived
+func (this *WarcArchive) Lookup(req *base.Request) (*base.Response, error) {
+	fingerprint, err := this.fingerprinter.Fingerprint(req)
+	if err != nil {
+		return nil, err
+	}
+	location, ok := this.index[fingerprint]
+	if !ok {
+		atomic.AddUint64(&this.misses, 1)
+		return nil, ErrNotArchived
+	}
+	record, err := warc.ReadRecordAt(location.path, location.offset)
+	if err != nil {
+		return nil, err
+	}
+	httpResp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(record.Content)), req.HttpReq())
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&this.hits, 1)
+	return base.NewResponse(httpResp, req.Depth()), nil
+}
+
+func (this *WarcArchive) ProcessRequest(req *base.Request) (*base.Response, error) {
+	return this.Lookup(req)
+}
+
+func (this *WarcArchive) ProcessResponse(req *base.Request, resp *base.Response, err error) (*base.Response, error) {
+	return resp, err
+}
+
+func (this *WarcArchive) Summary() string {
+	return fmt.Sprintf("files:%d,responses:%d,hits:%d,misses:%d",
+		this.files, len(this.index), atomic.LoadUint64(&this.hits), atomic.LoadUint64(&this.misses))
+}
+
+//从WARC归档回放响应的网页下载器，不会访问网络
+type warcReplayDownloader struct {
+	id          uint32
+	archive     *WarcArchive
+	middlewares middlewareChain
+}
+
+//创建回放WARC归档的网页下载器
+func NewWarcReplayDownloader(archive *WarcArchive, middlewares ...DownloaderMiddleware) PageDownloader {
+	return &warcReplayDownloader{
+		id:          genDownloaderId(),
+		archive:     archive,
+		middlewares: middlewareChain(middlewares),
+	}
+}
+
+func (this *warcReplayDownloader) Id() uint32 {
+	return this.id
+}
+
+func (this *warcReplayDownloader) Download(req base.Request) (*base.Response, error) {
+	return this.middlewares.download(&req, this.archive.Lookup)
+}
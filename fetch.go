@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"github.com/fmyxyz/goreptile/config"
+	"net/http"
+	"os"
+)
+
+func runFetch(flags *flag.FlagSet, args []string) error {
+	configPath := flags.String("config", "", "config `file` (YAML or TOML); its downloader settings are used")
+	head := flags.Bool("head", false, "print only the status and headers")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("The url is required!")
+	}
+	file, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	resp, err := download(file, flags.Arg(0), 0)
+	if err != nil {
+		return err
+	}
+	httpResp := resp.HttpReq()
+	fmt.Fprintf(os.Stdout, "%s %s\n", httpResp.Proto, httpResp.Status)
+	httpResp.Header.Write(os.Stdout)
+	if *head {
+		return nil
+	}
+	body, err := resp.Body()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout)
+	_, err = os.Stdout.Write(body)
+	return err
+}
+
+//用配置中的下载器设置下载一个URL，不经过调度器，因此不受爬取范围、robots.txt 和礼貌策略的限制
+func download(file *config.File, rawUrl string, depth uint32) (*base.Response, error) {
+	dl, err := file.BuildDownloader()
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dl.Download(*base.NewRequest(httpReq, depth))
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || !resp.Valid() {
+		return nil, errors.New(fmt.Sprintf("The response of %s is invalid!", rawUrl))
+	}
+	return resp, nil
+}
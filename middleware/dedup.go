@@ -0,0 +1,233 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+//去重存储
+type DedupStore interface {
+	//添加键
+	//若键已存在（对于概率实现，可能已存在），则返回false
+	Add(key string) bool
+	//判断键是否存在
+	Contains(key string) bool
+	//已添加的键的数量
+	Count() uint64
+	//估计的误判率，精确实现为0
+	ErrorRate() float64
+	//摘要信息
+	Summary() string
+}
+
+//生成去重存储
+type GenDedupStore func() DedupStore
+
+//精确的去重存储
+type exactDedupStore struct {
+	keys    map[string]struct{}
+	rwMutex sync.RWMutex
+}
+
+//创建精确的去重存储，所有键都保存在内存中
+func NewExactDedupStore() DedupStore {
+	return &exactDedupStore{keys: make(map[string]struct{})}
+}
+
+func (this *exactDedupStore) Add(key string) bool {
+	this.rwMutex.Lock()
+	defer this.rwMutex.Unlock()
+	if _, ok := this.keys[key]; ok {
+		return false
+	}
+	this.keys[key] = struct{}{}
+	return true
+}
+
+func (this *exactDedupStore) Contains(key string) bool {
+	this.rwMutex.RLock()
+	defer this.rwMutex.RUnlock()
+	_, ok := this.keys[key]
+	return ok
+}
+
+func (this *exactDedupStore) Count() uint64 {
+	this.rwMutex.RLock()
+	defer this.rwMutex.RUnlock()
+	return uint64(len(this.keys))
+}
+
+func (this *exactDedupStore) ErrorRate() float64 {
+	return 0
+}
+
+func (this *exactDedupStore) Summary() string {
+	return fmt.Sprintf("type:exact,count:%d,errorRate:0", this.Count())
+}
+
+//单个布隆过滤器
+type bloomFilter struct {
+	bits     []uint64
+	m        uint64 //位数
+	k        uint64 //哈希函数个数
+	capacity uint64 //设计容量
+	count    uint64 //已添加的键数量
+}
+
+func newBloomFilter(capacity uint64, errorRate float64) *bloomFilter {
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Ceil(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		bits:     make([]uint64, (m+63)/64),
+		m:        m,
+		k:        k,
+		capacity: capacity,
+	}
+}
+
+//双重哈希得到第 i 个位置
+func (this *bloomFilter) location(h1, h2, i uint64) uint64 {
+	return (h1 + i*h2) % this.m
+}
+
+func (this *bloomFilter) test(h1, h2 uint64) bool {
+	for i := uint64(0); i < this.k; i++ {
+		loc := this.location(h1, h2, i)
+		if this.bits[loc/64]&(1<<(loc%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *bloomFilter) add(h1, h2 uint64) {
+	for i := uint64(0); i < this.k; i++ {
+		loc := this.location(h1, h2, i)
+		this.bits[loc/64] |= 1 << (loc % 64)
+	}
+	this.count++
+}
+
+//按已添加的键数量估计的误判率
+func (this *bloomFilter) errorRate() float64 {
+	return math.Pow(1-math.Exp(-float64(this.k)*float64(this.count)/float64(this.m)), float64(this.k))
+}
+
+const (
+	bloomGrowth    = 2   //每个新过滤器的容量倍数
+	bloomTightness = 0.9 //每个新过滤器的误判率倍数
+)
+
+//可扩展的布隆过滤器去重存储
+//当前过滤器达到设计容量后会追加一个容量更大、误判率更低的过滤器，
+//使总误判率不超过设定值
+type bloomDedupStore struct {
+	filters   []*bloomFilter
+	errorRate float64
+	count     uint64
+	rwMutex   sync.RWMutex
+}
+
+//创建基于可扩展布隆过滤器的去重存储
+//initialCapacity 为第一个过滤器的容量，errorRate 为期望的总误判率
+func NewBloomDedupStore(initialCapacity uint64, errorRate float64) (DedupStore, error) {
+	if initialCapacity == 0 {
+		return nil, errors.New("The initial capacity of bloom filter must be positive!")
+	}
+	if errorRate <= 0 || errorRate >= 1 {
+		return nil, errors.New(fmt.Sprintf("The error rate of bloom filter is invalid! (errorRate=%v)", errorRate))
+	}
+	store := &bloomDedupStore{errorRate: errorRate}
+	store.filters = append(store.filters, newBloomFilter(initialCapacity, store.filterErrorRate(0)))
+	return store, nil
+}
+
+//第 i 个过滤器的误判率
+//各过滤器误判率之和为等比数列，其极限不超过设定的总误判率
+func (this *bloomDedupStore) filterErrorRate(i int) float64 {
+	return this.errorRate * (1 - bloomTightness) * math.Pow(bloomTightness, float64(i))
+}
+
+func bloomHash(key string) (uint64, uint64) {
+	h1 := fnv.New64a()
+	h1.Write([]byte(key))
+	h2 := fnv.New64()
+	h2.Write([]byte(key))
+	return h1.Sum64(), h2.Sum64() | 1
+}
+
+func (this *bloomDedupStore) contains(h1, h2 uint64) bool {
+	for _, filter := range this.filters {
+		if filter.test(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *bloomDedupStore) Add(key string) bool {
+	h1, h2 := bloomHash(key)
+	this.rwMutex.Lock()
+	defer this.rwMutex.Unlock()
+	if this.contains(h1, h2) {
+		return false
+	}
+	current := this.filters[len(this.filters)-1]
+	if current.count >= current.capacity {
+		current = newBloomFilter(current.capacity*bloomGrowth, this.filterErrorRate(len(this.filters)))
+		this.filters = append(this.filters, current)
+	}
+	current.add(h1, h2)
+	this.count++
+	return true
+}
+
+func (this *bloomDedupStore) Contains(key string) bool {
+	h1, h2 := bloomHash(key)
+	this.rwMutex.RLock()
+	defer this.rwMutex.RUnlock()
+	return this.contains(h1, h2)
+}
+
+func (this *bloomDedupStore) Count() uint64 {
+	this.rwMutex.RLock()
+	defer this.rwMutex.RUnlock()
+	return this.count
+}
+
+//估计的总误判率：任意一个过滤器误判即为误判
+func (this *bloomDedupStore) ErrorRate() float64 {
+	this.rwMutex.RLock()
+	defer this.rwMutex.RUnlock()
+	var notFalse float64 = 1
+	for _, filter := range this.filters {
+		notFalse *= 1 - filter.errorRate()
+	}
+	return 1 - notFalse
+}
+
+//占用的内存字节数
+func (this *bloomDedupStore) size() uint64 {
+	var size uint64
+	for _, filter := range this.filters {
+		size += uint64(len(filter.bits)) * 8
+	}
+	return size
+}
+
+func (this *bloomDedupStore) Summary() string {
+	errorRate := this.ErrorRate()
+	this.rwMutex.RLock()
+	defer this.rwMutex.RUnlock()
+	return fmt.Sprintf("type:bloom,count:%d,filters:%d,bytes:%d,errorRate:%.6g,maxErrorRate:%v",
+		this.count, len(this.filters), this.size(), errorRate, this.errorRate)
+}
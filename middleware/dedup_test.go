@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestNewBloomFilterSizing(t *testing.T) {
+	tests := []struct {
+		capacity  uint64
+		errorRate float64
+		wantM     uint64
+		wantK     uint64
+	}{
+		{capacity: 1, errorRate: 0.5, wantM: 64, wantK: 45},
+		{capacity: 1000, errorRate: 0.01, wantM: 9586, wantK: 7},
+		{capacity: 1000, errorRate: 0.001, wantM: 14378, wantK: 10},
+		{capacity: 100000, errorRate: 0.0001, wantM: 1917012, wantK: 14},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d/%v", test.capacity, test.errorRate), func(t *testing.T) {
+			filter := newBloomFilter(test.capacity, test.errorRate)
+			if filter.m != test.wantM || filter.k != test.wantK {
+				t.Fatalf("m=%d k=%d, want m=%d k=%d", filter.m, filter.k, test.wantM, test.wantK)
+			}
+			if got := uint64(len(filter.bits)); got != (test.wantM+63)/64 {
+				t.Fatalf("len(bits)=%d, want %d", got, (test.wantM+63)/64)
+			}
+			//填满设计容量后，估计的误判率不超过设定值（64位下限会使小容量的过滤器更稀疏）
+			filter.count = test.capacity
+			if rate := filter.errorRate(); rate > test.errorRate*1.01 {
+				t.Fatalf("errorRate()=%v at capacity, want <= %v", rate, test.errorRate)
+			}
+		})
+	}
+}
+
+func TestBloomDedupStoreGrowth(t *testing.T) {
+	tests := []struct {
+		initialCapacity uint64
+		adds            int
+		wantCapacities  []uint64
+	}{
+		{initialCapacity: 100, adds: 0, wantCapacities: []uint64{100}},
+		{initialCapacity: 100, adds: 100, wantCapacities: []uint64{100}},
+		{initialCapacity: 100, adds: 101, wantCapacities: []uint64{100, 200}},
+		{initialCapacity: 100, adds: 300, wantCapacities: []uint64{100, 200}},
+		{initialCapacity: 100, adds: 301, wantCapacities: []uint64{100, 200, 400}},
+		{initialCapacity: 10, adds: 1000, wantCapacities: []uint64{10, 20, 40, 80, 160, 320, 640}},
+	}
+	//误判率足够小，添加的键都不会被误判为已存在，Count 与添加次数相同
+	const errorRate = 1e-6
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d+%d", test.initialCapacity, test.adds), func(t *testing.T) {
+			dedup, err := NewBloomDedupStore(test.initialCapacity, errorRate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store := dedup.(*bloomDedupStore)
+			for i := 0; i < test.adds; i++ {
+				if !store.Add(fmt.Sprintf("key-%d", i)) {
+					t.Fatalf("Add(key-%d) = false for a new key", i)
+				}
+			}
+			if store.Count() != uint64(test.adds) {
+				t.Fatalf("Count()=%d, want %d", store.Count(), test.adds)
+			}
+			if len(store.filters) != len(test.wantCapacities) {
+				t.Fatalf("%d filters, want %d", len(store.filters), len(test.wantCapacities))
+			}
+			for i, filter := range store.filters {
+				if filter.capacity != test.wantCapacities[i] {
+					t.Fatalf("filters[%d].capacity=%d, want %d", i, filter.capacity, test.wantCapacities[i])
+				}
+				if filter.count > filter.capacity {
+					t.Fatalf("filters[%d] holds %d keys, over its capacity %d", i, filter.count, filter.capacity)
+				}
+				wantRate := errorRate * (1 - bloomTightness) * math.Pow(bloomTightness, float64(i))
+				if rate := store.filterErrorRate(i); math.Abs(rate-wantRate) > 1e-15 {
+					t.Fatalf("filterErrorRate(%d)=%v, want %v", i, rate, wantRate)
+				}
+			}
+			//没有漏判，再次添加都返回false
+			for i := 0; i < test.adds; i++ {
+				key := fmt.Sprintf("key-%d", i)
+				if !store.Contains(key) || store.Add(key) {
+					t.Fatalf("%s is not deduplicated", key)
+				}
+			}
+			if rate := store.ErrorRate(); rate > errorRate {
+				t.Fatalf("ErrorRate()=%v, want <= %v", rate, errorRate)
+			}
+		})
+	}
+}
+
+func TestBloomDedupStoreFalsePositives(t *testing.T) {
+	tests := []struct {
+		initialCapacity uint64
+		errorRate       float64
+		adds            int
+	}{
+		{initialCapacity: 1000, errorRate: 0.01, adds: 1000},
+		{initialCapacity: 1000, errorRate: 0.01, adds: 20000},
+		{initialCapacity: 100, errorRate: 0.05, adds: 10000},
+	}
+	const probes = 20000
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d/%v+%d", test.initialCapacity, test.errorRate, test.adds), func(t *testing.T) {
+			store, err := NewBloomDedupStore(test.initialCapacity, test.errorRate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < test.adds; i++ {
+				store.Add(fmt.Sprintf("added-%d", i))
+			}
+			falsePositives := 0
+			for i := 0; i < probes; i++ {
+				if store.Contains(fmt.Sprintf("probe-%d", i)) {
+					falsePositives++
+				}
+			}
+			if rate := float64(falsePositives) / probes; rate > test.errorRate {
+				t.Fatalf("false positive rate %v, want <= %v", rate, test.errorRate)
+			}
+		})
+	}
+}
+
+func TestNewBloomDedupStoreInvalid(t *testing.T) {
+	tests := []struct {
+		initialCapacity uint64
+		errorRate       float64
+	}{
+		{initialCapacity: 0, errorRate: 0.01},
+		{initialCapacity: 100, errorRate: 0},
+		{initialCapacity: 100, errorRate: -0.1},
+		{initialCapacity: 100, errorRate: 1},
+	}
+	for _, test := range tests {
+		if _, err := NewBloomDedupStore(test.initialCapacity, test.errorRate); err == nil {
+			t.Errorf("NewBloomDedupStore(%d, %v) returned no error", test.initialCapacity, test.errorRate)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fmyxyz/goreptile/analyzer"
+	"github.com/fmyxyz/goreptile/base"
+	"github.com/fmyxyz/goreptile/config"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func runParse(flags *flag.FlagSet, args []string) error {
+	configPath := flags.String("config", "", "config `file` (YAML or TOML); its analyzer and downloader settings are used")
+	rules := flags.String("rules", "", "rule set `file`; overrides analyzer.rules")
+	baseUrl := flags.String("url", "", "the `url` a saved file was downloaded from, used to resolve links and match rules (default: the file:// url of the file)")
+	contentType := flags.String("type", "", "content `type` of a saved file (default: guessed from the extension and content)")
+	depth := flags.Uint("depth", 0, "depth of the response")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("The file or url is required!")
+	}
+	source := flags.Arg(0)
+
+	file, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	if *rules != "" {
+		file.Analyzer.Rules = *rules
+	}
+	parsers, err := file.BuildParsers()
+	if err != nil {
+		return err
+	}
+	var resp *base.Response
+	if lower := strings.ToLower(source); strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+		resp, err = download(file, source, uint32(*depth))
+	} else {
+		resp, err = savedResponse(source, *baseUrl, *contentType, uint32(*depth))
+	}
+	if err != nil {
+		return err
+	}
+
+	//与爬取时一样由分析器解析，输出的条目和请求与爬取时产生的相同
+	dataList, errs := analyzer.NewAnalyzer().Analyze(respParsers(parsers), *resp)
+	encoder := json.NewEncoder(os.Stdout)
+	for _, data := range dataList {
+		switch d := data.(type) {
+		case base.Item:
+			err = encoder.Encode(map[string]interface{}{"item": d})
+		case *base.Item:
+			err = encoder.Encode(map[string]interface{}{"item": *d})
+		case *base.Request:
+			var line *seedLine
+			line, err = newSeedLine(d)
+			if err == nil {
+				err = encoder.Encode(map[string]interface{}{"request": line})
+			}
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+	}
+	if len(errs) > 0 {
+		return errors.New(fmt.Sprintf("%d errors occurred while parsing %s", len(errs), source))
+	}
+	return nil
+}
+
+//由保存的文件构造响应
+func savedResponse(path string, rawUrl string, contentType string, depth uint32) (*base.Response, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if rawUrl == "" {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		rawUrl = (&url.URL{Scheme: "file", Path: filepath.ToSlash(absPath)}).String()
+	}
+	httpReq, err := http.NewRequest(http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(path))
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	httpResp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {contentType}},
+		Request:    httpReq,
+	}
+	resp := base.NewResponse(httpResp, depth)
+	resp.SetBody(body)
+	return resp, nil
+}
+
+//与种子文件中 JSON 种子格式相同的请求，见 seed.ParseLine
+type seedLine struct {
+	Url      string                 `json:"url"`
+	Method   string                 `json:"method,omitempty"`
+	Headers  map[string][]string    `json:"headers,omitempty"`
+	Body     string                 `json:"body,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+	Priority int                    `json:"priority,omitempty"`
+	Callback string                 `json:"callback,omitempty"`
+}
+
+func newSeedLine(req *base.Request) (*seedLine, error) {
+	httpReq := req.HttpReq()
+	if httpReq == nil || httpReq.URL == nil {
+		return nil, errors.New("The request is invalid!")
+	}
+	body, err := req.BodyBytes()
+	if err != nil {
+		return nil, err
+	}
+	line := &seedLine{
+		Url:      httpReq.URL.String(),
+		Body:     string(body),
+		Meta:     req.MetaMap(),
+		Priority: req.Priority(),
+		Callback: req.Callback(),
+	}
+	if httpReq.Method != http.MethodGet {
+		line.Method = httpReq.Method
+	}
+	if len(httpReq.Header) > 0 {
+		line.Headers = httpReq.Header
+	}
+	return line, nil
+}
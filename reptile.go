@@ -2,62 +2,124 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/fmyxyz/goreptile/analyzer"
 	"github.com/fmyxyz/goreptile/base"
-	"github.com/fmyxyz/goreptile/itempipeline"
-	sched "github.com/fmyxyz/goreptile/scheduler"
-	"github.com/fmyxyz/goreptile/tool"
+	"github.com/fmyxyz/goreptile/config"
+	"github.com/fmyxyz/goreptile/downloader"
+	"github.com/fmyxyz/goreptile/scheduler"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
-	"time"
 )
 
+//子命令
+type command struct {
+	name    string
+	usage   string //参数说明
+	summary string
+	run     func(flags *flag.FlagSet, args []string) error
+}
+
+var commands = []*command{
+	{"crawl", "[flags] [seed url...]", "Crawl from the seeds and write the items as JSON lines", runCrawl},
+	{"resume", "[flags] data-dir", "Continue a crawl started with crawl -data", runResume},
+	{"fetch", "[flags] url", "Download one url through the configured downloader and print the status, headers and body", runFetch},
+	{"parse", "[flags] file|url", "Run the configured parsers against a saved file or url and print the items and requests", runParse},
+	{"schema", "", "Print every config key with its type, default and environment variable", runSchema},
+}
+
 func main() {
-	scheduler := sched.NewScheduler()
-
-	interverNs := 10 * time.Millisecond
-	var maxIdleCount uint = 10000
-
-	checkChan := tool.Monitoring(
-		scheduler,
-		interverNs,
-		maxIdleCount,
-		true,
-		true,
-		record)
-
-	var channelArgs = base.NewChannelArgs(10, 10, 10, 10)
-	var poolBaseArgs = base.NewPoolBaseArgs(3, 3)
-	var crawlDepth uint32 = 10
-	var httpClientGennerator = genHttpClien
-	var respParsers = getResponseParsers()
-	var itemProcessors = gerItemProcessors()
-	var startUrl = "https://www.csdn.net/"
-	firstHttpReq, err := http.NewRequest("GET", startUrl, nil)
-	if err != nil {
-		logger.Println(err)
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "-help" || os.Args[1] == "help" {
+		usage()
 		return
 	}
-	//go func() {
-	scheduler.Start(
-		channelArgs,
-		poolBaseArgs,
-		crawlDepth,
-		httpClientGennerator,
-		respParsers,
-		itemProcessors,
-		firstHttpReq,
-	)
-	//}()
-
-	//scheduler.Stop()
-	<-checkChan
+	for _, cmd := range commands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+		if err := cmd.run(newFlagSet(cmd), os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "goreptile %s: %s\n", cmd.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "goreptile: unknown command %q\n\n", os.Args[1])
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: goreptile <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-7s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Settings are read from the config file (-config, YAML or TOML), then from GOREPTILE_* environment")
+	fmt.Fprintln(os.Stderr, "variables, then from the command-line flags. Run \"goreptile schema\" for every key.")
+	fmt.Fprintln(os.Stderr, "Run \"goreptile <command> -h\" for the flags of a command.")
+}
+
+//创建子命令的参数集
+func newFlagSet(cmd *command) *flag.FlagSet {
+	flags := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: goreptile %s %s\n\n%s.\n\n", cmd.name, cmd.usage, cmd.summary)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+//可重复的字符串参数
+type stringList []string
+
+func (this *stringList) String() string {
+	return strings.Join(*this, ",")
+}
+
+func (this *stringList) Set(value string) error {
+	*this = append(*this, value)
+	return nil
+}
+
+//判断参数是否在命令行中给出
+func flagGiven(flags *flag.FlagSet, name string) bool {
+	given := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+//没有配置解析函数时，使用默认的链接解析函数
+func respParsers(parsers []analyzer.ParseResponse) []analyzer.ParseResponse {
+	if len(parsers) == 0 {
+		return []analyzer.ParseResponse{parserForATag}
+	}
+	return parsers
+}
+
+func runSchema(flags *flag.FlagSet, args []string) error {
+	flags.Parse(args)
+	return config.WriteSchema(os.Stdout)
+}
+
+//命令行和调度器等模块的日志都输出到标准错误，标准输出只用于条目等结果
+var logger = log.New(os.Stderr, "goreptile", log.LstdFlags)
+
+func init() {
+	scheduler.SetLogOutput(os.Stderr)
+	analyzer.SetLogOutput(os.Stderr)
+	downloader.SetLogOutput(os.Stderr)
 }
 
 func record(level byte, content string) {
@@ -77,58 +139,13 @@ func record(level byte, content string) {
 	}
 }
 
-func gerItemProcessors() []itempipeline.ProcessItem {
-	processItems := []itempipeline.ProcessItem{
-		processItem,
-	}
-	return processItems
-}
-
-func processItem(item base.Item) (result base.Item, err error) {
-	if item == nil {
-		return nil, errors.New("Invalid item!")
-	}
-	result = make(map[string]interface{})
-	for k, v := range item {
-		result[k] = v
-	}
-	if _, ok := result["number"]; !ok {
-		result["number"] = len(result)
-	}
-
-	f, err := os.Open("F:/tmp/a.txt")
-	if err != nil {
-		f, _ = os.Create("F:/tmp/a.txt")
-	}
-	defer f.Close()
-	for k, v := range result {
-		f.WriteString(fmt.Sprint("map[", k, "]", v, "\n"))
-		f.WriteString(fmt.Sprint("------------------------------------------"))
-	}
-
-	time.Sleep(10 * time.Millisecond)
-	return result, nil
-}
-
-func getResponseParsers() []analyzer.ParseResponse {
-	parses := []analyzer.ParseResponse{
-		parserForATag,
-	}
-	return parses
-}
-func genHttpClien() *http.Client {
-	return &http.Client{}
-}
-
-var logger = log.New(os.Stdout, "scheduler", log.LstdFlags)
-
+//默认的解析函数，提取页面中的链接和链接文字
 func parserForATag(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
 	if httpResp.StatusCode != 200 {
-		err := errors.New(fmt.Sprintf("Unsupported status code %d. (httpResponse=%v)", httpResp.StatusCode))
+		err := errors.New(fmt.Sprintf("Unsupported status code %d. (reqUrl=%s)", httpResp.StatusCode, httpResp.Request.URL))
 		return nil, []error{err}
 	}
 	var reqUrl *url.URL = httpResp.Request.URL
-	record(0, "processing url:"+reqUrl.String())
 	var httpRespBody io.ReadCloser = httpResp.Body
 	defer func() {
 		if httpRespBody != nil {
@@ -173,7 +190,7 @@ func parserForATag(httpResp *http.Response, respDepth uint32) ([]base.Data, []er
 		if text != "" {
 			imap := make(map[string]interface{})
 			imap["a.text"] = text
-			imap["parent_url"] = reqUrl
+			imap["parent_url"] = reqUrl.String()
 			item := base.Item(imap)
 			dataList = append(dataList, item)
 		}
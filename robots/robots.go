@@ -0,0 +1,202 @@
+package robots
+
+import (
+	"bufio"
+	"bytes"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//robots.txt 中的一条规则
+type rule struct {
+	allow   bool
+	pattern string
+}
+
+//robots.txt 中的一组规则，对应一个或多个 User-agent
+type group struct {
+	agents     []string
+	rules      []rule
+	crawlDelay time.Duration
+}
+
+//解析后的 robots.txt
+type Robots struct {
+	groups   []*group
+	sitemaps []string
+	allowAll bool //允许全部，如 robots.txt 不存在
+	denyAll  bool //禁止全部，如 robots.txt 暂时无法获取
+}
+
+//允许访问全部URL的 robots.txt
+func AllowAll() *Robots {
+	return &Robots{allowAll: true}
+}
+
+//禁止访问全部URL的 robots.txt
+func DenyAll() *Robots {
+	return &Robots{denyAll: true}
+}
+
+//根据 robots.txt 的响应状态码生成对应的规则
+//2xx 解析内容，4xx 视为不存在，其他状态码视为暂时禁止访问
+func FromStatus(statusCode int, body []byte) *Robots {
+	switch {
+	case statusCode >= 200 && statusCode < 300:
+		return Parse(body)
+	case statusCode >= 400 && statusCode < 500:
+		return AllowAll()
+	default:
+		return DenyAll()
+	}
+}
+
+//解析 robots.txt 的内容
+func Parse(data []byte) *Robots {
+	robots := &Robots{}
+	var current *group
+	var lastWasAgent bool
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := strings.TrimSpace(kv[1])
+		switch key {
+		case "user-agent":
+			if current == nil || !lastWasAgent {
+				current = &group{}
+				robots.groups = append(robots.groups, current)
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			lastWasAgent = true
+			continue
+		case "allow", "disallow":
+			if current != nil && value != "" {
+				current.rules = append(current.rules, rule{allow: key == "allow", pattern: value})
+			}
+		case "crawl-delay":
+			if current != nil {
+				if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+					current.crawlDelay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		case "sitemap":
+			if value != "" {
+				robots.sitemaps = append(robots.sitemaps, value)
+			}
+		}
+		lastWasAgent = false
+	}
+	return robots
+}
+
+//查找与 User-agent 匹配的规则组
+//优先匹配指定了该 User-agent 的组，否则使用 * 组
+func (this *Robots) match(userAgent string) []*group {
+	userAgent = strings.ToLower(userAgent)
+	var specific, wildcard []*group
+	for _, g := range this.groups {
+		for _, agent := range g.agents {
+			if agent == "*" {
+				wildcard = append(wildcard, g)
+				break
+			}
+			if userAgent != "" && strings.Contains(userAgent, agent) {
+				specific = append(specific, g)
+				break
+			}
+		}
+	}
+	if len(specific) > 0 {
+		return specific
+	}
+	return wildcard
+}
+
+//判断 User-agent 是否可以访问该URL
+//匹配最长的规则生效，长度相同时 Allow 优先
+func (this *Robots) Allowed(userAgent string, u *url.URL) bool {
+	if this.allowAll {
+		return true
+	}
+	if this.denyAll {
+		return false
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	allowed := true
+	matched := -1
+	for _, g := range this.match(userAgent) {
+		for _, r := range g.rules {
+			if !matchPattern(r.pattern, path) {
+				continue
+			}
+			if len(r.pattern) > matched || (len(r.pattern) == matched && r.allow) {
+				matched = len(r.pattern)
+				allowed = r.allow
+			}
+		}
+	}
+	return allowed
+}
+
+//获取 User-agent 的抓取间隔，未指定时返回0
+func (this *Robots) CrawlDelay(userAgent string) time.Duration {
+	var delay time.Duration
+	for _, g := range this.match(userAgent) {
+		if g.crawlDelay > delay {
+			delay = g.crawlDelay
+		}
+	}
+	return delay
+}
+
+//获取 robots.txt 中声明的站点地图
+func (this *Robots) Sitemaps() []string {
+	return this.sitemaps
+}
+
+//匹配规则，支持 * 通配符和 $ 结尾标记
+func matchPattern(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = strings.TrimSuffix(pattern, "$")
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	last := len(parts) - 1
+	for i := 1; i < last; i++ {
+		j := strings.Index(path[pos:], parts[i])
+		if j < 0 {
+			return false
+		}
+		pos += j + len(parts[i])
+	}
+	if last == 0 {
+		return !anchored || pos == len(path)
+	}
+	if anchored {
+		return strings.HasSuffix(path[pos:], parts[last])
+	}
+	return strings.Contains(path[pos:], parts[last])
+}
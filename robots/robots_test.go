@@ -0,0 +1,164 @@
+package robots
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testRobots = `# 注释行
+User-agent: *
+Disallow: /private/
+Allow: /private/public
+Disallow: /*.pdf$
+Disallow: /search?q=
+Crawl-delay: 2
+
+User-agent: GoReptile
+User-agent: OtherBot
+Disallow: /reptile/
+Allow: /reptile/ok
+Crawl-delay: 0.5   # 行尾注释
+
+User-agent: EmptyBot
+Disallow:
+
+Sitemap: http://example.com/sitemap.xml
+sitemap: http://example.com/news.xml
+`
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/", "/", true},
+		{"/", "/a", true},
+		{"/a", "/", false},
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish", false},
+		{"/fish/", "/fish", false},
+		{"/fish*", "/fish", true},
+		{"/fish*.php", "/fish.php", true},
+		{"/fish*.php", "/fishheads/catfish.php?id=1", true},
+		{"/fish*.php", "/fish.asp", false},
+		{"/*.php$", "/index.php", true},
+		{"/*.php$", "/index.php?a=1", false},
+		{"/*.php$", "/index.php5", false},
+		{"/a$", "/a", true},
+		{"/a$", "/ab", false},
+		{"/*/b/*/d", "/a/b/c/d", true},
+		{"/*/b/*/d", "/a/c/b/d", false},
+		{"*", "/anything", true},
+	}
+	for _, test := range tests {
+		if got := matchPattern(test.pattern, test.path); got != test.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", test.pattern, test.path, got, test.want)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	robots := Parse([]byte(testRobots))
+	tests := []struct {
+		userAgent string
+		rawUrl    string
+		want      bool
+	}{
+		{"Mozilla/5.0", "http://example.com/", true},
+		{"Mozilla/5.0", "http://example.com/private/a", false},
+		{"Mozilla/5.0", "http://example.com/private/public/a", true},
+		{"Mozilla/5.0", "http://example.com/doc.pdf", false},
+		{"Mozilla/5.0", "http://example.com/doc.pdf?v=1", true},
+		{"Mozilla/5.0", "http://example.com/search?q=go", false},
+		{"Mozilla/5.0", "http://example.com/search", true},
+		{"Mozilla/5.0", "http://example.com/robots.txt", true},
+		//指定了 User-agent 的组优先，不再使用 * 组
+		{"goreptile/1.0", "http://example.com/private/a", true},
+		{"goreptile/1.0", "http://example.com/reptile/a", false},
+		{"goreptile/1.0", "http://example.com/reptile/ok", true},
+		{"OtherBot", "http://example.com/reptile/a", false},
+		{"EmptyBot", "http://example.com/private/a", true},
+		{"", "http://example.com/private/a", false},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.rawUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := robots.Allowed(test.userAgent, u); got != test.want {
+			t.Errorf("Allowed(%q, %s) = %v, want %v", test.userAgent, test.rawUrl, got, test.want)
+		}
+	}
+}
+
+func TestAllowedLongestMatch(t *testing.T) {
+	tests := []struct {
+		robots string
+		path   string
+		want   bool
+	}{
+		{"User-agent: *\nDisallow: /a\nAllow: /a/b\n", "/a/b/c", true},
+		{"User-agent: *\nAllow: /a\nDisallow: /a/b\n", "/a/b/c", false},
+		//长度相同时 Allow 优先
+		{"User-agent: *\nDisallow: /a\nAllow: /a\n", "/a", true},
+		{"User-agent: *\nDisallow: /\n", "/", false},
+		{"User-agent: *\nDisallow: /\n", "/robots.txt", true},
+		{"", "/a", true},
+	}
+	for _, test := range tests {
+		robots := Parse([]byte(test.robots))
+		if got := robots.Allowed("bot", &url.URL{Path: test.path}); got != test.want {
+			t.Errorf("Allowed(%q) with %q = %v, want %v", test.path, test.robots, got, test.want)
+		}
+	}
+}
+
+func TestCrawlDelay(t *testing.T) {
+	robots := Parse([]byte(testRobots))
+	tests := []struct {
+		userAgent string
+		want      time.Duration
+	}{
+		{"Mozilla/5.0", 2 * time.Second},
+		{"GoReptile", 500 * time.Millisecond},
+		{"EmptyBot", 0},
+	}
+	for _, test := range tests {
+		if got := robots.CrawlDelay(test.userAgent); got != test.want {
+			t.Errorf("CrawlDelay(%q) = %s, want %s", test.userAgent, got, test.want)
+		}
+	}
+}
+
+func TestSitemaps(t *testing.T) {
+	want := []string{"http://example.com/sitemap.xml", "http://example.com/news.xml"}
+	if got := Parse([]byte(testRobots)).Sitemaps(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Sitemaps() = %v, want %v", got, want)
+	}
+}
+
+func TestFromStatus(t *testing.T) {
+	body := []byte("User-agent: *\nDisallow: /private/\n")
+	tests := []struct {
+		statusCode int
+		path       string
+		want       bool
+	}{
+		{200, "/private/a", false},
+		{200, "/public", true},
+		{404, "/private/a", true},
+		{401, "/private/a", true},
+		{500, "/public", false},
+		{503, "/public", false},
+		{301, "/public", false},
+	}
+	for _, test := range tests {
+		robots := FromStatus(test.statusCode, body)
+		if got := robots.Allowed("bot", &url.URL{Path: test.path}); got != test.want {
+			t.Errorf("FromStatus(%d).Allowed(%q) = %v, want %v", test.statusCode, test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package scheduler
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fmyxyz/goreptile/analyzer"
+	"github.com/fmyxyz/goreptile/base"
+	"github.com/fmyxyz/goreptile/downloader"
+	"github.com/fmyxyz/goreptile/itempipeline"
+	"github.com/fmyxyz/goreptile/middleware"
+	"github.com/fmyxyz/goreptile/scope"
+	"github.com/fmyxyz/goreptile/seed"
+	"net/http"
+	"time"
+)
+
+//调度器配置，包括 Start 的参数和启动前的各项设置
+//除 MaxBodySize 外，零值字段使用对应设置的默认值；MaxBodySize 为0时不限制，
+//DefaultConfig 给出了常用的默认配置，其中 MaxBodySize 为 downloader.DefaultMaxBodySize
+type Config struct {
+	DataDir string //持久化目录，为空时不持久化，见 NewPersistentScheduler
+
+	ChannelArgs         base.ChannelArgs
+	PoolBaseArgs        base.PoolBaseArgs
+	CrawlDepth          uint32 //爬取深度，深度大于该值的请求会被丢弃
+	HttpClientGenerator GenHttpClient
+	RespParsers         []analyzer.ParseResponse
+	ItemProcessors      []itempipeline.ProcessItem
+
+	FirstHttpReq *http.Request //首个请求，有种子来源时可以为nil
+	Seeds        []seed.Source //种子来源
+	Sitemaps     base.SitemapArgs
+	FeedInterval time.Duration //订阅源的轮询间隔
+	FeedUrls     []string      //订阅源
+
+	FrontierStrategy FrontierStrategy
+	ScoreRequest     ScoreRequest
+	Scope            *scope.Policy //为nil时只允许与首个请求同一主域名的URL
+	Politeness       base.PolitenessArgs
+	Robots           base.RobotsArgs
+	Retry            base.RetryArgs
+
+	Fingerprinter *base.Fingerprinter      //为nil时使用默认的请求指纹生成器
+	DedupStore    middleware.GenDedupStore //为nil时使用精确的去重存储
+
+	DownloaderMiddlewares []downloader.DownloaderMiddleware
+	ProxyPool             *downloader.ProxyPool
+	MaxBodySize           int64 //响应体的最大长度，为0时不限制
+}
+
+//默认配置，调用方至少还需要设置解析函数、条目处理器和首个请求（或种子来源）
+func DefaultConfig() Config {
+	return Config{
+		ChannelArgs:  base.NewChannelArgs(10, 10, 10, 10),
+		PoolBaseArgs: base.NewPoolBaseArgs(3, 3),
+		CrawlDepth:   10,
+		HttpClientGenerator: func() *http.Client {
+			return &http.Client{}
+		},
+		MaxBodySize: downloader.DefaultMaxBodySize,
+	}
+}
+
+//配置错误，包含配置中的所有问题
+type ConfigError struct {
+	Problems []string
+}
+
+func (this *ConfigError) Error() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("The scheduler config is invalid! (%d problems)", len(this.Problems)))
+	for _, problem := range this.Problems {
+		buffer.WriteString("\n  - ")
+		buffer.WriteString(problem)
+	}
+	return buffer.String()
+}
+
+//检查配置，返回的 *ConfigError 包含所有问题，没有问题时返回nil
+func (this *Config) Validate() error {
+	var problems []string
+	addProblem := func(field string, err error) {
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", field, err))
+		}
+	}
+	addProblem("ChannelArgs", this.ChannelArgs.Check())
+	addProblem("PoolBaseArgs", this.PoolBaseArgs.Check())
+	if this.HttpClientGenerator == nil {
+		problems = append(problems, "HttpClientGenerator: The http client generator is invalid!")
+	}
+	for i, parser := range this.RespParsers {
+		if parser == nil {
+			problems = append(problems, fmt.Sprintf("RespParsers[%d]: The response parser is invalid!", i))
+		}
+	}
+	if len(this.ItemProcessors) == 0 {
+		problems = append(problems, "ItemProcessors: The item processor list is empty!")
+	}
+	for i, processor := range this.ItemProcessors {
+		if processor == nil {
+			problems = append(problems, fmt.Sprintf("ItemProcessors[%d]: The item processor is invalid!", i))
+		}
+	}
+	if this.FirstHttpReq == nil && len(this.Seeds) == 0 {
+		problems = append(problems, "FirstHttpReq: The first http request is required when there is no seed source!")
+	}
+	if this.FirstHttpReq != nil && this.FirstHttpReq.URL == nil {
+		problems = append(problems, "FirstHttpReq: The url of the first http request is invalid!")
+	}
+	if this.FirstHttpReq == nil && this.Scope == nil {
+		problems = append(problems, "Scope: The scope policy is required when there is no first http request!")
+	}
+	for i, source := range this.Seeds {
+		if source == nil {
+			problems = append(problems, fmt.Sprintf("Seeds[%d]: The seed source is invalid!", i))
+		}
+	}
+	addProblem("Sitemaps", this.Sitemaps.Check())
+	if this.Sitemaps.Enabled() && this.Sitemaps.Discover() && this.FirstHttpReq == nil && len(this.Sitemaps.SitemapUrls()) == 0 {
+		problems = append(problems, "Sitemaps: Sitemap discovery requires the first http request!")
+	}
+	_, err := newFeedPolling(this.FeedInterval, this.FeedUrls)
+	addProblem("FeedUrls", err)
+	addProblem("FrontierStrategy", checkFrontier(this.FrontierStrategy, this.ScoreRequest))
+	addProblem("Politeness", this.Politeness.Check())
+	addProblem("Robots", this.Robots.Check())
+	addProblem("Retry", this.Retry.Check())
+	for i, middleware := range this.DownloaderMiddlewares {
+		if middleware == nil {
+			problems = append(problems, fmt.Sprintf("DownloaderMiddlewares[%d]: The downloader middleware is invalid!", i))
+		}
+	}
+	if this.MaxBodySize < 0 {
+		problems = append(problems, fmt.Sprintf("MaxBodySize: The max body size is invalid! (maxBodySize=%d)", this.MaxBodySize))
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+//按配置创建并启动调度器，DataDir 不为空时创建可恢复的调度器
+func StartScheduler(config Config) (Scheduler, error) {
+	var scheduler Scheduler
+	if config.DataDir != "" {
+		scheduler = NewPersistentScheduler(config.DataDir)
+	} else {
+		scheduler = NewScheduler()
+	}
+	if err := scheduler.StartWithConfig(config); err != nil {
+		return nil, err
+	}
+	return scheduler, nil
+}
+
+//启动前的各项设置，StartWithConfig 失败时据此恢复
+type settings struct {
+	frontierStrategy FrontierStrategy
+	scoreRequest     ScoreRequest
+	politenessArgs   base.PolitenessArgs
+	robotsArgs       base.RobotsArgs
+	retryArgs        base.RetryArgs
+	scopePolicy      *scope.Policy
+	fingerprinter    *base.Fingerprinter
+	genDedupStore    middleware.GenDedupStore
+	dlMiddlewares    []downloader.DownloaderMiddleware
+	proxyPool        *downloader.ProxyPool
+	maxBodySize      int64
+	feeds            *feedPolling
+	sitemapArgs      base.SitemapArgs
+	seedSources      []seed.Source
+}
+
+func (this *myScheduler) settings() settings {
+	return settings{
+		frontierStrategy: this.frontierStrategy,
+		scoreRequest:     this.scoreRequest,
+		politenessArgs:   this.politenessArgs,
+		robotsArgs:       this.robotsArgs,
+		retryArgs:        this.retryArgs,
+		scopePolicy:      this.scopePolicy,
+		fingerprinter:    this.fingerprinter,
+		genDedupStore:    this.genDedupStore,
+		dlMiddlewares:    this.dlMiddlewares,
+		proxyPool:        this.proxyPool,
+		maxBodySize:      this.maxBodySize,
+		feeds:            this.feeds,
+		sitemapArgs:      this.sitemapArgs,
+		seedSources:      this.seedSources,
+	}
+}
+
+func (this *myScheduler) restoreSettings(saved settings) {
+	this.frontierStrategy = saved.frontierStrategy
+	this.scoreRequest = saved.scoreRequest
+	this.politenessArgs = saved.politenessArgs
+	this.robotsArgs = saved.robotsArgs
+	this.retryArgs = saved.retryArgs
+	this.scopePolicy = saved.scopePolicy
+	this.fingerprinter = saved.fingerprinter
+	this.genDedupStore = saved.genDedupStore
+	this.dlMiddlewares = saved.dlMiddlewares
+	this.proxyPool = saved.proxyPool
+	this.maxBodySize = saved.maxBodySize
+	this.feeds = saved.feeds
+	this.sitemapArgs = saved.sitemapArgs
+	this.seedSources = saved.seedSources
+}
+
+func (this *myScheduler) StartWithConfig(config Config) (err error) {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if config.DataDir != this.dataDir {
+		return &ConfigError{Problems: []string{fmt.Sprintf("DataDir: The data dir %q does not match the scheduler's %q!", config.DataDir, this.dataDir)}}
+	}
+	saved := this.settings()
+	defer func() {
+		if err != nil {
+			this.restoreSettings(saved)
+		}
+	}()
+	if err := this.SetFrontier(config.FrontierStrategy, config.ScoreRequest); err != nil {
+		return err
+	}
+	if err := this.SetPoliteness(config.Politeness); err != nil {
+		return err
+	}
+	if err := this.SetRobots(config.Robots); err != nil {
+		return err
+	}
+	if err := this.SetRetry(config.Retry); err != nil {
+		return err
+	}
+	if config.Scope != nil {
+		if err := this.SetScope(config.Scope); err != nil {
+			return err
+		}
+	}
+	if config.Fingerprinter != nil {
+		if err := this.SetFingerprinter(config.Fingerprinter); err != nil {
+			return err
+		}
+	}
+	if config.DedupStore != nil {
+		if err := this.SetDedupStore(config.DedupStore); err != nil {
+			return err
+		}
+	}
+	if err := this.SetDownloaderMiddlewares(config.DownloaderMiddlewares...); err != nil {
+		return err
+	}
+	if err := this.SetProxyPool(config.ProxyPool); err != nil {
+		return err
+	}
+	if err := this.SetMaxBodySize(config.MaxBodySize); err != nil {
+		return err
+	}
+	if err := this.SetFeeds(config.FeedInterval, config.FeedUrls...); err != nil {
+		return err
+	}
+	if err := this.SetSitemaps(config.Sitemaps); err != nil {
+		return err
+	}
+	if err := this.AddSeeds(config.Seeds...); err != nil {
+		return err
+	}
+	return this.Start(config.ChannelArgs, config.PoolBaseArgs, config.CrawlDepth,
+		config.HttpClientGenerator, config.RespParsers, config.ItemProcessors, config.FirstHttpReq)
+}
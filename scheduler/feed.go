@@ -0,0 +1,175 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/analyzer"
+	"github.com/fmyxyz/goreptile/base"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+//订阅源请求的回调名称，其响应由调度器按订阅源解析，不交给解析函数
+const FEED_CALLBACK = "goreptile.feed"
+
+//订阅源请求及条目链接请求的元数据的键
+const (
+	FEED_META_URL      = "feedUrl"
+	FEED_META_ENTRY_ID = "feedEntryId"
+)
+
+//订阅源轮询的参数和计数
+type feedPolling struct {
+	interval time.Duration //轮询间隔
+	urls     []*url.URL    //订阅源的URL
+
+	polls    uint64 //已发出的订阅源请求数
+	entries  uint64 //解析出的条目数
+	unseen   uint64 //未见过的条目数
+	enqueued uint64 //放入请求缓存的条目链接数
+}
+
+func (this *feedPolling) summary() string {
+	if this == nil {
+		return "<none>"
+	}
+	return fmt.Sprintf("feeds:%d,interval:%s,polls:%d,entries:%d,unseen:%d,enqueued:%d",
+		len(this.urls), this.interval,
+		atomic.LoadUint64(&this.polls), atomic.LoadUint64(&this.entries),
+		atomic.LoadUint64(&this.unseen), atomic.LoadUint64(&this.enqueued))
+}
+
+func (this *myScheduler) SetFeeds(interval time.Duration, feedUrls ...string) error {
+	if atomic.LoadUint32(&this.running) == 1 {
+		return errors.New("The feeds can not be changed while the scheduler is running!")
+	}
+	feeds, err := newFeedPolling(interval, feedUrls)
+	if err != nil {
+		return err
+	}
+	this.feeds = feeds
+	return nil
+}
+
+//检查轮询参数并创建订阅源轮询，feedUrls 为空时返回nil
+func newFeedPolling(interval time.Duration, feedUrls []string) (*feedPolling, error) {
+	if len(feedUrls) == 0 {
+		return nil, nil
+	}
+	if interval <= 0 {
+		return nil, errors.New(fmt.Sprintf("The feed polling interval is invalid! (interval=%s)", interval))
+	}
+	feeds := &feedPolling{interval: interval}
+	for _, feedUrl := range feedUrls {
+		u, err := url.Parse(feedUrl)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, errors.New(fmt.Sprintf("The feed url %q is invalid!", feedUrl))
+		}
+		feeds.urls = append(feeds.urls, u)
+	}
+	return feeds, nil
+}
+
+//启动订阅源轮询，立即轮询一次，之后每隔轮询间隔轮询一次，暂停期间不轮询
+func (this *myScheduler) pollFeeds() {
+	if this.feeds == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(this.feeds.interval)
+		defer ticker.Stop()
+		for {
+			if !this.Paused() {
+				this.pollFeedsOnce()
+			}
+			<-ticker.C
+			if this.stopSign.Signed() || !this.Running() {
+				return
+			}
+		}
+	}()
+}
+
+//把每个订阅源的请求放入请求缓存
+//订阅源请求需要重复下载，因此不经过去重和范围检查，但仍遵守 robots.txt
+func (this *myScheduler) pollFeedsOnce() {
+	for _, feedUrl := range this.feeds.urls {
+		if !this.robotsAllowed(feedUrl) {
+			logger.Printf("Ignore the feed ! It is disallowed by robots.txt (feedUrl='%s')\n", feedUrl)
+			continue
+		}
+		httpReq, err := http.NewRequest(http.MethodGet, feedUrl.String(), nil)
+		if err != nil {
+			this.SendError(err, SCHEDULER_CODE)
+			continue
+		}
+		req := base.NewRequest(httpReq, 0)
+		req.SetCallback(FEED_CALLBACK)
+		req.SetMeta(FEED_META_URL, feedUrl.String())
+		if this.stopSign.Signed() {
+			return
+		}
+		this.reqCache.put(req)
+		atomic.AddUint64(&this.feeds.polls, 1)
+	}
+}
+
+//解析订阅源的响应
+//只有未见过的条目才会产生条目和对条目链接的请求，条目按订阅源的URL和条目的 Id 去重
+func (this *myScheduler) analyzeFeed(resp base.Response, code string) {
+	httpResp := resp.HttpReq()
+	if httpResp == nil || httpResp.Request == nil || httpResp.Request.URL == nil {
+		this.SendError(errors.New("The http response of feed is invalid!"), code)
+		return
+	}
+	body, err := resp.Body()
+	if err != nil {
+		this.SendError(err, code)
+		return
+	}
+	reqUrl := httpResp.Request.URL
+	feed, err := analyzer.ParseFeedDocument(body, httpResp.Header.Get("Content-Type"), reqUrl)
+	if err != nil {
+		this.SendError(errors.New(fmt.Sprintf("%s (feedUrl=%s)", err, reqUrl)), code)
+		return
+	}
+	feedUrl := reqUrl.String()
+	if value, ok := resp.Meta(FEED_META_URL); ok {
+		feedUrl = fmt.Sprint(value)
+	}
+	if this.feeds != nil {
+		atomic.AddUint64(&this.feeds.entries, uint64(len(feed.Entries)))
+	}
+	for i := range feed.Entries {
+		entry := &feed.Entries[i]
+		//同一订阅源的多个响应可能同时被分析，先原子地标记为已见过，只有标记成功的分析产生条目
+		//去重存储不能删除键，停止时未发送的条目不会再产生
+		if entry.Id == "" || !this.urls.add("feed-entry:"+feedUrl+"#"+entry.Id) {
+			continue
+		}
+		if this.feeds != nil {
+			atomic.AddUint64(&this.feeds.unseen, 1)
+		}
+		if !this.sendItem(entry.Item(feed, feedUrl), code) {
+			return
+		}
+		if entry.Link == "" {
+			continue
+		}
+		httpReq, err := http.NewRequest(http.MethodGet, entry.Link, nil)
+		if err != nil {
+			this.SendError(err, code)
+			continue
+		}
+		req := base.NewRequest(httpReq, resp.Depth()+1)
+		req.SetMeta(FEED_META_URL, feedUrl)
+		req.SetMeta(FEED_META_ENTRY_ID, entry.Id)
+		var enqueued *uint64
+		if this.feeds != nil {
+			enqueued = &this.feeds.enqueued
+		}
+		this.savaReqToCache(*req, code, enqueued)
+	}
+}
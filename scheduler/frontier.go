@@ -0,0 +1,184 @@
+package scheduler
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"sync"
+)
+
+//请求缓存的调度策略
+type FrontierStrategy uint8
+
+const (
+	FRONTIER_BFS        FrontierStrategy = iota //广度优先，先放入的请求先下载
+	FRONTIER_DFS                                //深度优先，后放入的请求先下载
+	FRONTIER_PRIORITY                           //优先级高的请求先下载，优先级相同时先放入的先下载
+	FRONTIER_BEST_FIRST                         //按评分函数的分值，分值高的请求先下载
+)
+
+var frontierStrategyNameMap = map[FrontierStrategy]string{
+	FRONTIER_BFS:        "bfs",
+	FRONTIER_DFS:        "dfs",
+	FRONTIER_PRIORITY:   "priority",
+	FRONTIER_BEST_FIRST: "best-first",
+}
+
+func (this FrontierStrategy) String() string {
+	if name, ok := frontierStrategyNameMap[this]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", this)
+}
+
+//请求评分函数，分值越高越先被下载
+type ScoreRequest func(req *base.Request) float64
+
+//检查调度策略及评分函数
+func checkFrontier(strategy FrontierStrategy, score ScoreRequest) error {
+	if _, ok := frontierStrategyNameMap[strategy]; !ok {
+		return errors.New(fmt.Sprintf("The frontier strategy %d is invalid!", strategy))
+	}
+	if strategy == FRONTIER_BEST_FIRST && score == nil {
+		return errors.New("The best-first frontier strategy requires a score function!")
+	}
+	return nil
+}
+
+//根据调度策略创建内存中的请求缓存
+func newRequestCacheWithStrategy(strategy FrontierStrategy, score ScoreRequest) requestCache {
+	switch strategy {
+	case FRONTIER_DFS:
+		return newHeapRequestCache(strategy, func(a, b *heapItem) bool {
+			return a.seq > b.seq
+		})
+	case FRONTIER_PRIORITY:
+		return newHeapRequestCache(strategy, func(a, b *heapItem) bool {
+			if a.req.Priority() != b.req.Priority() {
+				return a.req.Priority() > b.req.Priority()
+			}
+			return a.seq < b.seq
+		})
+	case FRONTIER_BEST_FIRST:
+		rc := newHeapRequestCache(strategy, func(a, b *heapItem) bool {
+			if a.score != b.score {
+				return a.score > b.score
+			}
+			return a.seq < b.seq
+		})
+		rc.score = score
+		return rc
+	default:
+		return newRequestCache()
+	}
+}
+
+type heapItem struct {
+	req   *base.Request
+	seq   uint64
+	score float64
+}
+
+type heapItems struct {
+	items []*heapItem
+	less  func(a, b *heapItem) bool
+}
+
+func (this *heapItems) Len() int           { return len(this.items) }
+func (this *heapItems) Less(i, j int) bool { return this.less(this.items[i], this.items[j]) }
+func (this *heapItems) Swap(i, j int)      { this.items[i], this.items[j] = this.items[j], this.items[i] }
+
+func (this *heapItems) Push(x interface{}) {
+	this.items = append(this.items, x.(*heapItem))
+}
+
+func (this *heapItems) Pop() interface{} {
+	n := len(this.items)
+	item := this.items[n-1]
+	this.items[n-1] = nil
+	this.items = this.items[:n-1]
+	return item
+}
+
+//基于堆的请求缓存，按调度策略决定请求的顺序
+type reqCacheByHeap struct {
+	strategy FrontierStrategy
+	items    *heapItems
+	score    ScoreRequest
+	seq      uint64
+	mutex    sync.Mutex
+	status   byte //0 运行中 1已关闭
+}
+
+func newHeapRequestCache(strategy FrontierStrategy, less func(a, b *heapItem) bool) *reqCacheByHeap {
+	return &reqCacheByHeap{
+		strategy: strategy,
+		items:    &heapItems{items: make([]*heapItem, 0), less: less},
+	}
+}
+
+func (this *reqCacheByHeap) put(req *base.Request) bool {
+	if req == nil {
+		return false
+	}
+	var score float64
+	if this.score != nil {
+		score = this.score(req)
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.status == 1 {
+		return false
+	}
+	this.seq++
+	heap.Push(this.items, &heapItem{req: req, seq: this.seq, score: score})
+	return true
+}
+
+func (this *reqCacheByHeap) get(ready readyRequest) *base.Request {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.status == 1 || this.items.Len() == 0 {
+		return nil
+	}
+	//跳过的请求保留原来的序号放回堆中，顺序不变
+	var skipped []*heapItem
+	defer func() {
+		for _, item := range skipped {
+			heap.Push(this.items, item)
+		}
+	}()
+	for this.items.Len() > 0 && len(skipped) < readyScanLimit {
+		item := heap.Pop(this.items).(*heapItem)
+		if ready == nil || ready(item.req) {
+			return item.req
+		}
+		skipped = append(skipped, item)
+	}
+	return nil
+}
+
+func (this *reqCacheByHeap) done(seq uint64) {}
+
+func (this *reqCacheByHeap) capacity() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return cap(this.items.items)
+}
+
+func (this *reqCacheByHeap) length() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.items.Len()
+}
+
+func (this *reqCacheByHeap) close() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.status = 1
+}
+
+func (this *reqCacheByHeap) summary() string {
+	return fmt.Sprintf("status:%s ,strategy:%s,length:%d,capacity:%d", statusMap[this.status], this.strategy, this.length(), this.capacity())
+}
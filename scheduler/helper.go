@@ -1,13 +1,13 @@
 package scheduler
 
 import (
-	"errors"
 	"fmt"
 	"github.com/fmyxyz/goreptile/analyzer"
 	"github.com/fmyxyz/goreptile/base"
 	"github.com/fmyxyz/goreptile/downloader"
 	"github.com/fmyxyz/goreptile/itempipeline"
 	"github.com/fmyxyz/goreptile/middleware"
+	"github.com/fmyxyz/goreptile/scope"
 	"strings"
 )
 
@@ -25,19 +25,37 @@ func generateChannelManager(channelArgs base.ChannelArgs) middleware.ChannelMana
 	return middleware.NewChannelManager(channelArgs)
 }
 
-func generatePageDownloadPool(poolSize uint32, genHttpClient GenHttpClient) (downloader.PageDownloaderPool, error) {
+//生成网页下载器池
+//设置了代理池时，HTTP客户端经代理池包装，代理池作为最后一个中间件，紧挨着实际的下载
+func generatePageDownloadPool(poolSize uint32, genHttpClient GenHttpClient, maxBodySize int64, middlewares []downloader.DownloaderMiddleware, proxyPool *downloader.ProxyPool) (downloader.PageDownloaderPool, error) {
+	if proxyPool != nil {
+		if _, err := proxyPool.Client(genHttpClient()); err != nil {
+			return nil, err
+		}
+		middlewares = append(append([]downloader.DownloaderMiddleware(nil), middlewares...), proxyPool)
+	}
 	gen := func() downloader.PageDownloader {
-		return downloader.NewPageDownloader(genHttpClient())
+		client := genHttpClient()
+		if proxyPool != nil {
+			wrapped, err := proxyPool.Client(client)
+			if err != nil {
+				panic(err)
+			}
+			client = wrapped
+		}
+		return downloader.NewPageDownloaderWithMaxBodySize(client, maxBodySize, middlewares...)
 	}
 	return downloader.NewDownloaderPool(poolSize, gen)
 }
 
+//判断请求是否被下载器中间件丢弃
+func isDropped(err error) bool {
+	return err == downloader.ErrDropRequest
+}
+
+//获取主机的主域名（可注册域名），主机可以带端口
 func getPrimaryDomain(host string) (string, error) {
-	hp := strings.Split(host, ":")
-	if len(hp) != 2 {
-		return "", errors.New("The host is invalid!")
-	}
-	return hp[0], nil
+	return scope.RegistrableDomain(host)
 }
 
 func parseCode(code string) []string {
@@ -0,0 +1,433 @@
+package scheduler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"github.com/fmyxyz/goreptile/middleware"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	frontierFileName = "frontier.journal" //请求缓存日志文件
+	seenFileName     = "seen.log"         //已请求URL文件
+)
+
+//请求的持久化记录
+type reqRecord struct {
+	Method   string                 `json:"method"`
+	Url      string                 `json:"url"`
+	Header   http.Header            `json:"header,omitempty"`
+	Body     []byte                 `json:"body,omitempty"`
+	Depth    uint32                 `json:"depth"`
+	Priority int                    `json:"priority,omitempty"`
+	Attempt  uint32                 `json:"attempt,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+	Callback string                 `json:"callback,omitempty"`
+}
+
+//将请求转换为可持久化的记录
+func encodeRequest(req *base.Request) (*reqRecord, error) {
+	httpReq := req.HttpReq()
+	if httpReq == nil || httpReq.URL == nil {
+		return nil, errors.New("The request is invalid!")
+	}
+	record := &reqRecord{
+		Method:   httpReq.Method,
+		Url:      httpReq.URL.String(),
+		Header:   httpReq.Header,
+		Depth:    req.Depth(),
+		Priority: req.Priority(),
+		Attempt:  req.Attempt(),
+		Meta:     req.MetaMap(),
+		Callback: req.Callback(),
+	}
+	body, err := req.BodyBytes()
+	if err != nil {
+		return nil, err
+	}
+	record.Body = body
+	return record, nil
+}
+
+//根据持久化记录还原请求
+func decodeRequest(record *reqRecord) (*base.Request, error) {
+	var body io.Reader
+	if len(record.Body) > 0 {
+		body = bytes.NewReader(record.Body)
+	}
+	httpReq, err := http.NewRequest(record.Method, record.Url, body)
+	if err != nil {
+		return nil, err
+	}
+	if record.Header != nil {
+		httpReq.Header = record.Header
+	}
+	req := base.NewRequestWithPriority(httpReq, record.Depth, record.Priority)
+	req.SetAttempt(record.Attempt)
+	if len(record.Meta) > 0 {
+		req.SetMetaMap(record.Meta)
+	}
+	if record.Callback != "" {
+		req.SetCallback(record.Callback)
+	}
+	return req, nil
+}
+
+//请求缓存日志中的一条记录
+//op 为 put 时表示放入请求，为 done 时表示请求已处理完成
+type journalEntry struct {
+	Op  string     `json:"op"`
+	Seq uint64     `json:"seq"`
+	Req *reqRecord `json:"req,omitempty"`
+}
+
+const (
+	journalOpPut  = "put"
+	journalOpDone = "done"
+)
+
+//基于磁盘日志的请求缓存
+//请求的顺序仍由内存中的缓存决定，所有放入和完成的操作都会先写入日志，
+//重新打开时回放日志即可恢复到停止（或崩溃）前的请求缓存。
+//已取出但尚未处理完成的请求在恢复时会被重新放入缓存。
+//请求的序号记录在请求中（见 base.Request.Seq），并随 http 请求传递给响应，
+//因此重试、复制请求或中间件替换 http 请求都不影响完成的标记。
+type reqCacheByFile struct {
+	inner   requestCache
+	path    string
+	file    *os.File
+	writer  *bufio.Writer
+	seq     uint64
+	records uint64                //日志中的记录数
+	taken   map[uint64]struct{}   //已取出未完成的请求的序号
+	entries map[uint64]*reqRecord //尚未完成的请求记录
+	mutex   sync.Mutex
+	status  byte //0 运行中 1已关闭
+}
+
+//日志记录数超过存活记录数的倍数时进行压缩
+const journalCompactFactor = 4
+
+//打开（或创建）基于磁盘日志的请求缓存
+//inner 决定请求的顺序
+func newFileRequestCache(path string, inner requestCache) (requestCache, error) {
+	rc := &reqCacheByFile{
+		inner:   inner,
+		path:    path,
+		taken:   make(map[uint64]struct{}),
+		entries: make(map[uint64]*reqRecord),
+	}
+	if err := rc.replay(); err != nil {
+		return nil, err
+	}
+	seqs := make([]uint64, 0, len(rc.entries))
+	for seq := range rc.entries {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	for _, seq := range seqs {
+		req, err := decodeRequest(rc.entries[seq])
+		if err != nil {
+			logger.Printf("Ignore the persisted request %d : %s\n", seq, err)
+			delete(rc.entries, seq)
+			continue
+		}
+		req.SetSeq(seq)
+		rc.inner.put(req)
+	}
+	if err := rc.compact(); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+//回放日志
+//最后一行可能因崩溃而不完整，此时忽略该行
+func (this *reqCacheByFile) replay() error {
+	file, err := os.Open(this.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.Printf("Ignore the broken journal entry in '%s' : %s\n", this.path, err)
+			continue
+		}
+		switch entry.Op {
+		case journalOpPut:
+			if entry.Req != nil {
+				this.entries[entry.Seq] = entry.Req
+			}
+		case journalOpDone:
+			delete(this.entries, entry.Seq)
+		}
+		if entry.Seq > this.seq {
+			this.seq = entry.Seq
+		}
+	}
+	return scanner.Err()
+}
+
+//只保留尚未完成的请求，重写日志
+func (this *reqCacheByFile) compact() error {
+	if this.file != nil {
+		this.writer.Flush()
+		this.file.Close()
+	}
+	tmpPath := this.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	seqs := make([]uint64, 0, len(this.entries))
+	for seq := range this.entries {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	for _, seq := range seqs {
+		if err := encoder.Encode(&journalEntry{Op: journalOpPut, Seq: seq, Req: this.entries[seq]}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, this.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(this.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	this.file = file
+	this.writer = bufio.NewWriter(file)
+	this.records = uint64(len(seqs))
+	return nil
+}
+
+//写入一条日志并刷新到文件，进程崩溃时不会丢失
+func (this *reqCacheByFile) append(entry *journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	this.writer.Write(data)
+	this.writer.WriteByte('\n')
+	if err := this.writer.Flush(); err != nil {
+		return err
+	}
+	this.records++
+	if this.records > journalCompactFactor*uint64(len(this.entries))+1024 {
+		return this.compact()
+	}
+	return nil
+}
+
+func (this *reqCacheByFile) put(req *base.Request) bool {
+	if req == nil {
+		return false
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.status == 1 {
+		return false
+	}
+	record, err := encodeRequest(req)
+	if err != nil {
+		logger.Printf("Ignore the request ! It can not be persisted : %s\n", err)
+		return false
+	}
+	this.seq++
+	seq := this.seq
+	this.entries[seq] = record
+	if err := this.append(&journalEntry{Op: journalOpPut, Seq: seq, Req: record}); err != nil {
+		logger.Printf("Failed to write the request journal '%s' : %s\n", this.path, err)
+	}
+	req.SetSeq(seq)
+	return this.inner.put(req)
+}
+
+func (this *reqCacheByFile) get(ready readyRequest) *base.Request {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.status == 1 {
+		return nil
+	}
+	req := this.inner.get(ready)
+	if req == nil {
+		return nil
+	}
+	if seq := req.Seq(); seq != 0 {
+		this.taken[seq] = struct{}{}
+	}
+	return req
+}
+
+func (this *reqCacheByFile) done(seq uint64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if _, ok := this.taken[seq]; !ok {
+		return
+	}
+	delete(this.taken, seq)
+	delete(this.entries, seq)
+	if this.status == 1 {
+		return
+	}
+	if err := this.append(&journalEntry{Op: journalOpDone, Seq: seq}); err != nil {
+		logger.Printf("Failed to write the request journal '%s' : %s\n", this.path, err)
+	}
+}
+
+func (this *reqCacheByFile) capacity() int {
+	return this.inner.capacity()
+}
+
+func (this *reqCacheByFile) length() int {
+	return this.inner.length()
+}
+
+func (this *reqCacheByFile) close() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.status == 1 {
+		return
+	}
+	this.status = 1
+	this.inner.close()
+	this.writer.Flush()
+	this.file.Close()
+}
+
+func (this *reqCacheByFile) summary() string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return fmt.Sprintf("status:%s ,length:%d,capacity:%d,inflight:%d,journal:%s", statusMap[this.status], this.inner.length(), this.inner.capacity(), len(this.taken), this.path)
+}
+
+//已请求URL的集合，元素为请求指纹
+//去重由去重存储完成，设置了文件时每个新指纹会追加一行到文件，重新打开时回放到去重存储中
+type urlSet struct {
+	store  middleware.DedupStore
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+	mutex  sync.Mutex
+}
+
+func newUrlSet(store middleware.DedupStore) *urlSet {
+	return &urlSet{store: store}
+}
+
+func newFileUrlSet(path string, store middleware.DedupStore) (*urlSet, error) {
+	set := &urlSet{store: store, path: path}
+	if file, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				store.Add(line)
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	set.file = file
+	set.writer = bufio.NewWriter(file)
+	return set, nil
+}
+
+//添加指纹，已存在时返回false
+func (this *urlSet) add(key string) bool {
+	if !this.store.Add(key) {
+		return false
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.file != nil {
+		this.writer.WriteString(key)
+		this.writer.WriteByte('\n')
+		if err := this.writer.Flush(); err != nil {
+			logger.Printf("Failed to write the url set '%s' : %s\n", this.path, err)
+		}
+	}
+	return true
+}
+
+func (this *urlSet) contains(key string) bool {
+	return this.store.Contains(key)
+}
+
+func (this *urlSet) length() uint64 {
+	return this.store.Count()
+}
+
+func (this *urlSet) summary() string {
+	if this.path == "" {
+		return this.store.Summary()
+	}
+	return fmt.Sprintf("%s,file:%s", this.store.Summary(), this.path)
+}
+
+func (this *urlSet) close() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.file == nil {
+		return
+	}
+	this.writer.Flush()
+	this.file.Close()
+	this.file = nil
+}
+
+//打开请求缓存和已请求URL集合
+//dataDir 为空时使用内存实现，否则从该目录恢复上次的状态
+func openStore(dataDir string, strategy FrontierStrategy, score ScoreRequest, store middleware.DedupStore) (requestCache, *urlSet, error) {
+	if dataDir == "" {
+		return newRequestCacheWithStrategy(strategy, score), newUrlSet(store), nil
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, nil, err
+	}
+	reqCache, err := newFileRequestCache(filepath.Join(dataDir, frontierFileName), newRequestCacheWithStrategy(strategy, score))
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("Occur error when open request cache:%s\n", err))
+	}
+	urls, err := newFileUrlSet(filepath.Join(dataDir, seenFileName), store)
+	if err != nil {
+		reqCache.close()
+		return nil, nil, errors.New(fmt.Sprintf("Occur error when open url set:%s\n", err))
+	}
+	return reqCache, urls, nil
+}
@@ -0,0 +1,332 @@
+package scheduler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tempJournal(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, frontierFileName), func() { os.RemoveAll(dir) }
+}
+
+func newTestRequest(t *testing.T, rawUrl string) *base.Request {
+	httpReq, err := http.NewRequest(http.MethodGet, rawUrl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base.NewRequest(httpReq, 0)
+}
+
+//按顺序取出缓存中的所有请求的URL
+func drainUrls(rc requestCache) []string {
+	var urls []string
+	for req := rc.get(nil); req != nil; req = rc.get(nil) {
+		urls = append(urls, req.HttpReq().URL.String())
+	}
+	return urls
+}
+
+//读取日志中的所有记录
+func readJournal(t *testing.T, path string) []journalEntry {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var entries []journalEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("broken journal entry %q: %s", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestReqCacheByFileReplay(t *testing.T) {
+	tests := []struct {
+		name  string
+		puts  []string
+		takes int   //依次取出的请求数
+		done  []int //标记完成的已取出请求的下标
+		want  []string
+	}{
+		{
+			name: "nothing taken",
+			puts: []string{"http://a/", "http://b/", "http://c/"},
+			want: []string{"http://a/", "http://b/", "http://c/"},
+		},
+		{
+			name:  "taken and done",
+			puts:  []string{"http://a/", "http://b/", "http://c/"},
+			takes: 1,
+			done:  []int{0},
+			want:  []string{"http://b/", "http://c/"},
+		},
+		{
+			name:  "taken but not done",
+			puts:  []string{"http://a/", "http://b/", "http://c/"},
+			takes: 2,
+			done:  []int{1},
+			want:  []string{"http://a/", "http://c/"},
+		},
+		{
+			name:  "all done",
+			puts:  []string{"http://a/", "http://b/"},
+			takes: 2,
+			done:  []int{1, 0},
+			want:  nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, cleanup := tempJournal(t)
+			defer cleanup()
+			rc, err := newFileRequestCache(path, newRequestCache())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rawUrl := range test.puts {
+				if !rc.put(newTestRequest(t, rawUrl)) {
+					t.Fatalf("put(%s) = false", rawUrl)
+				}
+			}
+			var taken []*base.Request
+			for i := 0; i < test.takes; i++ {
+				taken = append(taken, rc.get(nil))
+			}
+			for _, i := range test.done {
+				rc.done(taken[i].Seq())
+			}
+			rc.close()
+
+			reopened, err := newFileRequestCache(path, newRequestCache())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.close()
+			if got := drainUrls(reopened); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("replayed %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReqCacheByFileReplayJournal(t *testing.T) {
+	tests := []struct {
+		name    string
+		journal string
+		want    []string
+		wantSeq uint64 //下一个放入的请求的序号
+	}{
+		{
+			name:    "empty",
+			journal: "",
+			want:    nil,
+			wantSeq: 1,
+		},
+		{
+			name: "done removes the put",
+			journal: `{"op":"put","seq":1,"req":{"method":"GET","url":"http://a/","depth":0}}
+{"op":"put","seq":2,"req":{"method":"GET","url":"http://b/","depth":1}}
+{"op":"done","seq":1}
+`,
+			want:    []string{"http://b/"},
+			wantSeq: 3,
+		},
+		{
+			name: "truncated last line",
+			journal: `{"op":"put","seq":1,"req":{"method":"GET","url":"http://a/","depth":0}}
+{"op":"put","seq":2,"req":{"meth`,
+			want:    []string{"http://a/"},
+			wantSeq: 2,
+		},
+		{
+			name: "replayed in sequence order",
+			journal: `{"op":"put","seq":7,"req":{"method":"GET","url":"http://c/","depth":0}}
+{"op":"put","seq":3,"req":{"method":"GET","url":"http://a/","depth":0}}
+{"op":"put","seq":5,"req":{"method":"GET","url":"http://b/","depth":0}}
+`,
+			want:    []string{"http://a/", "http://b/", "http://c/"},
+			wantSeq: 8,
+		},
+		{
+			name: "done of an unknown request",
+			journal: `{"op":"done","seq":9}
+{"op":"put","seq":2,"req":{"method":"GET","url":"http://a/","depth":0}}
+`,
+			want:    []string{"http://a/"},
+			wantSeq: 10,
+		},
+		{
+			name: "invalid request is dropped",
+			journal: `{"op":"put","seq":1,"req":{"method":"GET","url":"://bad","depth":0}}
+{"op":"put","seq":2,"req":{"method":"GET","url":"http://a/","depth":0}}
+`,
+			want:    []string{"http://a/"},
+			wantSeq: 3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, cleanup := tempJournal(t)
+			defer cleanup()
+			if err := ioutil.WriteFile(path, []byte(test.journal), 0644); err != nil {
+				t.Fatal(err)
+			}
+			rc, err := newFileRequestCache(path, newRequestCache())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.close()
+			if got := drainUrls(rc); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("replayed %v, want %v", got, test.want)
+			}
+			req := newTestRequest(t, "http://next/")
+			rc.put(req)
+			if req.Seq() != test.wantSeq {
+				t.Fatalf("next seq %d, want %d", req.Seq(), test.wantSeq)
+			}
+		})
+	}
+}
+
+func TestReqCacheByFileCompaction(t *testing.T) {
+	tests := []struct {
+		name       string
+		cycles     int //放入、取出并完成的请求数
+		live       int //之后放入而未完成的请求数
+		maxRecords int //日志中最多的记录数
+	}{
+		{name: "below the threshold", cycles: 10, live: 2, maxRecords: 2*10 + 2},
+		{name: "compacted while running", cycles: 600, live: 3, maxRecords: journalCompactFactor*3 + 1024},
+		{name: "many live requests", cycles: 1000, live: 300, maxRecords: journalCompactFactor*300 + 1024},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, cleanup := tempJournal(t)
+			defer cleanup()
+			rc, err := newFileRequestCache(path, newRequestCache())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < test.cycles; i++ {
+				rc.put(newTestRequest(t, "http://done/"))
+				rc.done(rc.get(nil).Seq())
+			}
+			var want []string
+			for i := 0; i < test.live; i++ {
+				rawUrl := fmt.Sprintf("http://live/%d", i)
+				rc.put(newTestRequest(t, rawUrl))
+				want = append(want, rawUrl)
+			}
+			rc.close()
+			if records := len(readJournal(t, path)); records > test.maxRecords {
+				t.Fatalf("%d records in the journal, want <= %d", records, test.maxRecords)
+			}
+
+			//重新打开时只保留存活的请求
+			reopened, err := newFileRequestCache(path, newRequestCache())
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries := readJournal(t, path)
+			if len(entries) != test.live {
+				t.Fatalf("%d records after reopening, want %d", len(entries), test.live)
+			}
+			for _, entry := range entries {
+				if entry.Op != journalOpPut {
+					t.Fatalf("%q record after reopening, want only put", entry.Op)
+				}
+			}
+			if got := drainUrls(reopened); !reflect.DeepEqual(got, want) {
+				t.Fatalf("replayed %v, want %v", got, want)
+			}
+			reopened.close()
+		})
+	}
+}
+
+func TestRequestRecordRoundTrip(t *testing.T) {
+	tests := []struct {
+		method   string
+		rawUrl   string
+		body     string
+		priority int
+		attempt  uint32
+		meta     map[string]interface{}
+		callback string
+	}{
+		{method: http.MethodGet, rawUrl: "http://a/?q=1"},
+		{method: http.MethodPost, rawUrl: "http://a/form", body: "k=v", priority: 5, attempt: 2},
+		{method: http.MethodGet, rawUrl: "http://a/feed", meta: map[string]interface{}{"feed": "http://a/rss"}, callback: "detail"},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.rawUrl, func(t *testing.T) {
+			path, cleanup := tempJournal(t)
+			defer cleanup()
+			rc, err := newFileRequestCache(path, newRequestCache())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var body io.Reader
+			if test.body != "" {
+				body = strings.NewReader(test.body)
+			}
+			httpReq, err := http.NewRequest(test.method, test.rawUrl, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := base.NewRequestWithPriority(httpReq, 3, test.priority)
+			req.SetAttempt(test.attempt)
+			if test.meta != nil {
+				req.SetMetaMap(test.meta)
+			}
+			if test.callback != "" {
+				req.SetCallback(test.callback)
+			}
+			rc.put(req)
+			rc.close()
+
+			reopened, err := newFileRequestCache(path, newRequestCache())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.close()
+			got := reopened.get(nil)
+			if got == nil {
+				t.Fatal("the request is not replayed")
+			}
+			gotBody, err := got.BodyBytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotReq := got.HttpReq()
+			if gotReq.Method != test.method || gotReq.URL.String() != test.rawUrl || string(gotBody) != test.body {
+				t.Fatalf("replayed %s %s %q, want %s %s %q", gotReq.Method, gotReq.URL, gotBody, test.method, test.rawUrl, test.body)
+			}
+			if got.Depth() != 3 || got.Priority() != test.priority || got.Attempt() != test.attempt || got.Callback() != test.callback {
+				t.Fatalf("replayed depth=%d priority=%d attempt=%d callback=%q", got.Depth(), got.Priority(), got.Attempt(), got.Callback())
+			}
+			if test.meta != nil && !reflect.DeepEqual(got.MetaMap(), test.meta) {
+				t.Fatalf("replayed meta %v, want %v", got.MetaMap(), test.meta)
+			}
+		})
+	}
+}
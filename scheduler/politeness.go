@@ -0,0 +1,148 @@
+package scheduler
+
+import (
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"net"
+	"sync"
+	"time"
+)
+
+//主机（或IP）的访问状态
+type hostSlot struct {
+	active uint32    //正在进行的请求数
+	next   time.Time //允许下一次请求的时间
+}
+
+//礼貌爬取控制器
+//在请求从请求缓存中取出之前，限制每个主机和每个IP的并发数以及同一主机两次请求的间隔
+type politeness struct {
+	args   base.PolitenessArgs
+	hosts  map[string]*hostSlot
+	ips    map[string]*hostSlot
+	hostIp map[string]string //主机解析得到的IP
+	delays map[string]time.Duration
+	mutex  sync.Mutex
+}
+
+func newPoliteness(args base.PolitenessArgs) *politeness {
+	return &politeness{
+		args:   args,
+		hosts:  make(map[string]*hostSlot),
+		ips:    make(map[string]*hostSlot),
+		hostIp: make(map[string]string),
+		delays: make(map[string]time.Duration),
+	}
+}
+
+//设置某个主机的最小请求间隔，如 robots.txt 中的 Crawl-delay
+//仅在大于配置的间隔时生效
+func (this *politeness) setHostDelay(host string, delay time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.delays[host] = delay
+}
+
+//获取主机的IP，尚未解析时在后台解析并返回false，解析失败时以主机名代替
+func (this *politeness) lookup(host string) (string, bool) {
+	this.mutex.Lock()
+	ip, ok := this.hostIp[host]
+	if !ok {
+		//空字符串表示正在解析
+		this.hostIp[host] = ""
+		go func() {
+			ip := host
+			if addrs, err := net.LookupIP(host); err == nil && len(addrs) > 0 {
+				ip = addrs[0].String()
+			}
+			this.mutex.Lock()
+			this.hostIp[host] = ip
+			this.mutex.Unlock()
+		}()
+	}
+	this.mutex.Unlock()
+	return ip, ip != ""
+}
+
+func (this *politeness) slot(slots map[string]*hostSlot, key string) *hostSlot {
+	s, ok := slots[key]
+	if !ok {
+		s = &hostSlot{}
+		slots[key] = s
+	}
+	return s
+}
+
+//尝试获取访问主机的许可，许可不可用时立即返回false，不会阻塞
+//调度器在从请求缓存取出请求时调用，主机繁忙的请求留在请求缓存中
+func (this *politeness) tryAcquire(host string) bool {
+	hp := this.args.HostPoliteness(host)
+	var ip string
+	if this.args.IpConcurrency() > 0 {
+		var ok bool
+		if ip, ok = this.lookup(host); !ok {
+			return false
+		}
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	now := time.Now()
+	hs := this.slot(this.hosts, host)
+	if (hp.Concurrency != 0 && hs.active >= hp.Concurrency) || now.Before(hs.next) {
+		return false
+	}
+	var is *hostSlot
+	if ip != "" {
+		is = this.slot(this.ips, ip)
+		if is.active >= this.args.IpConcurrency() {
+			return false
+		}
+	}
+	delay := hp.Delay
+	if d, ok := this.delays[host]; ok && d > delay {
+		delay = d
+	}
+	hs.active++
+	hs.next = now.Add(delay)
+	if is != nil {
+		is.active++
+	}
+	return true
+}
+
+//获取访问主机的许可，在许可可用之前阻塞
+//只用于不经过请求缓存的下载，如 robots.txt 和站点地图
+//stopped 返回true时放弃等待并返回false
+func (this *politeness) acquire(host string, stopped func() bool) bool {
+	for !this.tryAcquire(host) {
+		if stopped() {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+//归还访问主机的许可
+func (this *politeness) release(host string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if hs, ok := this.hosts[host]; ok && hs.active > 0 {
+		hs.active--
+	}
+	if ip := this.hostIp[host]; ip != "" && this.args.IpConcurrency() > 0 {
+		if is, ok := this.ips[ip]; ok && is.active > 0 {
+			is.active--
+		}
+	}
+}
+
+func (this *politeness) summary() string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	var active uint32
+	for _, hs := range this.hosts {
+		active += hs.active
+	}
+	return fmt.Sprintf("hosts:%d,ips:%d,active:%d", len(this.hosts), len(this.ips), active)
+}
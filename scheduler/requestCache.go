@@ -6,9 +6,20 @@ import (
 	"sync"
 )
 
+//取出请求时最多检查的请求数，见 requestCache.get
+const readyScanLimit = 64
+
+//判断请求是否可以取出，返回true时请求被取出
+type readyRequest func(req *base.Request) bool
+
 type requestCache interface {
 	put(req *base.Request) bool
-	get() *base.Request
+	//按顺序取出第一个 ready 返回true的请求，最多检查前 readyScanLimit 个请求，其余请求保持原来的顺序
+	//ready 为nil时取出第一个请求，没有可取出的请求时返回nil
+	get(ready readyRequest) *base.Request
+	//标记请求已处理完成（响应已分析、子请求已放入缓存），持久化的缓存据此确定可以丢弃的请求
+	//seq 为请求的序号，见 base.Request.Seq，为0时忽略
+	done(seq uint64)
 	capacity() int
 	length() int
 	close()
@@ -34,7 +45,7 @@ func (this *reqCacheBySlice) put(req *base.Request) bool {
 	return true
 }
 
-func (this *reqCacheBySlice) get() *base.Request {
+func (this *reqCacheBySlice) get(ready readyRequest) *base.Request {
 	if this.length() == 0 {
 		return nil
 	}
@@ -44,12 +55,25 @@ func (this *reqCacheBySlice) get() *base.Request {
 
 	this.mutex.Lock()
 	defer this.mutex.Unlock()
-	req := this.cache[0]
-	this.cache = this.cache[1:]
-	return req
-
+	for i, req := range this.cache {
+		if i >= readyScanLimit {
+			break
+		}
+		if ready != nil && !ready(req) {
+			continue
+		}
+		if i == 0 {
+			this.cache = this.cache[1:]
+		} else {
+			this.cache = append(this.cache[:i:i], this.cache[i+1:]...)
+		}
+		return req
+	}
+	return nil
 }
 
+func (this *reqCacheBySlice) done(seq uint64) {}
+
 func (this *reqCacheBySlice) capacity() int {
 	return cap(this.cache)
 }
@@ -0,0 +1,147 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+//重试计数
+type retryCounts struct {
+	pending uint64 //等待重试的请求数
+	retried uint64 //已重试的次数
+	gaveUp  uint64 //达到最大尝试次数后放弃的请求数
+}
+
+func (this *retryCounts) summary() string {
+	return fmt.Sprintf("pending:%d,retried:%d,gaveUp:%d",
+		atomic.LoadUint64(&this.pending), atomic.LoadUint64(&this.retried), atomic.LoadUint64(&this.gaveUp))
+}
+
+//判断下载错误是否为可重试的网络错误
+func retryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+//计算第 attempt 次重试前的等待时间
+//按指数增长并加入随机抖动，结果在 [delay/2, delay] 之间
+func backoff(args base.RetryArgs, attempt uint32) time.Duration {
+	delay := args.BaseDelay()
+	for i := uint32(0); i < attempt && delay < args.MaxDelay(); i++ {
+		delay *= 2
+	}
+	if delay > args.MaxDelay() {
+		delay = args.MaxDelay()
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+//解析 Retry-After 响应头，支持秒数和HTTP日期两种格式
+func retryAfter(httpResp *http.Response) time.Duration {
+	if httpResp == nil {
+		return 0
+	}
+	value := httpResp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+//判断下载结果是否需要重试，需要时返回等待时间
+func (this *myScheduler) shouldRetry(req base.Request, resp *base.Response, err error) (time.Duration, bool) {
+	if this.retryArgs.MaxAttempts() <= 1 {
+		return 0, false
+	}
+	var httpResp *http.Response
+	if resp != nil {
+		httpResp = resp.HttpReq()
+	}
+	if err != nil {
+		if !retryableError(err) {
+			return 0, false
+		}
+	} else if httpResp == nil || !this.retryArgs.RetryStatus(httpResp.StatusCode) {
+		return 0, false
+	}
+	if req.Attempt()+1 >= this.retryArgs.MaxAttempts() {
+		atomic.AddUint64(&this.retryCounts.gaveUp, 1)
+		return 0, false
+	}
+	delay := backoff(this.retryArgs, req.Attempt())
+	if after := retryAfter(httpResp); after > delay {
+		delay = after
+	}
+	return delay, true
+}
+
+//复制请求的 http 请求并重建请求体
+//第一次下载已经读完了原请求体，重试时需要从 GetBody 重新获取
+func rewindBody(req *base.Request) error {
+	httpReq := req.HttpReq()
+	if httpReq.Body == nil || httpReq.Body == http.NoBody {
+		return nil
+	}
+	if httpReq.GetBody == nil {
+		return errors.New(fmt.Sprintf("The request body can not be rebuilt for retry! (requestUrl=%s)", httpReq.URL))
+	}
+	body, err := httpReq.GetBody()
+	if err != nil {
+		return err
+	}
+	clone := httpReq.Clone(httpReq.Context())
+	clone.Body = body
+	req.SetHttpReq(clone)
+	return nil
+}
+
+//等待一段时间后将请求重新放入请求缓存
+//原请求在重试请求放入后才标记为完成，以免持久化的请求缓存丢失该请求
+func (this *myScheduler) retry(req base.Request, delay time.Duration) {
+	next := req.NextAttempt()
+	atomic.AddUint64(&this.retryCounts.pending, 1)
+	logger.Printf("Retry the request after %s (attempt=%d, requestUrl='%s')\n", delay, next.Attempt(), req.HttpReq().URL)
+	time.AfterFunc(delay, func() {
+		defer atomic.AddUint64(&this.retryCounts.pending, ^uint64(0))
+		if this.stopSign.Signed() {
+			this.stopSign.Deal(SCHEDULER_CODE)
+			return
+		}
+		if err := rewindBody(next); err != nil {
+			atomic.AddUint64(&this.retryCounts.gaveUp, 1)
+			this.SendError(err, SCHEDULER_CODE)
+			this.reqCache.done(req.Seq())
+			return
+		}
+		if this.reqCache.put(next) {
+			atomic.AddUint64(&this.retryCounts.retried, 1)
+			this.reqCache.done(req.Seq())
+		}
+	})
+}
@@ -0,0 +1,260 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"github.com/fmyxyz/goreptile/robots"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	robotsTTL      = 24 * time.Hour   //robots.txt 的缓存时间
+	robotsRetryTTL = 10 * time.Minute //robots.txt 暂时无法获取时的缓存时间
+	robotsMaxSize  = 512 * 1024       //robots.txt 的最大长度
+)
+
+type robotsEntry struct {
+	robots  *robots.Robots
+	expires time.Time
+	ready   chan struct{} //获取完成后关闭
+	waiters []func()      //获取完成后调用，见 getAsync
+}
+
+//获取 robots.txt 的函数，返回状态码和内容
+type fetchRobots func(robotsUrl *url.URL) (int, []byte, error)
+
+//按主机缓存的 robots.txt
+type robotsCache struct {
+	args       base.RobotsArgs
+	fetch      fetchRobots
+	entries    map[string]*robotsEntry //键为 scheme://host
+	disallowed uint64                  //被禁止的请求数
+	mutex      sync.Mutex
+}
+
+func newRobotsCache(args base.RobotsArgs, fetch fetchRobots) *robotsCache {
+	return &robotsCache{
+		args:    args,
+		fetch:   fetch,
+		entries: make(map[string]*robotsEntry),
+	}
+}
+
+//获取URL所在主机的 robots.txt，同一主机同时只会获取一次
+func (this *robotsCache) get(u *url.URL) *robots.Robots {
+	key := u.Scheme + "://" + u.Host
+	this.mutex.Lock()
+	entry, ok := this.entries[key]
+	if ok {
+		this.mutex.Unlock()
+		<-entry.ready
+		if time.Now().Before(entry.expires) {
+			return entry.robots
+		}
+		this.mutex.Lock()
+		if this.entries[key] == entry {
+			delete(this.entries, key)
+		}
+		this.mutex.Unlock()
+		return this.get(u)
+	}
+	entry = &robotsEntry{ready: make(chan struct{})}
+	this.entries[key] = entry
+	this.mutex.Unlock()
+	this.fill(entry, u)
+	return entry.robots
+}
+
+//不等待地获取URL所在主机的 robots.txt
+//尚未获取或已过期时在后台获取并返回nil，获取完成后调用 then（可以为nil）
+func (this *robotsCache) getAsync(u *url.URL, then func()) *robots.Robots {
+	key := u.Scheme + "://" + u.Host
+	this.mutex.Lock()
+	entry, ok := this.entries[key]
+	if ok {
+		select {
+		case <-entry.ready:
+			if time.Now().Before(entry.expires) {
+				this.mutex.Unlock()
+				return entry.robots
+			}
+		default:
+			if then != nil {
+				entry.waiters = append(entry.waiters, then)
+			}
+			this.mutex.Unlock()
+			return nil
+		}
+	}
+	entry = &robotsEntry{ready: make(chan struct{})}
+	if then != nil {
+		entry.waiters = append(entry.waiters, then)
+	}
+	this.entries[key] = entry
+	this.mutex.Unlock()
+	go this.fill(entry, u)
+	return nil
+}
+
+//获取 robots.txt 并填充缓存项，完成后依次调用等待的函数
+func (this *robotsCache) fill(entry *robotsEntry, u *url.URL) {
+	robotsUrl := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+	statusCode, body, err := this.fetch(robotsUrl)
+	if err != nil {
+		logger.Printf("Failed to fetch '%s' : %s\n", robotsUrl, err)
+		entry.robots = robots.DenyAll()
+		entry.expires = time.Now().Add(robotsRetryTTL)
+	} else {
+		entry.robots = robots.FromStatus(statusCode, body)
+		if statusCode >= 500 {
+			entry.expires = time.Now().Add(robotsRetryTTL)
+		} else {
+			entry.expires = time.Now().Add(robotsTTL)
+		}
+	}
+	this.mutex.Lock()
+	close(entry.ready)
+	waiters := entry.waiters
+	entry.waiters = nil
+	this.mutex.Unlock()
+	for _, then := range waiters {
+		then()
+	}
+}
+
+//判断是否允许请求该URL
+func (this *robotsCache) allowed(u *url.URL) bool {
+	if !this.args.ObeyHost(u.Hostname()) {
+		return true
+	}
+	return this.check(this.get(u), u)
+}
+
+//不等待地判断是否允许请求该URL，robots.txt 尚未获取时 ready 为false，获取完成后调用 then
+func (this *robotsCache) allowedAsync(u *url.URL, then func()) (allowed bool, ready bool) {
+	if !this.args.ObeyHost(u.Hostname()) {
+		return true, true
+	}
+	r := this.getAsync(u, then)
+	if r == nil {
+		return false, false
+	}
+	return this.check(r, u), true
+}
+
+func (this *robotsCache) check(r *robots.Robots, u *url.URL) bool {
+	if r.Allowed(this.args.UserAgent(), u) {
+		return true
+	}
+	atomic.AddUint64(&this.disallowed, 1)
+	return false
+}
+
+//获取URL所在主机的 robots.txt 中声明的站点地图
+func (this *robotsCache) sitemaps(u *url.URL) []string {
+	return this.get(u).Sitemaps()
+}
+
+//获取URL所在主机的抓取间隔
+func (this *robotsCache) crawlDelay(u *url.URL) time.Duration {
+	if !this.args.ObeyHost(u.Hostname()) {
+		return 0
+	}
+	//只在 robots.txt 已获取时生效，不等待获取
+	r := this.getAsync(u, nil)
+	if r == nil {
+		return 0
+	}
+	return r.CrawlDelay(this.args.UserAgent())
+}
+
+func (this *robotsCache) summary() string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	var sitemaps int
+	for _, entry := range this.entries {
+		select {
+		case <-entry.ready:
+			sitemaps += len(entry.robots.Sitemaps())
+		default:
+		}
+	}
+	return fmt.Sprintf("obey:%v,hosts:%d,sitemaps:%d,disallowed:%d", this.args.Obey(), len(this.entries), sitemaps, atomic.LoadUint64(&this.disallowed))
+}
+
+//通过网页下载器池获取 robots.txt
+func (this *myScheduler) fetchRobots(robotsUrl *url.URL) (int, []byte, error) {
+	httpReq, err := http.NewRequest("GET", robotsUrl.String(), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if ua := this.robotsArgs.UserAgent(); ua != "" {
+		httpReq.Header.Set("User-Agent", ua)
+	}
+	return this.fetchDirect(httpReq, robotsMaxSize)
+}
+
+//通过网页下载器池直接下载，不经过请求缓存和解析函数，返回状态码和最多 maxSize 字节的内容
+func (this *myScheduler) fetchDirect(httpReq *http.Request, maxSize int64) (int, []byte, error) {
+	host := httpReq.URL.Hostname()
+	if !this.politeness.acquire(host, this.stopSign.Signed) {
+		return 0, nil, errors.New("The scheduler has been stopped!")
+	}
+	defer this.politeness.release(host)
+
+	downloader, err := this.dlpool.Take()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer this.dlpool.Return(downloader)
+	resp, err := downloader.Download(*base.NewRequest(httpReq, 0))
+	if err != nil {
+		return 0, nil, err
+	}
+	httpResp := resp.HttpReq()
+	defer httpResp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(httpResp.Body, maxSize))
+	if err != nil {
+		return 0, nil, err
+	}
+	return httpResp.StatusCode, body, nil
+}
+
+//判断请求是否被 robots.txt 允许，并应用其中的抓取间隔
+//主机的 robots.txt 尚未获取时等待获取完成，只用于不影响下载和分析的 goroutine
+func (this *myScheduler) robotsAllowed(reqUrl *url.URL) bool {
+	if !this.robotsArgs.Obey() {
+		return true
+	}
+	if !this.robots.allowed(reqUrl) {
+		return false
+	}
+	this.applyCrawlDelay(reqUrl)
+	return true
+}
+
+//与 robotsAllowed 相同，但不等待：主机的 robots.txt 尚未获取时在后台获取，返回的 ready 为false，
+//获取完成后调用 then
+func (this *myScheduler) robotsAllowedAsync(reqUrl *url.URL, then func()) (allowed bool, ready bool) {
+	if !this.robotsArgs.Obey() {
+		return true, true
+	}
+	allowed, ready = this.robots.allowedAsync(reqUrl, then)
+	if allowed {
+		this.applyCrawlDelay(reqUrl)
+	}
+	return allowed, ready
+}
+
+func (this *myScheduler) applyCrawlDelay(reqUrl *url.URL) {
+	if delay := this.robots.crawlDelay(reqUrl); delay > 0 {
+		this.politeness.setHostDelay(reqUrl.Hostname(), delay)
+	}
+}
@@ -1,9 +1,9 @@
 package scheduler
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/fmyxyz/goreptile/base"
+	"sync/atomic"
 )
 
 type SchedSummary interface {
@@ -15,23 +15,37 @@ type SchedSummary interface {
 type mySchedSummary struct {
 	prefix  string
 	running uint32
+	paused  uint32
 
-	channelArgs  base.ChannelArgs
-	poolBaseArgs base.PoolBaseArgs
+	channelArgs    base.ChannelArgs
+	poolBaseArgs   base.PoolBaseArgs
+	politenessArgs base.PolitenessArgs
+	retryArgs      base.RetryArgs
 
-	crawlDepth uint32
+	crawlDepth  uint32
+	tooDeep     uint64
+	maxBodySize int64
 
 	chanmanSummary      string
 	reqCacheSummary     string
 	itemPipelineSummary string
 	stopSignSummary     string
+	politenessSummary   string
+	robotsSummary       string
+	retrySummary        string
+	proxySummary        string
+	feedSummary         string
+	sitemapSummary      string
+	seedSummary         string
+	scopePolicy         string
+	scopeSummary        string
 
 	dlPoolLen       uint32
 	dlPoolCap       uint32
 	analyzerPoolLen uint32
 	analyzerPoolCap uint32
 
-	urlCount  int
+	urlCount  uint64
 	urlDetail string
 }
 
@@ -54,6 +68,7 @@ func (this *mySchedSummary) Same(other SchedSummary) bool {
 	}
 
 	if this.running != otherSs.running ||
+		this.paused != otherSs.paused ||
 		this.poolBaseArgs.AnalyzerPoolSize() != otherSs.poolBaseArgs.AnalyzerPoolSize() ||
 		this.poolBaseArgs.PageDownloaderPoolSize() != otherSs.poolBaseArgs.PageDownloaderPoolSize() ||
 		this.channelArgs.ErrorChanLen() != otherSs.channelArgs.ErrorChanLen() ||
@@ -61,10 +76,20 @@ func (this *mySchedSummary) Same(other SchedSummary) bool {
 		this.channelArgs.RespChanLen() != otherSs.channelArgs.RespChanLen() ||
 		this.channelArgs.ReqChanLen() != otherSs.channelArgs.ReqChanLen() ||
 		this.crawlDepth != otherSs.crawlDepth ||
+		this.tooDeep != otherSs.tooDeep ||
+		this.maxBodySize != otherSs.maxBodySize ||
 		this.chanmanSummary != otherSs.chanmanSummary ||
 		this.reqCacheSummary != otherSs.reqCacheSummary ||
 		this.itemPipelineSummary != otherSs.itemPipelineSummary ||
 		this.stopSignSummary != otherSs.stopSignSummary ||
+		this.politenessSummary != otherSs.politenessSummary ||
+		this.robotsSummary != otherSs.robotsSummary ||
+		this.retrySummary != otherSs.retrySummary ||
+		this.proxySummary != otherSs.proxySummary ||
+		this.feedSummary != otherSs.feedSummary ||
+		this.sitemapSummary != otherSs.sitemapSummary ||
+		this.seedSummary != otherSs.seedSummary ||
+		this.scopeSummary != otherSs.scopeSummary ||
 		this.dlPoolLen != otherSs.dlPoolLen ||
 		this.dlPoolCap != otherSs.dlPoolCap ||
 		this.analyzerPoolLen != otherSs.analyzerPoolLen ||
@@ -79,28 +104,17 @@ func (this *mySchedSummary) Same(other SchedSummary) bool {
 
 func NewSchedSummary(sched *myScheduler, prefix string) SchedSummary {
 
-	urlCount := len(sched.urlMap)
-
-	var urlDetail string
-	if urlCount > 0 {
-		var buffer bytes.Buffer
-		buffer.WriteByte('\n')
-		for k, _ := range sched.urlMap {
-			buffer.WriteString(prefix)
-			buffer.WriteString(prefix)
-			buffer.WriteString(k)
-			buffer.WriteByte('\n')
-		}
-		urlDetail = buffer.String()
-	} else {
-		urlDetail = "\n"
-	}
 	return &mySchedSummary{
 		prefix:              prefix,
 		running:             sched.running,
+		paused:              sched.paused,
 		poolBaseArgs:        sched.poolBaseArgs,
 		channelArgs:         sched.channelArgs,
+		politenessArgs:      sched.politenessArgs,
+		retryArgs:           sched.retryArgs,
 		crawlDepth:          sched.crawlDepth,
+		tooDeep:             atomic.LoadUint64(&sched.tooDeep),
+		maxBodySize:         sched.maxBodySize,
 		chanmanSummary:      sched.chanman.Summary(),
 		reqCacheSummary:     sched.reqCache.summary(),
 		dlPoolLen:           sched.dlpool.Used(),
@@ -108,33 +122,68 @@ func NewSchedSummary(sched *myScheduler, prefix string) SchedSummary {
 		analyzerPoolLen:     sched.analyzerPool.Used(),
 		analyzerPoolCap:     sched.analyzerPool.Total(),
 		itemPipelineSummary: sched.itempipeline.Summary(),
-		urlCount:            urlCount,
-		urlDetail:           urlDetail,
+		urlCount:            sched.urls.length(),
+		urlDetail:           sched.urls.summary(),
 		stopSignSummary:     sched.stopSign.Summary(),
+		politenessSummary:   sched.politeness.summary(),
+		robotsSummary:       sched.robots.summary(),
+		retrySummary:        sched.retryCounts.summary(),
+		proxySummary:        sched.proxySummary(),
+		feedSummary:         sched.feeds.summary(),
+		sitemapSummary:      sched.sitemaps.summary(),
+		seedSummary:         sched.seedCounts.summary(),
+		scopePolicy:         sched.scopePolicy.String(),
+		scopeSummary:        sched.scopeSummary(),
 	}
 }
 
 func (this *mySchedSummary) getSummary(detail bool) string {
 	var template = this.prefix + "Running :%v \n" +
+		this.prefix + "Paused :%v \n" +
 		this.prefix + "Pool base size args :%s \n" +
 		this.prefix + "Channel args :%s \n" +
-		this.prefix + "Crawl depth :%d \n" +
+		this.prefix + "Politeness args :%s \n" +
+		this.prefix + "Retry args :%s \n" +
+		this.prefix + "Crawl depth :%d,tooDeep:%d \n" +
+		this.prefix + "Max body size :%d \n" +
 		this.prefix + "Channels manager :%s \n" +
 		this.prefix + "Request cache :%s \n" +
 		this.prefix + "Downloader pool :%d/%d \n" +
 		this.prefix + "Analyzer pool :%d/%d \n" +
+		this.prefix + "Politeness :%s \n" +
+		this.prefix + "Robots :%s \n" +
+		this.prefix + "Retry :%s \n" +
+		this.prefix + "Proxy pool :%s \n" +
+		this.prefix + "Feeds :%s \n" +
+		this.prefix + "Sitemaps :%s \n" +
+		this.prefix + "Seeds :%s \n" +
+		this.prefix + "Scope :%s \n" +
+		this.prefix + "Scope decisions :%s \n" +
 		this.prefix + "Item pipeline :%s \n" +
 		this.prefix + "Url(%d) :%s \n" +
 		this.prefix + "Stop sign :%s \n"
 	return fmt.Sprintf(template,
 		func() bool { return this.running == 1 }(),
+		func() bool { return this.paused == 1 }(),
 		this.poolBaseArgs.String(),
 		this.channelArgs.String(),
-		this.crawlDepth,
+		this.politenessArgs.String(),
+		this.retryArgs.String(),
+		this.crawlDepth, this.tooDeep,
+		this.maxBodySize,
 		this.chanmanSummary,
 		this.reqCacheSummary,
 		this.dlPoolLen, this.dlPoolCap,
 		this.analyzerPoolLen, this.analyzerPoolCap,
+		this.politenessSummary,
+		this.robotsSummary,
+		this.retrySummary,
+		this.proxySummary,
+		this.feedSummary,
+		this.sitemapSummary,
+		this.seedSummary,
+		this.scopePolicy,
+		this.scopeSummary,
 		this.itemPipelineSummary,
 		this.urlCount,
 		func() string {
@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/fmyxyz/goreptile/analyzer"
@@ -8,10 +9,13 @@ import (
 	"github.com/fmyxyz/goreptile/downloader"
 	"github.com/fmyxyz/goreptile/itempipeline"
 	"github.com/fmyxyz/goreptile/middleware"
+	"github.com/fmyxyz/goreptile/scope"
+	"github.com/fmyxyz/goreptile/seed"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"strings"
 	//"sync"
 	"sync/atomic"
 	"time"
@@ -19,6 +23,8 @@ import (
 
 type Scheduler interface {
 	//启动调度器
+	//firstHttpReq 为首个请求，已通过 AddSeeds 添加种子来源时可以为nil
+	//crawlDepth 为爬取深度，深度大于该值的请求会被丢弃
 	Start(channelArgs base.ChannelArgs,
 		poolBaseArgs base.PoolBaseArgs,
 		crawlDepth uint32,
@@ -26,10 +32,61 @@ type Scheduler interface {
 		respParsers []analyzer.ParseResponse,
 		itemProcessors []itempipeline.ProcessItem,
 		firstHttpReq *http.Request) (err error)
+	//按配置设置并启动调度器，配置中的所有问题会在设置之前一并返回，见 Config.Validate
+	//config.DataDir 必须与创建调度器时的持久化目录一致；启动失败时恢复调用前的设置，可以修改配置后再次调用
+	StartWithConfig(config Config) error
 	//停止调度器，所有处理模块都会停止
 	Stop() bool
 	//调度器是否在运行
 	Running() bool
+	//暂停调度器，下载和分析不再接收新的任务，正在进行的任务会继续完成
+	//请求缓存和已请求的URL都会保留
+	//若调度器未运行或已暂停，则返回false
+	Pause() bool
+	//恢复已暂停的调度器
+	//若调度器未暂停，则返回false
+	Resume() bool
+	//调度器是否已暂停
+	Paused() bool
+	//设置请求缓存的调度策略，需在启动前设置，默认为广度优先
+	//score 仅在最佳优先策略下使用
+	SetFrontier(strategy FrontierStrategy, score ScoreRequest) error
+	//设置礼貌爬取参数，需在启动前设置，默认不做限制
+	SetPoliteness(politenessArgs base.PolitenessArgs) error
+	//设置 robots.txt 参数，需在启动前设置，默认不遵守 robots.txt
+	SetRobots(robotsArgs base.RobotsArgs) error
+	//设置爬取范围策略，需在启动前设置
+	//未设置时只允许 http 和 https 协议下与首个请求同一主域名的URL
+	SetScope(scopePolicy *scope.Policy) error
+	//设置用于去重的请求指纹生成器，需在启动前设置
+	//默认使用默认设置的URL规范化器，且不计算任何请求头
+	SetFingerprinter(fingerprinter *base.Fingerprinter) error
+	//设置去重存储的生成函数，每次启动时生成新的去重存储，需在启动前设置
+	//默认使用精确的去重存储
+	SetDedupStore(genDedupStore middleware.GenDedupStore) error
+	//设置下载重试参数，需在启动前设置，默认不重试
+	SetRetry(retryArgs base.RetryArgs) error
+	//设置下载器中间件，按给定的顺序处理请求，需在启动前设置
+	SetDownloaderMiddlewares(middlewares ...downloader.DownloaderMiddleware) error
+	//设置代理池，需在启动前设置，默认不使用代理池
+	//代理池在所有下载器中间件之后选择代理，HTTP客户端的 Transport 必须为nil或 *http.Transport
+	SetProxyPool(proxyPool *downloader.ProxyPool) error
+	//设置响应体的最大长度，需在启动前设置，默认为 downloader.DefaultMaxBodySize，为0时不限制
+	//响应体超过最大长度的网页会下载失败
+	SetMaxBodySize(maxBodySize int64) error
+	//设置订阅源轮询，需在启动前设置，feedUrls 为空时不轮询
+	//启动后每隔 interval 下载一次各订阅源（RSS 2.0、Atom 或 JSON Feed），
+	//只有未见过的条目会产生条目并把条目链接放入请求缓存，条目链接的响应交给解析函数处理
+	//设置后调度器不会空闲，需要主动停止
+	SetFeeds(interval time.Duration, feedUrls ...string) error
+	//设置站点地图参数，需在启动前设置，默认不使用站点地图
+	//启动后在后台递归地遍历站点地图（包括站点地图索引和 gzip 压缩的站点地图），
+	//按 priority 和 lastmod 把其中的URL作为种子放入请求缓存，priority 映射为请求的优先级
+	SetSitemaps(sitemapArgs base.SitemapArgs) error
+	//添加种子来源，启动前添加的种子来源在启动时开始交出种子，运行时添加的立即开始
+	//种子同样经过范围检查，种子跨多个主域名时需要通过 SetScope 设置范围
+	//从通道读取种子的种子来源在通道关闭前不会结束，期间调度器不会空闲
+	AddSeeds(sources ...seed.Source) error
 	//错误通道，调度器及各个处理模块出现的错误
 	//nil 表示通道不可用或调度器已停止
 	ErrorChan() <-chan error
@@ -43,36 +100,83 @@ type Scheduler interface {
 type GenHttpClient func() *http.Client
 
 type myScheduler struct {
-	channelArgs  base.ChannelArgs
-	poolBaseArgs base.PoolBaseArgs
-
-	crawlDepth    uint32 //深度
-	primaryDomain string //主域名
-
-	chanman      middleware.ChannelManager     //通道管理器
-	stopSign     middleware.StopSign           //停止信号
-	dlpool       downloader.PageDownloaderPool //网页下载器池
-	analyzerPool analyzer.AnalyzerPool         //分析器池
-	itempipeline itempipeline.ItemPipeline     //条目处理管道
+	channelArgs    base.ChannelArgs
+	poolBaseArgs   base.PoolBaseArgs
+	politenessArgs base.PolitenessArgs
+	robotsArgs     base.RobotsArgs
+	retryArgs      base.RetryArgs
+	sitemapArgs    base.SitemapArgs
+
+	crawlDepth    uint32        //深度
+	tooDeep       uint64        //超过爬取深度而被丢弃的请求数
+	primaryDomain string        //主域名
+	scopePolicy   *scope.Policy //爬取范围策略
+	scopeCounts   []uint64      //各范围判定结果的计数
+
+	chanman       middleware.ChannelManager     //通道管理器
+	stopSign      middleware.StopSign           //停止信号
+	dlpool        downloader.PageDownloaderPool //网页下载器池
+	analyzerPool  analyzer.AnalyzerPool         //分析器池
+	itempipeline  itempipeline.ItemPipeline     //条目处理管道
+	politeness    *politeness                   //礼貌爬取控制器
+	robots        *robotsCache                  //robots.txt 缓存
+	robotsPending int64                         //等待获取 robots.txt 的请求数
+	retryCounts   retryCounts                   //重试计数
+
+	dlMiddlewares []downloader.DownloaderMiddleware //下载器中间件
+	proxyPool     *downloader.ProxyPool             //代理池
+	maxBodySize   int64                             //响应体的最大长度
+	feeds         *feedPolling                      //订阅源轮询
+	sitemaps      *sitemapSeeding                   //站点地图的遍历状态
+	seedSources   []seed.Source                     //启动前添加的种子来源
+	seedCounts    seedCounts                        //种子计数
 
 	running uint32 //运行标记 0未运行 1已运行 2已停止
+	paused  uint32 //暂停标记 0未暂停 1已暂停
 
-	reqCache requestCache //请求缓存
+	dataDir          string           //持久化目录，为空时不持久化
+	frontierStrategy FrontierStrategy //请求缓存的调度策略
+	scoreRequest     ScoreRequest     //请求评分函数
+	reqCache         requestCache     //请求缓存
 
-	urlMap map[string]bool //已请求的URL
+	fingerprinter *base.Fingerprinter      //请求指纹生成器
+	genDedupStore middleware.GenDedupStore //去重存储的生成函数
+	urls          *urlSet                  //已请求的URL的指纹
 
 	//wg sync.WaitGroup
 }
 
 func NewScheduler() Scheduler {
 	return &myScheduler{
-		reqCache: newRequestCache(),
-		urlMap:   make(map[string]bool),
+		reqCache:      newRequestCache(),
+		urls:          newUrlSet(middleware.NewExactDedupStore()),
+		fingerprinter: base.NewFingerprinter(nil),
+		genDedupStore: middleware.NewExactDedupStore,
+		maxBodySize:   downloader.DefaultMaxBodySize,
+	}
+}
+
+//创建可恢复的调度器
+//请求缓存和已请求的URL会持久化到 dataDir 目录，
+//使用同一目录再次启动时会从上次停止（或崩溃）时的状态继续爬取
+func NewPersistentScheduler(dataDir string) Scheduler {
+	return &myScheduler{
+		dataDir:       dataDir,
+		reqCache:      newRequestCache(),
+		urls:          newUrlSet(middleware.NewExactDedupStore()),
+		fingerprinter: base.NewFingerprinter(nil),
+		genDedupStore: middleware.NewExactDedupStore,
+		maxBodySize:   downloader.DefaultMaxBodySize,
 	}
 }
 
 var logger = log.New(os.Stdout, "scheduler:", log.LstdFlags)
 
+//设置日志的输出位置，默认为标准输出
+func SetLogOutput(w io.Writer) {
+	logger.SetOutput(w)
+}
+
 func (this *myScheduler) Start(channelArgs base.ChannelArgs,
 	poolBaseArgs base.PoolBaseArgs,
 	crawlDepth uint32,
@@ -87,11 +191,20 @@ func (this *myScheduler) Start(channelArgs base.ChannelArgs,
 			err = errors.New(errMsg)
 		}
 	}()
-	if atomic.LoadUint32(&this.running) == 1 {
+	prevRunning := atomic.LoadUint32(&this.running)
+	if prevRunning == 1 {
 		return errors.New("The Scheduler has bean started!\n")
 	}
 	atomic.StoreUint32(&this.running, 1)
+	atomic.StoreUint32(&this.paused, 0)
+	//启动失败时恢复运行标记，之后仍可以修改设置并重新启动
+	defer func() {
+		if err != nil {
+			atomic.StoreUint32(&this.running, prevRunning)
+		}
+	}()
 
+	//所有的检查和初始化都在启动任何 goroutine 之前完成
 	if err := channelArgs.Check(); err != nil {
 		return err
 	}
@@ -101,13 +214,51 @@ func (this *myScheduler) Start(channelArgs base.ChannelArgs,
 	}
 	this.poolBaseArgs = poolBaseArgs
 	this.crawlDepth = crawlDepth
-
-	this.chanman = generateChannelManager(this.channelArgs)
-
+	//有种子来源时可以不指定首个请求，但需要设置范围
+	if firstHttpReq == nil && len(this.seedSources) == 0 {
+		return errors.New("The frist http request is invalid!")
+	}
+	if firstHttpReq == nil && this.scopePolicy == nil {
+		return errors.New("The scope policy must be set when starting without the first http request!")
+	}
 	if httpClientGenerator == nil {
 		return errors.New("The http client generator list is ivalid!")
 	}
-	dlpool, err := generatePageDownloadPool(this.poolBaseArgs.PageDownloaderPoolSize(), httpClientGenerator)
+	if itemProcessors == nil {
+		return errors.New("The item processor list is invalid!")
+	}
+	for i, ip := range itemProcessors {
+		if ip == nil {
+			return errors.New(fmt.Sprintf("The %dth item processor is invalid!", i))
+		}
+	}
+
+	var siteUrl *url.URL
+	scopePolicy := this.scopePolicy
+	if firstHttpReq != nil {
+		siteUrl = firstHttpReq.URL
+		pd, err := getPrimaryDomain(firstHttpReq.Host)
+		if err != nil {
+			return err
+		}
+		this.primaryDomain = pd
+		//默认的范围：与首个请求同一可注册域名的URL
+		if scopePolicy == nil {
+			scopePolicy = scope.NewPolicy()
+			if err := scopePolicy.AllowDomains(pd); err != nil {
+				return err
+			}
+		}
+	}
+	dedupStore := this.genDedupStore()
+	if dedupStore == nil {
+		return errors.New("The dedup store is invalid!")
+	}
+
+	this.chanman = generateChannelManager(this.channelArgs)
+	this.politeness = newPoliteness(this.politenessArgs)
+	this.robots = newRobotsCache(this.robotsArgs, this.fetchRobots)
+	dlpool, err := generatePageDownloadPool(this.poolBaseArgs.PageDownloaderPoolSize(), httpClientGenerator, this.maxBodySize, this.dlMiddlewares, this.proxyPool)
 	if err != nil {
 		errMsg := fmt.Sprintf("Occur error when get page downloader pool:%s\n", err)
 		return errors.New(errMsg)
@@ -119,16 +270,6 @@ func (this *myScheduler) Start(channelArgs base.ChannelArgs,
 		return errors.New(errMsg)
 	}
 	this.analyzerPool = analyzerPool
-
-	if itemProcessors == nil {
-		return errors.New("The item processor list is invalid!")
-	}
-	for i, ip := range itemProcessors {
-		if ip == nil {
-			return errors.New(fmt.Sprintf("The %dth item processor is invalid!", i))
-		}
-	}
-
 	this.itempipeline = generateItemPipelLine(itemProcessors)
 
 	if this.stopSign == nil {
@@ -137,25 +278,46 @@ func (this *myScheduler) Start(channelArgs base.ChannelArgs,
 		this.stopSign.Reset()
 	}
 
-	this.urlMap = make(map[string]bool)
+	reqCache, urls, err := openStore(this.dataDir, this.frontierStrategy, this.scoreRequest, dedupStore)
+	if err != nil {
+		return err
+	}
+	var firstReq *base.Request
+	var firstFingerprint string
+	if firstHttpReq != nil {
+		firstReq = base.NewRequest(firstHttpReq, 0)
+		firstFingerprint, err = this.fingerprinter.Fingerprint(firstReq)
+		if err != nil {
+			reqCache.close()
+			urls.close()
+			return err
+		}
+	}
+	this.reqCache = reqCache
+	this.urls = urls
+	this.scopePolicy = scopePolicy
+	this.scopeCounts = make([]uint64, len(scope.Decisions))
+	atomic.StoreUint64(&this.tooDeep, 0)
+	//首个请求与种子一样检查范围和 robots.txt
+	if firstReq != nil {
+		if this.urls.contains(firstFingerprint) {
+			logger.Printf("Resume from the request cache (length=%d)\n", this.reqCache.length())
+		} else {
+			this.savaReqToCache(*firstReq, SCHEDULER_CODE, nil)
+		}
+	}
+
 	//this.wg.Add(4)
 	//defer this.wg.Wait()
 	this.startDownlaoding()
 	this.activateAnalyzers(respParsers)
 	this.openItemPipeLine()
 	this.schedule(10 * time.Millisecond)
-
-	if firstHttpReq == nil {
-		return errors.New("The frist http request is invalid!")
+	this.pollFeeds()
+	this.seedFromSitemaps(siteUrl)
+	for _, source := range this.seedSources {
+		this.streamSeeds(source)
 	}
-	pd, err := getPrimaryDomain(firstHttpReq.Host)
-	if err != nil {
-		return err
-	}
-	this.primaryDomain = pd
-
-	firstReq := base.NewRequest(firstHttpReq, 0)
-	this.reqCache.put(firstReq)
 	return nil
 }
 
@@ -163,12 +325,13 @@ func (this *myScheduler) schedule(interval time.Duration) {
 	go func() {
 		//defer this.wg.Done()
 		for {
+			this.waitWhilePaused()
 			remainder := cap(this.getReqChan()) - len(this.getReqChan())
 			var temp *base.Request
-			for remainder > 0 {
-				temp = this.reqCache.get()
+			for remainder > 0 && !this.Paused() {
+				temp = this.reqCache.get(this.politeReady)
 				if temp == nil {
-					return
+					break
 				}
 				this.getReqChan() <- *temp
 				remainder--
@@ -182,6 +345,16 @@ func (this *myScheduler) schedule(interval time.Duration) {
 	}()
 }
 
+//判断请求的主机是否可以访问，可以时获取访问许可，请求下载完成后归还
+func (this *myScheduler) politeReady(req *base.Request) bool {
+	httpReq := req.HttpReq()
+	if httpReq == nil || httpReq.URL == nil {
+		//无效的请求交给下载时报错，不能一直留在请求缓存中
+		return true
+	}
+	return this.politeness.tryAcquire(httpReq.URL.Hostname())
+}
+
 func (this *myScheduler) openItemPipeLine() {
 	go func() {
 		//defer this.wg.Done()
@@ -213,6 +386,7 @@ func (this *myScheduler) Stop() bool {
 	this.stopSign.Sign()
 	this.chanman.Close()
 	this.reqCache.close()
+	this.urls.close()
 
 	atomic.StoreUint32(&this.running, 2)
 	return true
@@ -222,6 +396,173 @@ func (this *myScheduler) Running() bool {
 	return atomic.LoadUint32(&this.running) == 1
 }
 
+func (this *myScheduler) Pause() bool {
+	if atomic.LoadUint32(&this.running) != 1 {
+		return false
+	}
+	if !atomic.CompareAndSwapUint32(&this.paused, 0, 1) {
+		return false
+	}
+	logger.Println("The scheduler is paused.")
+	return true
+}
+
+func (this *myScheduler) Resume() bool {
+	if atomic.LoadUint32(&this.running) != 1 {
+		return false
+	}
+	if !atomic.CompareAndSwapUint32(&this.paused, 1, 0) {
+		return false
+	}
+	logger.Println("The scheduler is resumed.")
+	return true
+}
+
+func (this *myScheduler) Paused() bool {
+	return atomic.LoadUint32(&this.paused) == 1
+}
+
+func (this *myScheduler) SetFrontier(strategy FrontierStrategy, score ScoreRequest) error {
+	if atomic.LoadUint32(&this.running) == 1 {
+		return errors.New("The frontier can not be changed while the scheduler is running!")
+	}
+	if err := checkFrontier(strategy, score); err != nil {
+		return err
+	}
+	this.frontierStrategy = strategy
+	this.scoreRequest = score
+	return nil
+}
+
+func (this *myScheduler) SetPoliteness(politenessArgs base.PolitenessArgs) error {
+	if atomic.LoadUint32(&this.running) == 1 {
+		return errors.New("The politeness can not be changed while the scheduler is running!")
+	}
+	if err := politenessArgs.Check(); err != nil {
+		return err
+	}
+	this.politenessArgs = politenessArgs
+	return nil
+}
+
+func (this *myScheduler) SetRobots(robotsArgs base.RobotsArgs) error {
+	if atomic.LoadUint32(&this.running) == 1 {
+		return errors.New("The robots args can not be changed while the scheduler is running!")
+	}
+	if err := robotsArgs.Check(); err != nil {
+		return err
+	}
+	this.robotsArgs = robotsArgs
+	return nil
+}
+
+func (this *myScheduler) SetScope(scopePolicy *scope.Policy) error {
+	if atomic.LoadUint32(&this.running) == 1 {
+		return errors.New("The scope can not be changed while the scheduler is running!")
+	}
+	if scopePolicy == nil {
+		return errors.New("The scope policy is invalid!")
+	}
+	this.scopePolicy = scopePolicy
+	return nil
+}
+
+func (this *myScheduler) SetFingerprinter(fingerprinter *base.Fingerprinter) error {
+	if atomic.LoadUint32(&this.running) == 1 {
+		return errors.New("The fingerprinter can not be changed while the scheduler is running!")
+	}
+	if fingerprinter == nil {
+		return errors.New("The fingerprinter is invalid!")
+	}
+	this.fingerprinter = fingerprinter
+	return nil
+}
+
+func (this *myScheduler) SetDedupStore(genDedupStore middleware.GenDedupStore) error {
+	if atomic.LoadUint32(&this.running) == 1 {
+		return errors.New("The dedup store can not be changed while the scheduler is running!")
+	}
+	if genDedupStore == nil {
+		return errors.New("The dedup store generator is invalid!")
+	}
+	this.genDedupStore = genDedupStore
+	return nil
+}
+
+func (this *myScheduler) SetRetry(retryArgs base.RetryArgs) error {
+	if atomic.LoadUint32(&this.running) == 1 {
+		return errors.New("The retry args can not be changed while the scheduler is running!")
+	}
+	if err := retryArgs.Check(); err != nil {
+		return err
+	}
+	this.retryArgs = retryArgs
+	return nil
+}
+
+func (this *myScheduler) SetDownloaderMiddlewares(middlewares ...downloader.DownloaderMiddleware) error {
+	if atomic.LoadUint32(&this.running) == 1 {
+		return errors.New("The downloader middlewares can not be changed while the scheduler is running!")
+	}
+	for i, middleware := range middlewares {
+		if middleware == nil {
+			return errors.New(fmt.Sprintf("The %dth downloader middleware is invalid!", i))
+		}
+	}
+	this.dlMiddlewares = middlewares
+	return nil
+}
+
+func (this *myScheduler) SetProxyPool(proxyPool *downloader.ProxyPool) error {
+	if atomic.LoadUint32(&this.running) == 1 {
+		return errors.New("The proxy pool can not be changed while the scheduler is running!")
+	}
+	this.proxyPool = proxyPool
+	return nil
+}
+
+func (this *myScheduler) SetMaxBodySize(maxBodySize int64) error {
+	if atomic.LoadUint32(&this.running) == 1 {
+		return errors.New("The max body size can not be changed while the scheduler is running!")
+	}
+	if maxBodySize < 0 {
+		return errors.New(fmt.Sprintf("The max body size is invalid! (maxBodySize=%d)", maxBodySize))
+	}
+	this.maxBodySize = maxBodySize
+	return nil
+}
+
+//代理池的摘要
+func (this *myScheduler) proxySummary() string {
+	if this.proxyPool == nil {
+		return "<none>"
+	}
+	return this.proxyPool.Summary()
+}
+
+//范围判定结果的计数摘要
+func (this *myScheduler) scopeSummary() string {
+	var buffer bytes.Buffer
+	for i, decision := range scope.Decisions {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		var count uint64
+		if i < len(this.scopeCounts) {
+			count = atomic.LoadUint64(&this.scopeCounts[i])
+		}
+		buffer.WriteString(fmt.Sprintf("%s:%d", decision, count))
+	}
+	return buffer.String()
+}
+
+//暂停期间阻塞，直到调度器恢复或收到停止信号
+func (this *myScheduler) waitWhilePaused() {
+	for this.Paused() && !this.stopSign.Signed() {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func (this *myScheduler) ErrorChan() <-chan error {
 	if this.chanman.Status() != middleware.CHANNEL_MANAGER_STATUS_INITIALIZED {
 		return nil
@@ -233,7 +574,12 @@ func (this *myScheduler) Idle() bool {
 	idleDlPool := this.dlpool.Used() == 0
 	idleAnalyzerPool := this.analyzerPool.Used() == 0
 	idleItemPipeline := this.itempipeline.ProcessingNumber() == 0
-	return idleAnalyzerPool && idleDlPool && idleItemPipeline
+	idleRetry := atomic.LoadUint64(&this.retryCounts.pending) == 0
+	idleFeeds := this.feeds == nil
+	idleSitemaps := this.sitemaps == nil || atomic.LoadUint32(&this.sitemaps.pending) == 0
+	idleSeeds := atomic.LoadInt64(&this.seedCounts.pending) == 0
+	idleRobots := atomic.LoadInt64(&this.robotsPending) == 0
+	return idleAnalyzerPool && idleDlPool && idleItemPipeline && idleRetry && idleFeeds && idleSitemaps && idleSeeds && idleRobots
 }
 
 func (this *myScheduler) Summary(prefix string) SchedSummary {
@@ -251,6 +597,7 @@ func (this *myScheduler) startDownlaoding() {
 	go func() {
 		//defer this.wg.Done()
 		for {
+			this.waitWhilePaused()
 			req, ok := <-this.getReqChan()
 			if !ok {
 				break
@@ -302,6 +649,9 @@ func (this *myScheduler) download(req base.Request) {
 		}
 	}()
 
+	//访问主机的许可在取出请求时已获取，见 politeReady
+	defer this.politeness.release(req.HttpReq().URL.Hostname())
+
 	downloader, err := this.dlpool.Take()
 	if err != nil {
 		errMsg := fmt.Sprintf("Downloader pool error:%s\n", err)
@@ -320,18 +670,35 @@ func (this *myScheduler) download(req base.Request) {
 
 	code := generateCode(DOWNLOADER_CODE, downloader.Id())
 	resp, err := downloader.Download(req)
+	if isDropped(err) {
+		logger.Printf("Ignore the requst ! It is dropped by downloader middleware (requestUrl='%s')\n", req.HttpReq().URL)
+		this.reqCache.done(req.Seq())
+		return
+	}
+	if delay, ok := this.shouldRetry(req, resp, err); ok {
+		if resp != nil && resp.HttpReq().Body != nil {
+			resp.HttpReq().Body.Close()
+		}
+		this.retry(req, delay)
+		return
+	}
+	//有响应时，请求在响应分析完成后才标记为完成，见 analyze
 	if resp != nil {
 		this.SendResp(*resp, code)
 	}
 	if err != nil {
 		this.SendError(err, code)
 	}
+	if resp == nil && !this.stopSign.Signed() {
+		this.reqCache.done(req.Seq())
+	}
 }
 
 func (this *myScheduler) activateAnalyzers(respParsers []analyzer.ParseResponse) {
 	go func() {
 		//defer this.wg.Done()
 		for {
+			this.waitWhilePaused()
 			resp, ok := <-this.getRespChan()
 			if !ok {
 				break
@@ -369,6 +736,19 @@ func (this *myScheduler) analyze(respParsers []analyzer.ParseResponse, resp base
 	}()
 
 	code := generateCode(ANALYZER_CODE, analyzer.Id())
+	if resp.Callback() == FEED_CALLBACK {
+		this.analyzeFeed(resp, code)
+	} else {
+		this.analyzeData(analyzer, respParsers, resp, code)
+	}
+	//子请求都已放入请求缓存后才标记请求完成；停止时子请求可能未放入，保留该请求以便恢复后重新下载
+	if !this.stopSign.Signed() {
+		this.reqCache.done(resp.Seq())
+	}
+}
+
+//用解析函数分析响应，把得到的请求放入请求缓存，条目交给条目处理管道
+func (this *myScheduler) analyzeData(analyzer analyzer.Analyzer, respParsers []analyzer.ParseResponse, resp base.Response, code string) {
 	datalist, errs := analyzer.Analyze(respParsers, resp)
 	if datalist != nil {
 		for _, data := range datalist {
@@ -377,9 +757,11 @@ func (this *myScheduler) analyze(respParsers []analyzer.ParseResponse, resp base
 			}
 			switch d := data.(type) {
 			case *base.Request:
-				this.savaReqToCache(*d, code)
+				this.savaReqToCache(*d, code, nil)
 			case *base.Item:
 				this.sendItem(*d, code)
+			case base.Item:
+				this.sendItem(d, code)
 			default:
 				errMsg := fmt.Sprintf("Unsupported data type '%T'! (value=%v)\n", d, d)
 				this.SendError(errors.New(errMsg), code)
@@ -397,7 +779,9 @@ func (this *myScheduler) analyze(respParsers []analyzer.ParseResponse, resp base
 	}
 }
 
-func (this *myScheduler) savaReqToCache(req base.Request, code string) bool {
+//检查请求并放入请求缓存，请求被放入缓存时返回true，并在 enqueued 不为nil时把它加1
+//主机的 robots.txt 尚未获取时请求暂存在后台，获取完成后重新检查，这时返回false，之后放入缓存时仍会增加 enqueued
+func (this *myScheduler) savaReqToCache(req base.Request, code string, enqueued *uint64) bool {
 	httpReq := req.HttpReq()
 	if httpReq == nil {
 		logger.Println("Ignore the requst ! It is HTTP request is invalid!")
@@ -409,28 +793,63 @@ func (this *myScheduler) savaReqToCache(req base.Request, code string) bool {
 
 		return false
 	}
-	if strings.ToLower(reqUrl.Scheme) != "htttp" {
-		logger.Printf("Ignore the requst ! It is url scheme '%s' ,but should be 'http' !\n", reqUrl.Scheme)
+	if req.Depth() > this.crawlDepth {
+		atomic.AddUint64(&this.tooDeep, 1)
+		logger.Printf("Ignore the requst ! It is depth %d is greater than the crawl depth %d. (requestUrl='%s')\n", req.Depth(), this.crawlDepth, reqUrl)
+		return false
+	}
+	decision := this.scopePolicy.Check(reqUrl)
+	atomic.AddUint64(&this.scopeCounts[decision], 1)
+	if decision != scope.ACCEPTED {
+		logger.Printf("Ignore the requst ! It is out of scope : %s (requestUrl='%s')\n", decision, reqUrl)
 		return false
 	}
 
-	if _, ok := this.urlMap[reqUrl.String()]; ok {
+	fingerprint, err := this.fingerprinter.Fingerprint(&req)
+	if err != nil {
+		logger.Printf("Ignore the requst ! It is fingerprint can not be computed : %s (requestUrl='%s')\n", err, reqUrl)
+		return false
+	}
+	if this.urls.contains(fingerprint) {
 		logger.Printf("Ignore the requst ! It is url is repeated. (requestUrl='%s')\n", reqUrl)
 		return false
 	}
 
-	if pd, _ := getPrimaryDomain(httpReq.Host); pd != this.primaryDomain {
-		logger.Printf("Ignore the requst ! It is host '%s' is not in primary domain '%s' (requestUrl='%s')\n", httpReq.Host, this.primaryDomain, reqUrl)
+	//先计入等待 robots.txt 的请求，避免后台获取完成得太快时计数短暂为负
+	//获取完成后只检查 robots.txt 并放入缓存，范围等检查不再重复
+	atomic.AddInt64(&this.robotsPending, 1)
+	allowed, ready := this.robotsAllowedAsync(reqUrl, func() {
+		defer atomic.AddInt64(&this.robotsPending, -1)
+		if !this.robotsAllowed(reqUrl) {
+			logger.Printf("Ignore the requst ! It is disallowed by robots.txt (requestUrl='%s')\n", reqUrl)
+			return
+		}
+		this.putReqToCache(req, fingerprint, code, enqueued)
+	})
+	if !ready {
+		return false
+	}
+	atomic.AddInt64(&this.robotsPending, -1)
+	if !allowed {
+		logger.Printf("Ignore the requst ! It is disallowed by robots.txt (requestUrl='%s')\n", reqUrl)
 		return false
 	}
+	return this.putReqToCache(req, fingerprint, code, enqueued)
+}
 
+//把已通过检查的请求放入请求缓存，fingerprint 为请求的指纹
+func (this *myScheduler) putReqToCache(req base.Request, fingerprint string, code string, enqueued *uint64) bool {
 	if this.stopSign.Signed() {
 		this.stopSign.Deal(code)
 		return false
 	}
+	if !this.urls.add(fingerprint) {
+		return false
+	}
 	this.reqCache.put(&req)
-
-	this.urlMap[reqUrl.String()] = true
+	if enqueued != nil {
+		atomic.AddUint64(enqueued, 1)
+	}
 	return true
 }
 
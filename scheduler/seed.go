@@ -0,0 +1,69 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"github.com/fmyxyz/goreptile/seed"
+	"sync/atomic"
+)
+
+//种子计数
+type seedCounts struct {
+	sources  uint64 //已开始的种子来源数
+	pending  int64  //正在交出种子的种子来源数
+	seeds    uint64 //已交出的种子数
+	enqueued uint64 //放入请求缓存的种子数
+	failed   uint64 //种子来源的错误数
+}
+
+func (this *seedCounts) summary() string {
+	return fmt.Sprintf("sources:%d,pending:%d,seeds:%d,enqueued:%d,failed:%d",
+		atomic.LoadUint64(&this.sources), atomic.LoadInt64(&this.pending),
+		atomic.LoadUint64(&this.seeds), atomic.LoadUint64(&this.enqueued), atomic.LoadUint64(&this.failed))
+}
+
+func (this *myScheduler) AddSeeds(sources ...seed.Source) error {
+	for i, source := range sources {
+		if source == nil {
+			return errors.New(fmt.Sprintf("The seed source [%d] is invalid!", i))
+		}
+	}
+	if atomic.LoadUint32(&this.running) == 1 {
+		for _, source := range sources {
+			this.streamSeeds(source)
+		}
+		return nil
+	}
+	this.seedSources = append(this.seedSources, sources...)
+	return nil
+}
+
+//在后台把种子来源的种子放入请求缓存，种子的深度为0
+//种子同样经过范围、去重和 robots.txt 检查，暂停期间不交出种子，停止后种子来源不再交出种子
+func (this *myScheduler) streamSeeds(source seed.Source) {
+	atomic.AddUint64(&this.seedCounts.sources, 1)
+	atomic.AddInt64(&this.seedCounts.pending, 1)
+	go func() {
+		defer atomic.AddInt64(&this.seedCounts.pending, -1)
+		errs := source.Seeds(func(req *base.Request) bool {
+			this.waitWhilePaused()
+			if this.stopSign.Signed() || !this.Running() {
+				return false
+			}
+			if req == nil || !req.Valid() {
+				return true
+			}
+			if req.Depth() != 0 {
+				req = req.CopyWithDepth(0)
+			}
+			atomic.AddUint64(&this.seedCounts.seeds, 1)
+			this.savaReqToCache(*req, SCHEDULER_CODE, &this.seedCounts.enqueued)
+			return true
+		})
+		for _, err := range errs {
+			atomic.AddUint64(&this.seedCounts.failed, 1)
+			this.SendError(err, SCHEDULER_CODE)
+		}
+	}()
+}
@@ -0,0 +1,95 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"github.com/fmyxyz/goreptile/sitemap"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+)
+
+//站点地图的遍历状态
+type sitemapSeeding struct {
+	walker   *sitemap.Walker
+	pending  uint32 //1表示正在遍历
+	enqueued uint64 //放入请求缓存的URL数
+}
+
+func (this *sitemapSeeding) summary() string {
+	if this == nil {
+		return "<none>"
+	}
+	return fmt.Sprintf("pending:%v,%s,enqueued:%d",
+		atomic.LoadUint32(&this.pending) == 1, this.walker.Summary(), atomic.LoadUint64(&this.enqueued))
+}
+
+func (this *myScheduler) SetSitemaps(sitemapArgs base.SitemapArgs) error {
+	if atomic.LoadUint32(&this.running) == 1 {
+		return errors.New("The sitemap args can not be changed while the scheduler is running!")
+	}
+	if err := sitemapArgs.Check(); err != nil {
+		return err
+	}
+	this.sitemapArgs = sitemapArgs
+	return nil
+}
+
+//获取站点地图，使用 robots.txt 的 User-agent
+func (this *myScheduler) fetchSitemap(sitemapUrl *url.URL) (int, []byte, error) {
+	httpReq, err := http.NewRequest(http.MethodGet, sitemapUrl.String(), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if ua := this.robotsArgs.UserAgent(); ua != "" {
+		httpReq.Header.Set("User-Agent", ua)
+	}
+	return this.fetchDirect(httpReq, sitemap.MaxSize)
+}
+
+//在后台遍历站点地图，把其中的URL作为种子（深度为0）放入请求缓存
+//先遍历指定的站点地图，再遍历从 siteUrl 所在站点发现的站点地图（siteUrl 为nil时不发现），发现的站点地图不存在时只记录日志
+//放入的请求同样经过范围、去重和 robots.txt 检查
+func (this *myScheduler) seedFromSitemaps(siteUrl *url.URL) {
+	if !this.sitemapArgs.Enabled() {
+		this.sitemaps = nil
+		return
+	}
+	seeding := &sitemapSeeding{
+		walker: sitemap.NewWalker(this.fetchSitemap,
+			this.sitemapArgs.MaxDepth(), this.sitemapArgs.MaxUrls(), this.sitemapArgs.Since()),
+		pending: 1,
+	}
+	this.sitemaps = seeding
+	go func() {
+		defer atomic.StoreUint32(&seeding.pending, 0)
+		emit := func(entry sitemap.Url) bool {
+			this.waitWhilePaused()
+			if this.stopSign.Signed() {
+				return false
+			}
+			req, err := entry.Request(0)
+			if err != nil {
+				this.SendError(err, SCHEDULER_CODE)
+				return true
+			}
+			this.savaReqToCache(*req, SCHEDULER_CODE, &seeding.enqueued)
+			return true
+		}
+		for _, err := range seeding.walker.Walk(this.sitemapArgs.SitemapUrls(), emit) {
+			this.SendError(err, SCHEDULER_CODE)
+		}
+		if !this.sitemapArgs.Discover() || siteUrl == nil || this.stopSign.Signed() {
+			return
+		}
+		discovered := sitemap.Discover(siteUrl, this.robots.sitemaps(siteUrl))
+		for _, err := range seeding.walker.Walk(discovered, emit) {
+			if sitemap.IsNotFound(err) {
+				logger.Println(err)
+				continue
+			}
+			this.SendError(err, SCHEDULER_CODE)
+		}
+	}()
+}
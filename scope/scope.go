@@ -0,0 +1,234 @@
+package scope
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/publicsuffix"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+//范围判定结果
+type Decision uint8
+
+const (
+	ACCEPTED        Decision = iota //在范围内
+	REJECTED_SCHEME                 //协议不被允许
+	REJECTED_DOMAIN                 //不在允许的域名内
+	DENIED_DOMAIN                   //在禁止的域名内
+	EXCLUDED                        //匹配了排除规则
+	NOT_INCLUDED                    //未匹配任何包含规则
+	REJECTED_PATH                   //路径不在允许的前缀内
+)
+
+var decisionNameMap = map[Decision]string{
+	ACCEPTED:        "accepted",
+	REJECTED_SCHEME: "rejectedScheme",
+	REJECTED_DOMAIN: "rejectedDomain",
+	DENIED_DOMAIN:   "deniedDomain",
+	EXCLUDED:        "excluded",
+	NOT_INCLUDED:    "notIncluded",
+	REJECTED_PATH:   "rejectedPath",
+}
+
+//所有的判定结果，按定义顺序排列
+var Decisions = []Decision{ACCEPTED, REJECTED_SCHEME, REJECTED_DOMAIN, DENIED_DOMAIN, EXCLUDED, NOT_INCLUDED, REJECTED_PATH}
+
+func (this Decision) String() string {
+	if name, ok := decisionNameMap[this]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", this)
+}
+
+//爬取范围策略
+//按协议、禁止的域名、允许的域名、排除规则、包含规则、路径前缀的顺序判定
+type Policy struct {
+	schemes        map[string]bool
+	allowedDomains []string
+	deniedDomains  []string
+	includes       []*regexp.Regexp
+	excludes       []*regexp.Regexp
+	pathPrefixes   []string
+}
+
+//创建范围策略，默认允许 http 和 https 协议下的所有URL
+func NewPolicy() *Policy {
+	return &Policy{
+		schemes: map[string]bool{"http": true, "https": true},
+	}
+}
+
+//设置允许的协议
+func (this *Policy) AllowSchemes(schemes ...string) *Policy {
+	this.schemes = make(map[string]bool)
+	for _, scheme := range schemes {
+		this.schemes[strings.ToLower(scheme)] = true
+	}
+	return this
+}
+
+//添加允许的域名，只允许该主机及其子域名
+//如 blog.example.com 允许 a.blog.example.com，但不允许 shop.example.com 和 example.com；
+//需要允许整个站点时传入可注册域名，见 RegistrableDomain
+func (this *Policy) AllowDomains(domains ...string) error {
+	for _, domain := range domains {
+		d := normalizeHost(domain)
+		if d == "" {
+			return errors.New(fmt.Sprintf("The domain '%s' is invalid!", domain))
+		}
+		this.allowedDomains = append(this.allowedDomains, d)
+	}
+	return nil
+}
+
+//添加禁止的域名，其子域名同样被禁止
+func (this *Policy) DenyDomains(domains ...string) {
+	for _, domain := range domains {
+		this.deniedDomains = append(this.deniedDomains, normalizeHost(domain))
+	}
+}
+
+//添加包含规则，设置后URL至少需要匹配一条
+func (this *Policy) Include(patterns ...string) error {
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.New(fmt.Sprintf("The include pattern '%s' is invalid : %s", pattern, err))
+		}
+		this.includes = append(this.includes, re)
+	}
+	return nil
+}
+
+//添加排除规则，匹配任意一条的URL都会被排除
+func (this *Policy) Exclude(patterns ...string) error {
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.New(fmt.Sprintf("The exclude pattern '%s' is invalid : %s", pattern, err))
+		}
+		this.excludes = append(this.excludes, re)
+	}
+	return nil
+}
+
+//添加允许的路径前缀，设置后URL的路径需要以其中之一开头
+func (this *Policy) AllowPathPrefixes(prefixes ...string) {
+	this.pathPrefixes = append(this.pathPrefixes, prefixes...)
+}
+
+//是否已设置允许的域名
+func (this *Policy) HasAllowedDomains() bool {
+	return len(this.allowedDomains) > 0
+}
+
+//判定URL是否在爬取范围内
+func (this *Policy) Check(u *url.URL) Decision {
+	if !this.schemes[strings.ToLower(u.Scheme)] {
+		return REJECTED_SCHEME
+	}
+	host := normalizeHost(u.Hostname())
+	for _, domain := range this.deniedDomains {
+		if matchDomain(host, domain) {
+			return DENIED_DOMAIN
+		}
+	}
+	if len(this.allowedDomains) > 0 {
+		allowed := false
+		for _, domain := range this.allowedDomains {
+			if matchDomain(host, domain) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return REJECTED_DOMAIN
+		}
+	}
+	rawUrl := u.String()
+	for _, re := range this.excludes {
+		if re.MatchString(rawUrl) {
+			return EXCLUDED
+		}
+	}
+	if len(this.includes) > 0 {
+		included := false
+		for _, re := range this.includes {
+			if re.MatchString(rawUrl) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return NOT_INCLUDED
+		}
+	}
+	if len(this.pathPrefixes) > 0 {
+		path := u.Path
+		if path == "" {
+			path = "/"
+		}
+		for _, prefix := range this.pathPrefixes {
+			if strings.HasPrefix(path, prefix) {
+				return ACCEPTED
+			}
+		}
+		return REJECTED_PATH
+	}
+	return ACCEPTED
+}
+
+func (this *Policy) String() string {
+	schemes := make([]string, 0, len(this.schemes))
+	for scheme := range this.schemes {
+		schemes = append(schemes, scheme)
+	}
+	return fmt.Sprintf("schemes:%v,allowedDomains:%v,deniedDomains:%v,includes:%d,excludes:%d,pathPrefixes:%v",
+		schemes, this.allowedDomains, this.deniedDomains, len(this.includes), len(this.excludes), this.pathPrefixes)
+}
+
+//获取主机的可注册域名（公共后缀加一级），如 www.csdn.net 的可注册域名为 csdn.net
+//IP地址和 localhost 等没有公共后缀的主机原样返回
+func RegistrableDomain(host string) (string, error) {
+	host = normalizeHost(host)
+	if host == "" {
+		return "", errors.New("The host is invalid!")
+	}
+	if strings.Contains(host, ":") || !strings.Contains(host, ".") || isIPv4(host) {
+		return host, nil
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("The host '%s' is invalid : %s", host, err))
+	}
+	return domain, nil
+}
+
+//去掉端口、结尾的点并转为小写
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if u, err := url.Parse("//" + host); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+//主机是否为该域名或其子域名
+func matchDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func isIPv4(host string) bool {
+	parts := strings.Split(host, ".")
+	if len(parts) != 4 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" || strings.TrimLeft(part, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
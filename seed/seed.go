@@ -0,0 +1,267 @@
+package seed
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+//接收种子请求，返回false时种子来源应尽快停止交出种子
+type Emit func(req *base.Request) bool
+
+//种子来源，依次交出深度为0的种子请求
+type Source interface {
+	//依次把种子交给 emit，直到种子交完或 emit 返回false
+	//返回交出种子过程中出现的错误，单个种子出错不影响其他种子
+	Seeds(emit Emit) []error
+	String() string
+}
+
+//由给定的 HTTP 请求组成的种子来源
+type requestSource struct {
+	httpReqs []*http.Request
+}
+
+func NewRequestSource(httpReqs ...*http.Request) Source {
+	return &requestSource{httpReqs: httpReqs}
+}
+
+func (this *requestSource) Seeds(emit Emit) []error {
+	var errs []error
+	for i, httpReq := range this.httpReqs {
+		if httpReq == nil || httpReq.URL == nil {
+			errs = append(errs, errors.New(fmt.Sprintf("The seed http request [%d] of %s is invalid!", i, this)))
+			continue
+		}
+		if !emit(base.NewRequest(httpReq, 0)) {
+			break
+		}
+	}
+	return errs
+}
+
+func (this *requestSource) String() string {
+	return fmt.Sprintf("requests(%d)", len(this.httpReqs))
+}
+
+//由给定的URL组成的种子来源，使用 GET 方法
+func NewUrlSource(urls ...string) Source {
+	return &readerSource{
+		name: fmt.Sprintf("urls(%d)", len(urls)),
+		open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(strings.Join(urls, "\n"))), nil
+		},
+	}
+}
+
+//按行读取种子的种子来源
+type readerSource struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+//从 reader 按行读取种子，格式见 ParseLine
+func NewReaderSource(name string, reader io.Reader) Source {
+	return &readerSource{
+		name: name,
+		open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(reader), nil
+		},
+	}
+}
+
+//从文件按行读取种子，格式见 ParseLine，以 .gz 结尾的文件会先解压
+//文件在交出种子时才打开，因此可以交给尚未启动的调度器
+func NewFileSource(path string) Source {
+	return &readerSource{
+		name: path,
+		open: func() (io.ReadCloser, error) {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			if !strings.HasSuffix(path, ".gz") {
+				return file, nil
+			}
+			gzipReader, err := gzip.NewReader(file)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			return &gzipFile{Reader: gzipReader, file: file}, nil
+		},
+	}
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (this *gzipFile) Close() error {
+	this.Reader.Close()
+	return this.file.Close()
+}
+
+//单行种子的最大长度
+const maxLineSize = 1 << 20
+
+func (this *readerSource) Seeds(emit Emit) []error {
+	reader, err := this.open()
+	if err != nil {
+		return []error{err}
+	}
+	defer reader.Close()
+	var errs []error
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		req, err := ParseLine(scanner.Text())
+		if err != nil {
+			errs = append(errs, errors.New(fmt.Sprintf("%s:%d: %s", this.name, lineNumber, err)))
+			continue
+		}
+		if req == nil {
+			continue
+		}
+		if !emit(req) {
+			return errs
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, errors.New(fmt.Sprintf("%s:%d: %s", this.name, lineNumber, err)))
+	}
+	return errs
+}
+
+func (this *readerSource) String() string {
+	return this.name
+}
+
+//请求头的值，可以是字符串或字符串数组
+type headerValues []string
+
+func (this *headerValues) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*this = headerValues{value}
+		return nil
+	}
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return errors.New(fmt.Sprintf("The header value %s is neither a string nor a string array!", data))
+	}
+	*this = values
+	return nil
+}
+
+//JSON 格式的种子
+type jsonSeed struct {
+	Url      string                  `json:"url"`
+	Method   string                  `json:"method"`
+	Headers  map[string]headerValues `json:"headers"`
+	Body     string                  `json:"body"`
+	Meta     map[string]interface{}  `json:"meta"`
+	Priority int                     `json:"priority"`
+	Callback string                  `json:"callback"`
+}
+
+//解析一行种子
+//以 { 开头的行为 JSON 格式的种子，包括 url、method、headers、body、meta、priority 和 callback，
+//例如 {"url":"https://example.com/search","method":"POST","headers":{"Content-Type":"application/json"},"body":"{\"q\":1}","meta":{"category":"a"}}
+//其他的行为使用 GET 方法的URL，空行和以 # 开头的行会被忽略并返回nil
+func ParseLine(line string) (*base.Request, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	if !strings.HasPrefix(line, "{") {
+		return newRequest(http.MethodGet, line, nil)
+	}
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.DisallowUnknownFields()
+	var seed jsonSeed
+	if err := decoder.Decode(&seed); err != nil {
+		return nil, errors.New(fmt.Sprintf("The json seed is invalid: %s", err))
+	}
+	method := strings.ToUpper(seed.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if seed.Body != "" {
+		body = strings.NewReader(seed.Body)
+	}
+	req, err := newRequest(method, seed.Url, body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq := req.HttpReq()
+	for name, values := range seed.Headers {
+		for _, value := range values {
+			httpReq.Header.Add(name, value)
+		}
+	}
+	if host := httpReq.Header.Get("Host"); host != "" {
+		httpReq.Host = host
+	}
+	req.SetPriority(seed.Priority)
+	if seed.Meta != nil {
+		req.SetMetaMap(seed.Meta)
+	}
+	if seed.Callback != "" {
+		req.SetCallback(seed.Callback)
+	}
+	return req, nil
+}
+
+func newRequest(method string, rawUrl string, body io.Reader) (*base.Request, error) {
+	rawUrl = strings.TrimSpace(rawUrl)
+	httpReq, err := http.NewRequest(method, rawUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	if !httpReq.URL.IsAbs() || httpReq.URL.Host == "" {
+		return nil, errors.New(fmt.Sprintf("The seed url %q is not absolute!", rawUrl))
+	}
+	return base.NewRequest(httpReq, 0), nil
+}
+
+//从通道读取种子的种子来源，通道关闭后种子交完
+//通道可以在爬取过程中持续写入；emit 返回false后不再读取通道
+type channelSource struct {
+	name  string
+	seeds <-chan *base.Request
+}
+
+func NewChannelSource(name string, seeds <-chan *base.Request) Source {
+	return &channelSource{name: name, seeds: seeds}
+}
+
+func (this *channelSource) Seeds(emit Emit) []error {
+	var errs []error
+	for req := range this.seeds {
+		if req == nil || !req.Valid() {
+			errs = append(errs, errors.New(fmt.Sprintf("The seed request from %s is invalid!", this.name)))
+			continue
+		}
+		if !emit(req) {
+			break
+		}
+	}
+	return errs
+}
+
+func (this *channelSource) String() string {
+	return this.name
+}
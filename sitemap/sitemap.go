@@ -0,0 +1,216 @@
+package sitemap
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/base"
+	"golang.org/x/net/html/charset"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPriority = 0.5      //未指定 priority 时的优先级
+	MaxSize         = 50 << 20 //解压后站点地图的最大长度
+)
+
+//放入请求的元数据的键
+const (
+	META_LASTMOD    = "sitemapLastmod"
+	META_PRIORITY   = "sitemapPriority"
+	META_CHANGEFREQ = "sitemapChangefreq"
+)
+
+//站点地图中的一个URL，或站点地图索引中的一个子站点地图
+type Url struct {
+	Loc        string
+	LastMod    time.Time //未指定或无法解析时为零值
+	ChangeFreq string
+	Priority   float64 //0.0 ~ 1.0
+}
+
+//根据 lastmod 判断是否在 since 之后修改过，没有 lastmod 时视为修改过
+func (this Url) ModifiedSince(since time.Time) bool {
+	return since.IsZero() || this.LastMod.IsZero() || !this.LastMod.Before(since)
+}
+
+//转换为请求，priority 映射为 0 ~ 10 的请求优先级，lastmod、priority 和 changefreq 放入元数据
+func (this Url) Request(depth uint32) (*base.Request, error) {
+	httpReq, err := http.NewRequest(http.MethodGet, this.Loc, nil)
+	if err != nil {
+		return nil, err
+	}
+	req := base.NewRequestWithPriority(httpReq, depth, int(math.Round(this.Priority*10)))
+	req.SetMeta(META_PRIORITY, this.Priority)
+	if !this.LastMod.IsZero() {
+		req.SetMeta(META_LASTMOD, this.LastMod.Format(time.RFC3339))
+	}
+	if this.ChangeFreq != "" {
+		req.SetMeta(META_CHANGEFREQ, this.ChangeFreq)
+	}
+	return req, nil
+}
+
+//解析后的站点地图
+//普通站点地图只有 Urls，站点地图索引只有 Sitemaps
+type Sitemap struct {
+	Urls     []Url
+	Sitemaps []Url
+}
+
+type xmlUrl struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod"`
+	ChangeFreq string `xml:"changefreq"`
+	Priority   string `xml:"priority"`
+}
+
+func (this *xmlUrl) url() Url {
+	u := Url{
+		Loc:        strings.TrimSpace(this.Loc),
+		LastMod:    parseLastMod(this.LastMod),
+		ChangeFreq: strings.ToLower(strings.TrimSpace(this.ChangeFreq)),
+		Priority:   DefaultPriority,
+	}
+	if priority, err := strconv.ParseFloat(strings.TrimSpace(this.Priority), 64); err == nil {
+		u.Priority = math.Max(0, math.Min(1, priority))
+	}
+	return u
+}
+
+type xmlDocument struct {
+	Urls     []xmlUrl `xml:"url"`
+	Sitemaps []xmlUrl `xml:"sitemap"`
+}
+
+//lastmod 使用的 W3C 时间格式
+var lastModLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+func parseLastMod(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+//解析站点地图，支持 XML 站点地图、站点地图索引和每行一个URL的文本站点地图
+//经过 gzip 压缩的内容（如 sitemap.xml.gz）会先解压
+func Parse(data []byte) (*Sitemap, error) {
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		data, err = ioutil.ReadAll(io.LimitReader(gzipReader, MaxSize+1))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(data) > MaxSize {
+		return nil, errors.New(fmt.Sprintf("The sitemap is larger than the max size %d bytes!", MaxSize))
+	}
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte{0xef, 0xbb, 0xbf}))
+	if !bytes.HasPrefix(trimmed, []byte("<")) {
+		return parseText(trimmed)
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(trimmed))
+	decoder.Strict = false
+	decoder.CharsetReader = charset.NewReaderLabel
+	var root xml.StartElement
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("The sitemap is invalid: %s", err))
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			root = start
+			break
+		}
+	}
+	if root.Name.Local != "urlset" && root.Name.Local != "sitemapindex" {
+		return nil, errors.New(fmt.Sprintf("Unsupported sitemap root element <%s>!", root.Name.Local))
+	}
+	var doc xmlDocument
+	if err := decoder.DecodeElement(&doc, &root); err != nil {
+		return nil, errors.New(fmt.Sprintf("The sitemap is invalid: %s", err))
+	}
+	sitemap := &Sitemap{}
+	for i := range doc.Urls {
+		if u := doc.Urls[i].url(); u.Loc != "" {
+			sitemap.Urls = append(sitemap.Urls, u)
+		}
+	}
+	for i := range doc.Sitemaps {
+		if u := doc.Sitemaps[i].url(); u.Loc != "" {
+			sitemap.Sitemaps = append(sitemap.Sitemaps, u)
+		}
+	}
+	return sitemap, nil
+}
+
+func parseText(data []byte) (*Sitemap, error) {
+	sitemap := &Sitemap{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		u, err := url.Parse(line)
+		if err != nil || !u.IsAbs() {
+			return nil, errors.New(fmt.Sprintf("The text sitemap contains an invalid url %q!", line))
+		}
+		sitemap.Urls = append(sitemap.Urls, Url{Loc: line, Priority: DefaultPriority})
+	}
+	return sitemap, scanner.Err()
+}
+
+//按优先级从高到低排序，优先级相同时最近修改的在前
+func SortUrls(urls []Url) {
+	sort.SliceStable(urls, func(i, j int) bool {
+		if urls[i].Priority != urls[j].Priority {
+			return urls[i].Priority > urls[j].Priority
+		}
+		return urls[i].LastMod.After(urls[j].LastMod)
+	})
+}
+
+//站点的默认站点地图，即 scheme://host/sitemap.xml
+func DefaultUrl(siteUrl *url.URL) string {
+	return (&url.URL{Scheme: siteUrl.Scheme, Host: siteUrl.Host, Path: "/sitemap.xml"}).String()
+}
+
+//发现站点的站点地图：robots.txt 中声明的站点地图，以及默认站点地图
+func Discover(siteUrl *url.URL, declared []string) []string {
+	var sitemapUrls []string
+	seen := make(map[string]bool)
+	for _, sitemapUrl := range append(declared, DefaultUrl(siteUrl)) {
+		if !seen[sitemapUrl] {
+			seen[sitemapUrl] = true
+			sitemapUrls = append(sitemapUrls, sitemapUrl)
+		}
+	}
+	return sitemapUrls
+}
@@ -0,0 +1,162 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func gzipped(t *testing.T, data string) string {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.String()
+}
+
+func TestParse(t *testing.T) {
+	urlset := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc> http://example.com/a </loc>
+    <lastmod>2024-05-06T07:08:09+08:00</lastmod>
+    <changefreq>Daily</changefreq>
+    <priority>0.8</priority>
+  </url>
+  <url><loc>http://example.com/b</loc><lastmod>2024-05</lastmod><priority>1.5</priority></url>
+  <url><loc>http://example.com/c</loc><lastmod>yesterday</lastmod><priority>high</priority></url>
+  <url><loc></loc></url>
+</urlset>`
+	wantUrls := []Url{
+		{Loc: "http://example.com/a", LastMod: time.Date(2024, 5, 6, 7, 8, 9, 0, time.FixedZone("", 8*3600)), ChangeFreq: "daily", Priority: 0.8},
+		{Loc: "http://example.com/b", LastMod: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Priority: 1},
+		{Loc: "http://example.com/c", Priority: DefaultPriority},
+	}
+	tests := []struct {
+		name         string
+		data         string
+		wantUrls     []Url
+		wantSitemaps []string
+	}{
+		{name: "urlset", data: urlset, wantUrls: wantUrls},
+		{name: "gzip", data: gzipped(t, urlset), wantUrls: wantUrls},
+		{
+			name:         "index",
+			data:         `<sitemapindex><sitemap><loc>http://example.com/1.xml</loc></sitemap><sitemap><loc>/2.xml.gz</loc></sitemap></sitemapindex>`,
+			wantSitemaps: []string{"http://example.com/1.xml", "/2.xml.gz"},
+		},
+		{
+			name: "text",
+			data: "\xef\xbb\xbfhttp://example.com/x\r\n\r\n  http://example.com/y  \n",
+			wantUrls: []Url{
+				{Loc: "http://example.com/x", Priority: DefaultPriority},
+				{Loc: "http://example.com/y", Priority: DefaultPriority},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sitemap, err := Parse([]byte(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sitemap.Urls) != len(test.wantUrls) {
+				t.Fatalf("urls %+v, want %+v", sitemap.Urls, test.wantUrls)
+			}
+			for i, u := range sitemap.Urls {
+				want := test.wantUrls[i]
+				if u.Loc != want.Loc || !u.LastMod.Equal(want.LastMod) || u.ChangeFreq != want.ChangeFreq || u.Priority != want.Priority {
+					t.Fatalf("urls[%d] is %+v, want %+v", i, u, want)
+				}
+			}
+			var sitemaps []string
+			for _, u := range sitemap.Sitemaps {
+				sitemaps = append(sitemaps, u.Loc)
+			}
+			if !reflect.DeepEqual(sitemaps, test.wantSitemaps) {
+				t.Fatalf("sitemaps %v, want %v", sitemaps, test.wantSitemaps)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"<html><body></body></html>",
+		"<urlset><url><loc>http://example.com/</loc>",
+		"http://example.com/a\nnot a url\n",
+		"/relative\n",
+		"\x1f\x8b\x08broken",
+	}
+	for _, data := range tests {
+		if sitemap, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", data, sitemap)
+		}
+	}
+}
+
+func TestUrlRequest(t *testing.T) {
+	tests := []struct {
+		url          Url
+		wantPriority int
+		wantMeta     map[string]interface{}
+	}{
+		{
+			url:          Url{Loc: "http://example.com/a", Priority: 0.5},
+			wantPriority: 5,
+			wantMeta:     map[string]interface{}{META_PRIORITY: 0.5},
+		},
+		{
+			url: Url{
+				Loc:        "http://example.com/b",
+				LastMod:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				ChangeFreq: "weekly",
+				Priority:   0.86,
+			},
+			wantPriority: 9,
+			wantMeta: map[string]interface{}{
+				META_PRIORITY:   0.86,
+				META_LASTMOD:    "2024-01-02T03:04:05Z",
+				META_CHANGEFREQ: "weekly",
+			},
+		},
+	}
+	for _, test := range tests {
+		req, err := test.url.Request(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.HttpReq().URL.String() != test.url.Loc || req.Priority() != test.wantPriority {
+			t.Errorf("Request(%s) is %s with priority %d, want priority %d", test.url.Loc, req.HttpReq().URL, req.Priority(), test.wantPriority)
+		}
+		if meta := req.MetaMap(); !reflect.DeepEqual(meta, test.wantMeta) {
+			t.Errorf("Request(%s) meta is %v, want %v", test.url.Loc, meta, test.wantMeta)
+		}
+	}
+}
+
+func TestModifiedSince(t *testing.T) {
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		lastMod time.Time
+		since   time.Time
+		want    bool
+	}{
+		{time.Time{}, day, true},
+		{day, time.Time{}, true},
+		{day, day, true},
+		{day.Add(-time.Second), day, false},
+		{day.Add(time.Second), day, true},
+	}
+	for _, test := range tests {
+		if got := (Url{LastMod: test.lastMod}).ModifiedSince(test.since); got != test.want {
+			t.Errorf("ModifiedSince(%s) with lastmod %s = %v, want %v", test.since, test.lastMod, got, test.want)
+		}
+	}
+}
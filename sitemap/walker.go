@@ -0,0 +1,211 @@
+package sitemap
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fmyxyz/goreptile/seed"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//获取站点地图的函数，返回状态码和内容
+type Fetch func(sitemapUrl *url.URL) (int, []byte, error)
+
+//接收站点地图中的URL，返回false时停止遍历
+type Emit func(entry Url) bool
+
+//站点地图不存在的错误
+type notFoundError struct {
+	sitemapUrl string
+	statusCode int
+}
+
+func (this *notFoundError) Error() string {
+	return fmt.Sprintf("The sitemap is not found! (sitemapUrl=%s, statusCode=%d)", this.sitemapUrl, this.statusCode)
+}
+
+//判断错误是否为站点地图不存在，发现的默认站点地图不存在时通常可以忽略
+func IsNotFound(err error) bool {
+	var notFound *notFoundError
+	return errors.As(err, &notFound)
+}
+
+//站点地图遍历器，递归地遍历站点地图索引，按优先级和修改时间依次交出URL
+type Walker struct {
+	fetch    Fetch
+	maxDepth uint32    //站点地图索引的最大嵌套层数，为0时不展开索引
+	maxUrls  uint64    //最多交出的URL数，为0时不限制
+	since    time.Time //只交出该时间之后修改过的URL和站点地图，为零值时不限制
+
+	visited  map[string]bool
+	sitemaps uint64 //已获取的站点地图数
+	emitted  uint64 //已交出的URL数
+	skipped  uint64 //因修改时间被跳过的URL和站点地图数
+	failed   uint64 //获取或解析失败的站点地图数
+}
+
+func NewWalker(fetch Fetch, maxDepth uint32, maxUrls uint64, since time.Time) *Walker {
+	return &Walker{
+		fetch:    fetch,
+		maxDepth: maxDepth,
+		maxUrls:  maxUrls,
+		since:    since,
+		visited:  make(map[string]bool),
+	}
+}
+
+//遍历站点地图，已遍历过的站点地图不会重复获取
+//返回遍历过程中出现的错误，某个站点地图出错不影响其他站点地图
+func (this *Walker) Walk(sitemapUrls []string, emit Emit) []error {
+	var errs []error
+	for _, sitemapUrl := range sitemapUrls {
+		if !this.walk(sitemapUrl, 0, emit, &errs) {
+			break
+		}
+	}
+	return errs
+}
+
+func (this *Walker) walk(sitemapUrl string, depth uint32, emit Emit, errs *[]error) bool {
+	if this.visited[sitemapUrl] {
+		return true
+	}
+	this.visited[sitemapUrl] = true
+	sitemap, err := this.get(sitemapUrl)
+	if err != nil {
+		atomic.AddUint64(&this.failed, 1)
+		*errs = append(*errs, err)
+		return true
+	}
+	atomic.AddUint64(&this.sitemaps, 1)
+	SortUrls(sitemap.Urls)
+	for _, entry := range sitemap.Urls {
+		if !entry.ModifiedSince(this.since) {
+			atomic.AddUint64(&this.skipped, 1)
+			continue
+		}
+		if this.maxUrls > 0 && atomic.LoadUint64(&this.emitted) >= this.maxUrls {
+			return false
+		}
+		atomic.AddUint64(&this.emitted, 1)
+		if !emit(entry) {
+			return false
+		}
+	}
+	if len(sitemap.Sitemaps) == 0 {
+		return true
+	}
+	if depth >= this.maxDepth {
+		*errs = append(*errs, errors.New(fmt.Sprintf("The sitemap index is nested too deeply! (sitemapUrl=%s, maxDepth=%d)", sitemapUrl, this.maxDepth)))
+		return true
+	}
+	SortUrls(sitemap.Sitemaps)
+	for _, child := range sitemap.Sitemaps {
+		if !child.ModifiedSince(this.since) {
+			atomic.AddUint64(&this.skipped, 1)
+			continue
+		}
+		if !this.walk(this.resolve(sitemapUrl, child.Loc), depth+1, emit, errs) {
+			return false
+		}
+	}
+	return true
+}
+
+//获取并解析站点地图
+func (this *Walker) get(sitemapUrl string) (*Sitemap, error) {
+	u, err := url.Parse(sitemapUrl)
+	if err != nil || !u.IsAbs() {
+		return nil, errors.New(fmt.Sprintf("The sitemap url %q is invalid!", sitemapUrl))
+	}
+	statusCode, body, err := this.fetch(u)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to fetch the sitemap: %s (sitemapUrl=%s)", err, sitemapUrl))
+	}
+	if statusCode == 404 || statusCode == 410 {
+		return nil, &notFoundError{sitemapUrl: sitemapUrl, statusCode: statusCode}
+	}
+	if statusCode < 200 || statusCode >= 300 {
+		return nil, errors.New(fmt.Sprintf("Failed to fetch the sitemap: unexpected status code %d (sitemapUrl=%s)", statusCode, sitemapUrl))
+	}
+	sitemap, err := Parse(body)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s (sitemapUrl=%s)", err, sitemapUrl))
+	}
+	return sitemap, nil
+}
+
+func (this *Walker) resolve(parent string, loc string) string {
+	baseUrl, err := url.Parse(parent)
+	if err != nil {
+		return loc
+	}
+	u, err := baseUrl.Parse(loc)
+	if err != nil {
+		return loc
+	}
+	return u.String()
+}
+
+func (this *Walker) Summary() string {
+	return fmt.Sprintf("sitemaps:%d,urls:%d,skipped:%d,failed:%d",
+		atomic.LoadUint64(&this.sitemaps), atomic.LoadUint64(&this.emitted),
+		atomic.LoadUint64(&this.skipped), atomic.LoadUint64(&this.failed))
+}
+
+//使用 HTTP 客户端获取站点地图
+func HttpFetch(client *http.Client) Fetch {
+	return func(sitemapUrl *url.URL) (int, []byte, error) {
+		httpResp, err := client.Get(sitemapUrl.String())
+		if err != nil {
+			return 0, nil, err
+		}
+		defer httpResp.Body.Close()
+		body, err := ioutil.ReadAll(io.LimitReader(httpResp.Body, MaxSize+1))
+		if err != nil {
+			return 0, nil, err
+		}
+		return httpResp.StatusCode, body, nil
+	}
+}
+
+//以站点地图中的URL为种子的种子来源
+type Source struct {
+	walker      *Walker
+	sitemapUrls []string
+}
+
+//创建站点地图种子来源，参数的含义同 NewWalker
+func NewSource(fetch Fetch, maxDepth uint32, maxUrls uint64, since time.Time, sitemapUrls ...string) *Source {
+	return &Source{
+		walker:      NewWalker(fetch, maxDepth, maxUrls, since),
+		sitemapUrls: sitemapUrls,
+	}
+}
+
+func (this *Source) Seeds(emit seed.Emit) []error {
+	var errs []error
+	walkErrs := this.walker.Walk(this.sitemapUrls, func(entry Url) bool {
+		req, err := entry.Request(0)
+		if err != nil {
+			errs = append(errs, err)
+			return true
+		}
+		return emit(req)
+	})
+	return append(walkErrs, errs...)
+}
+
+//摘要信息
+func (this *Source) Summary() string {
+	return this.walker.Summary()
+}
+
+func (this *Source) String() string {
+	return fmt.Sprintf("sitemaps(%s)", strings.Join(this.sitemapUrls, ","))
+}
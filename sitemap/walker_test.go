@@ -0,0 +1,160 @@
+package sitemap
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//测试用的站点，index.xml 为站点地图索引，nested.xml 是嵌套的索引并且引用了 index.xml
+var testSite = map[string]string{
+	"http://s/index.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>/nested.xml</loc></sitemap>
+  <sitemap><loc>http://s/b.xml</loc><lastmod>2020-01-01</lastmod></sitemap>
+  <sitemap><loc>/a.xml</loc><lastmod>2024-01-01T00:00:00Z</lastmod></sitemap>
+</sitemapindex>`,
+	"http://s/a.xml": `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://s/a1</loc><priority>0.2</priority></url>
+  <url><loc>http://s/a2</loc><priority>0.9</priority></url>
+  <url><loc>http://s/a3</loc><lastmod>2019-01-01</lastmod></url>
+</urlset>`,
+	"http://s/b.xml": `<urlset><url><loc>http://s/b1</loc></url></urlset>`,
+	"http://s/nested.xml": `<sitemapindex>
+  <sitemap><loc>c.txt</loc></sitemap>
+  <sitemap><loc>http://s/index.xml</loc></sitemap>
+</sitemapindex>`,
+	"http://s/c.txt": "http://s/c1\nhttp://s/c2\n",
+}
+
+func TestWalker(t *testing.T) {
+	since := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		maxDepth     uint32
+		maxUrls      uint64
+		since        time.Time
+		sitemapUrls  []string
+		wantUrls     []string
+		wantFetched  []string
+		wantErrs     int
+		wantNotFound bool
+		wantSummary  string
+	}{
+		{
+			name:        "all",
+			maxDepth:    2,
+			sitemapUrls: []string{"http://s/index.xml"},
+			wantUrls:    []string{"http://s/a2", "http://s/a3", "http://s/a1", "http://s/b1", "http://s/c1", "http://s/c2"},
+			wantFetched: []string{"http://s/index.xml", "http://s/a.xml", "http://s/b.xml", "http://s/nested.xml", "http://s/c.txt"},
+			wantSummary: "sitemaps:5,urls:6,skipped:0,failed:0",
+		},
+		{
+			name:        "since",
+			maxDepth:    2,
+			since:       since,
+			sitemapUrls: []string{"http://s/index.xml"},
+			wantUrls:    []string{"http://s/a2", "http://s/a1", "http://s/c1", "http://s/c2"},
+			wantFetched: []string{"http://s/index.xml", "http://s/a.xml", "http://s/nested.xml", "http://s/c.txt"},
+			wantSummary: "sitemaps:4,urls:4,skipped:2,failed:0",
+		},
+		{
+			name:        "max urls",
+			maxDepth:    2,
+			maxUrls:     2,
+			sitemapUrls: []string{"http://s/index.xml", "http://s/b.xml"},
+			wantUrls:    []string{"http://s/a2", "http://s/a3"},
+			wantFetched: []string{"http://s/index.xml", "http://s/a.xml"},
+			wantSummary: "sitemaps:2,urls:2,skipped:0,failed:0",
+		},
+		{
+			name:        "max urls with since",
+			maxDepth:    2,
+			maxUrls:     3,
+			since:       since,
+			sitemapUrls: []string{"http://s/index.xml"},
+			wantUrls:    []string{"http://s/a2", "http://s/a1", "http://s/c1"},
+			wantFetched: []string{"http://s/index.xml", "http://s/a.xml", "http://s/nested.xml", "http://s/c.txt"},
+			wantSummary: "sitemaps:4,urls:3,skipped:2,failed:0",
+		},
+		{
+			name:        "nested too deeply",
+			maxDepth:    1,
+			sitemapUrls: []string{"http://s/index.xml"},
+			wantUrls:    []string{"http://s/a2", "http://s/a3", "http://s/a1", "http://s/b1"},
+			wantFetched: []string{"http://s/index.xml", "http://s/a.xml", "http://s/b.xml", "http://s/nested.xml"},
+			wantErrs:    1,
+			wantSummary: "sitemaps:4,urls:4,skipped:0,failed:0",
+		},
+		{
+			name:        "index not expanded",
+			maxDepth:    0,
+			sitemapUrls: []string{"http://s/index.xml"},
+			wantFetched: []string{"http://s/index.xml"},
+			wantErrs:    1,
+			wantSummary: "sitemaps:1,urls:0,skipped:0,failed:0",
+		},
+		{
+			name:         "not found",
+			maxDepth:     2,
+			sitemapUrls:  []string{"http://s/missing.xml", "http://s/b.xml", "http://s/b.xml"},
+			wantUrls:     []string{"http://s/b1"},
+			wantFetched:  []string{"http://s/missing.xml", "http://s/b.xml"},
+			wantErrs:     1,
+			wantNotFound: true,
+			wantSummary:  "sitemaps:1,urls:1,skipped:0,failed:1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fetched []string
+			fetch := func(sitemapUrl *url.URL) (int, []byte, error) {
+				fetched = append(fetched, sitemapUrl.String())
+				body, ok := testSite[sitemapUrl.String()]
+				if !ok {
+					return 404, nil, nil
+				}
+				return 200, []byte(body), nil
+			}
+			walker := NewWalker(fetch, test.maxDepth, test.maxUrls, test.since)
+			var urls []string
+			errs := walker.Walk(test.sitemapUrls, func(entry Url) bool {
+				urls = append(urls, entry.Loc)
+				return true
+			})
+			if !reflect.DeepEqual(urls, test.wantUrls) {
+				t.Fatalf("urls %v, want %v", urls, test.wantUrls)
+			}
+			if !reflect.DeepEqual(fetched, test.wantFetched) {
+				t.Fatalf("fetched %v, want %v", fetched, test.wantFetched)
+			}
+			if len(errs) != test.wantErrs {
+				t.Fatalf("errors %v, want %d errors", errs, test.wantErrs)
+			}
+			if test.wantNotFound && !IsNotFound(errs[0]) {
+				t.Fatalf("IsNotFound(%v) = false", errs[0])
+			}
+			if summary := walker.Summary(); summary != test.wantSummary {
+				t.Fatalf("Summary() = %s, want %s", summary, test.wantSummary)
+			}
+		})
+	}
+}
+
+func TestWalkerStop(t *testing.T) {
+	var fetched int
+	fetch := func(sitemapUrl *url.URL) (int, []byte, error) {
+		fetched++
+		return 200, []byte(testSite[sitemapUrl.String()]), nil
+	}
+	walker := NewWalker(fetch, 2, 0, time.Time{})
+	var urls []string
+	walker.Walk([]string{"http://s/index.xml"}, func(entry Url) bool {
+		urls = append(urls, entry.Loc)
+		return len(urls) < 4
+	})
+	if len(urls) != 4 || fetched != 3 {
+		t.Fatalf("%d urls after %d fetches, want 4 after 3", len(urls), fetched)
+	}
+}
@@ -63,7 +63,10 @@ func checkStatus(
 		var idleCount uint
 		var firstIdleTime time.Time
 		for {
-			if scheduler.Idle() {
+			//暂停期间各模块都是空闲的，不计入空闲计数
+			if scheduler.Paused() {
+				idleCount = 0
+			} else if scheduler.Idle() {
 				idleCount++
 				if idleCount == 1 {
 					firstIdleTime = time.Now()
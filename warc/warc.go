@@ -0,0 +1,381 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const version = "WARC/1.0"
+
+//记录类型
+const (
+	TYPE_WARCINFO = "warcinfo"
+	TYPE_RESPONSE = "response"
+	TYPE_REQUEST  = "request"
+)
+
+//记录头中的一个字段
+type Field struct {
+	Name  string
+	Value string
+}
+
+//记录头，保持字段的顺序
+type Header []Field
+
+//获取字段的值，字段名不区分大小写
+func (this Header) Get(name string) string {
+	for _, field := range this {
+		if strings.EqualFold(field.Name, name) {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+//设置字段的值，已存在时替换
+func (this *Header) Set(name, value string) {
+	for i, field := range *this {
+		if strings.EqualFold(field.Name, name) {
+			(*this)[i].Value = value
+			return
+		}
+	}
+	*this = append(*this, Field{Name: name, Value: value})
+}
+
+//WARC记录
+type Record struct {
+	Header  Header
+	Content []byte
+}
+
+//创建记录，自动生成记录ID和日期
+func NewRecord(recordType string, content []byte) *Record {
+	record := &Record{Content: content}
+	record.Header.Set("WARC-Type", recordType)
+	record.Header.Set("WARC-Record-ID", NewRecordId())
+	record.Header.Set("WARC-Date", time.Now().UTC().Format(time.RFC3339))
+	return record
+}
+
+//记录类型
+func (this *Record) Type() string {
+	return this.Header.Get("WARC-Type")
+}
+
+//记录ID
+func (this *Record) Id() string {
+	return this.Header.Get("WARC-Record-ID")
+}
+
+//生成记录ID，格式为 <urn:uuid:...>
+func NewRecordId() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+//按 WARC 格式写出记录
+func (this *Record) WriteTo(w io.Writer) (int64, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString(version)
+	buffer.WriteString("\r\n")
+	digest := sha1.Sum(this.Content)
+	this.Header.Set("WARC-Block-Digest", "sha1:"+base32.StdEncoding.EncodeToString(digest[:]))
+	this.Header.Set("Content-Length", strconv.Itoa(len(this.Content)))
+	for _, field := range this.Header {
+		buffer.WriteString(field.Name)
+		buffer.WriteString(": ")
+		buffer.WriteString(field.Value)
+		buffer.WriteString("\r\n")
+	}
+	buffer.WriteString("\r\n")
+	buffer.Write(this.Content)
+	buffer.WriteString("\r\n\r\n")
+	return buffer.WriteTo(w)
+}
+
+//WARC文件写入器，文件达到最大长度后切换到新文件
+//每个文件以 warcinfo 记录开始
+type Writer struct {
+	dir      string
+	prefix   string
+	maxSize  int64
+	compress bool //每条记录单独压缩为一个 gzip 成员
+	file     *os.File
+	size     int64
+	serial   int
+	mutex    sync.Mutex
+}
+
+//创建WARC文件写入器
+//文件名为 prefix-时间-序号.warc，compress 为true时扩展名为 .warc.gz
+func NewWriter(dir, prefix string, maxSize int64, compress bool) (*Writer, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("The max size of warc file must be positive!")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Writer{dir: dir, prefix: prefix, maxSize: maxSize, compress: compress}, nil
+}
+
+func (this *Writer) rotate() error {
+	if this.file != nil {
+		if err := this.file.Close(); err != nil {
+			return err
+		}
+		this.file = nil
+	}
+	//续爬时新的写入器的序号从1开始，同一秒内的文件名可能已经存在，此时使用下一个序号
+	var name string
+	var file *os.File
+	for {
+		this.serial++
+		name = fmt.Sprintf("%s-%s-%05d.warc", this.prefix, time.Now().UTC().Format("20060102150405"), this.serial)
+		if this.compress {
+			name += ".gz"
+		}
+		var err error
+		file, err = os.OpenFile(filepath.Join(this.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+	this.file = file
+	this.size = 0
+	info := NewRecord(TYPE_WARCINFO, []byte("software: goreptile\r\nformat: WARC File Format 1.0\r\n"))
+	info.Header.Set("WARC-Filename", name)
+	info.Header.Set("Content-Type", "application/warc-fields")
+	return this.write(info)
+}
+
+func (this *Writer) write(record *Record) error {
+	var w io.Writer = this.file
+	var gz *gzip.Writer
+	counter := &countingWriter{w: this.file}
+	w = counter
+	if this.compress {
+		gz = gzip.NewWriter(counter)
+		w = gz
+	}
+	if _, err := record.WriteTo(w); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	this.size += counter.n
+	return nil
+}
+
+//写入一组记录，同一组记录总是写入同一个文件
+func (this *Writer) WriteRecords(records ...*Record) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.file == nil || this.size >= this.maxSize {
+		if err := this.rotate(); err != nil {
+			return err
+		}
+	}
+	for _, record := range records {
+		if err := this.write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *Writer) Close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.file == nil {
+		return nil
+	}
+	err := this.file.Close()
+	this.file = nil
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (this *countingWriter) Write(p []byte) (int, error) {
+	n, err := this.w.Write(p)
+	this.n += int64(n)
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (this *countingReader) Read(p []byte) (int, error) {
+	n, err := this.r.Read(p)
+	this.n += int64(n)
+	return n, err
+}
+
+//WARC文件读取器，支持未压缩和按记录 gzip 压缩的文件
+type Reader struct {
+	counter *countingReader
+	br      *bufio.Reader
+	gzipped bool
+	gz      *gzip.Reader
+	offset  int64 //当前记录在文件中的偏移量
+}
+
+//创建WARC文件读取器，自动识别 gzip 压缩
+func NewReader(r io.Reader) (*Reader, error) {
+	counter := &countingReader{r: r}
+	br := bufio.NewReader(counter)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return &Reader{
+		counter: counter,
+		br:      br,
+		gzipped: len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b,
+	}, nil
+}
+
+//下一条记录在文件中的偏移量，可用于 ReadRecordAt
+func (this *Reader) Offset() int64 {
+	return this.offset
+}
+
+//读取下一条记录，没有更多记录时返回 io.EOF
+//读取后 Offset 返回该记录的偏移量
+func (this *Reader) Next() (*Record, error) {
+	offset := this.counter.n - int64(this.br.Buffered())
+	if !this.gzipped {
+		if err := skipBlankLines(this.br); err != nil {
+			return nil, err
+		}
+		offset = this.counter.n - int64(this.br.Buffered())
+		record, err := readRecord(this.br)
+		if err == nil {
+			this.offset = offset
+		}
+		return record, err
+	}
+	if _, err := this.br.Peek(1); err != nil {
+		return nil, err
+	}
+	if this.gz == nil {
+		gz, err := gzip.NewReader(this.br)
+		if err != nil {
+			return nil, err
+		}
+		this.gz = gz
+	} else if err := this.gz.Reset(this.br); err != nil {
+		return nil, err
+	}
+	this.gz.Multistream(false)
+	member := bufio.NewReader(this.gz)
+	if err := skipBlankLines(member); err != nil {
+		return nil, err
+	}
+	record, err := readRecord(member)
+	if err != nil {
+		return nil, err
+	}
+	//读完当前 gzip 成员，定位到下一个成员
+	io.Copy(ioutil.Discard, member)
+	this.offset = offset
+	return record, nil
+}
+
+func skipBlankLines(br *bufio.Reader) error {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return err
+		}
+		if b[0] != '\r' && b[0] != '\n' {
+			return nil
+		}
+		br.ReadByte()
+	}
+}
+
+func readRecord(br *bufio.Reader) (*Record, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line == "" {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if !strings.HasPrefix(strings.TrimSpace(line), "WARC/") {
+		return nil, errors.New(fmt.Sprintf("Invalid warc record version line : %q", line))
+	}
+	record := &Record{}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil, errors.New(fmt.Sprintf("Invalid warc header line : %q", line))
+		}
+		record.Header = append(record.Header, Field{Name: strings.TrimSpace(kv[0]), Value: strings.TrimSpace(kv[1])})
+	}
+	length, err := strconv.ParseInt(record.Header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return nil, errors.New("Invalid warc record Content-Length!")
+	}
+	record.Content = make([]byte, length)
+	if _, err := io.ReadFull(br, record.Content); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+//读取文件中指定偏移量处的一条记录
+func ReadRecordAt(path string, offset int64) (*Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	reader, err := NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	return reader.Next()
+}
@@ -0,0 +1,168 @@
+package warc
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "warc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+//按文件名顺序读取目录中所有文件的记录，并检查偏移量可以重新读取同一记录
+func readDir(t *testing.T, dir string) (files []string, records []*Record) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		files = append(files, info.Name())
+	}
+	sort.Strings(files)
+	for _, name := range files {
+		path := filepath.Join(dir, name)
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; ; i++ {
+			record, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: record %d: %s", name, i, err)
+			}
+			//每个文件以 warcinfo 记录开始
+			if (i == 0) != (record.Type() == TYPE_WARCINFO) {
+				t.Fatalf("%s: record %d is a %s record", name, i, record.Type())
+			}
+			again, err := ReadRecordAt(path, reader.Offset())
+			if err != nil {
+				t.Fatalf("%s: ReadRecordAt(%d): %s", name, reader.Offset(), err)
+			}
+			if again.Id() != record.Id() {
+				t.Fatalf("%s: ReadRecordAt(%d) read %s, want %s", name, reader.Offset(), again.Id(), record.Id())
+			}
+			if record.Type() != TYPE_WARCINFO {
+				records = append(records, record)
+			}
+		}
+		file.Close()
+	}
+	return files, records
+}
+
+func TestWriterReaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		compress  bool
+		maxSize   int64
+		groups    int
+		wantFiles int
+	}{
+		{name: "plain", compress: false, maxSize: 1 << 20, groups: 5, wantFiles: 1},
+		{name: "gzip", compress: true, maxSize: 1 << 20, groups: 5, wantFiles: 1},
+		{name: "plain rotated", compress: false, maxSize: 1, groups: 3, wantFiles: 3},
+		{name: "gzip rotated", compress: true, maxSize: 1, groups: 3, wantFiles: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+			writer, err := NewWriter(dir, "test", test.maxSize, test.compress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []*Record
+			for i := 0; i < test.groups; i++ {
+				request := NewRecord(TYPE_REQUEST, []byte(fmt.Sprintf("GET /%d HTTP/1.1\r\nHost: example.com\r\n\r\n", i)))
+				response := NewRecord(TYPE_RESPONSE, []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\n\r\nbody %d\r\n\r\n", i)))
+				response.Header.Set("WARC-Target-URI", fmt.Sprintf("http://example.com/%d", i))
+				response.Header.Set("WARC-Concurrent-To", request.Id())
+				if err := writer.WriteRecords(request, response); err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, request, response)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+			files, got := readDir(t, dir)
+			if len(files) != test.wantFiles {
+				t.Fatalf("%d files %v, want %d", len(files), files, test.wantFiles)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("read %d records, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i].Id() != want[i].Id() || got[i].Type() != want[i].Type() || string(got[i].Content) != string(want[i].Content) {
+					t.Fatalf("record %d is %s %s %q, want %s %s %q", i,
+						got[i].Type(), got[i].Id(), got[i].Content, want[i].Type(), want[i].Id(), want[i].Content)
+				}
+				for _, name := range []string{"WARC-Target-URI", "WARC-Concurrent-To", "WARC-Date", "WARC-Block-Digest"} {
+					if got[i].Header.Get(name) != want[i].Header.Get(name) {
+						t.Fatalf("record %d %s is %q, want %q", i, name, got[i].Header.Get(name), want[i].Header.Get(name))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWriterResume(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	//续爬时同一秒内创建的写入器序号都从1开始，文件名不能冲突
+	for i := 0; i < 3; i++ {
+		writer, err := NewWriter(dir, "test", 1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 2; j++ {
+			if err := writer.WriteRecords(NewRecord(TYPE_RESPONSE, []byte(fmt.Sprintf("%d-%d", i, j)))); err != nil {
+				t.Fatalf("writer %d: %s", i, err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, records := readDir(t, dir)
+	if len(files) != 6 || len(records) != 6 {
+		t.Fatalf("%d files and %d records, want 6 and 6", len(files), len(records))
+	}
+}
+
+func TestReaderInvalid(t *testing.T) {
+	tests := []string{
+		"HTTP/1.1 200 OK\r\n\r\n",
+		"WARC/1.0\r\nbroken header\r\n\r\n",
+		"WARC/1.0\r\nContent-Length: x\r\n\r\n",
+		"WARC/1.0\r\nContent-Length: 10\r\n\r\nshort",
+	}
+	for _, data := range tests {
+		dir, cleanup := tempDir(t)
+		path := filepath.Join(dir, "bad.warc")
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadRecordAt(path, 0); err == nil || err == io.EOF {
+			t.Errorf("ReadRecordAt(%q) returned %v, want an error", data, err)
+		}
+		cleanup()
+	}
+}